@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"harmony/internal/database"
+	"harmony/internal/migrations"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up | down [steps] | status")
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	// Ctrl+C cancels, an interrupted migration is not recorded and runs again the next time
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db := database.New()
+	defer db.Mongo.Disconnect(context.Background())
+
+	migrator, err := database.NewMigrator(db.Mongo, migrations.All())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			log.Printf("migration %d %s applied", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Println("nothing to migrate")
+		}
+
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			steps, err = strconv.Atoi(flag.Arg(1))
+			if err != nil || steps < 1 {
+				usage()
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			log.Printf("migration %d %s reverted", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Failed to revert: %v", err)
+		}
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+		for _, migration := range status {
+			appliedAt := "pending"
+			if migration.AppliedAt != nil {
+				appliedAt = migration.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-24s %s\n", migration.Version, migration.Name, appliedAt)
+		}
+
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,214 @@
+package autentication
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// keysDir is AUTH_KEYS_DIR, the directory of the .pem signing keys. It has to be set in production:
+	// without it a key is generated at every start, the tokens of the other instances are refused and
+	// every user is logged out on a restart
+	keysDir = os.Getenv("AUTH_KEYS_DIR")
+	// signingKid is AUTH_SIGNING_KID, the kid of the key signing the new tokens
+	signingKid = os.Getenv("AUTH_SIGNING_KID")
+)
+
+// key is a signing key, private is nil for retired keys kept only to verify the tokens still around
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeyManager signs Harmony tokens and verifies them by kid. Rotating means adding the new key
+// to the directory, pointing AUTH_SIGNING_KID to it and removing the old one once its tokens expired.
+type KeyManager struct {
+	keys    map[string]*key
+	signing *key
+}
+
+// NewKeyManager loads every .pem file of dir, the file name is the kid. RSA keys sign with RS256
+// and Ed25519 keys with EdDSA, a PUBLIC KEY file is only used to verify. The signing key is
+// signingKid or, when empty, the last kid in lexical order.
+func NewKeyManager(dir string, signingKid string) (*KeyManager, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	km := &KeyManager{keys: map[string]*key{}}
+	for _, path := range paths {
+		k, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		km.keys[k.id] = k
+		if k.private != nil && (signingKid == "" || signingKid == k.id) {
+			km.signing = k
+		}
+	}
+
+	if km.signing == nil {
+		if signingKid != "" {
+			return nil, fmt.Errorf("signing key %q not found in %s", signingKid, dir)
+		}
+		return nil, fmt.Errorf("no private key found in %s", dir)
+	}
+
+	return km, nil
+}
+
+// NewEphemeralKeyManager generates an Ed25519 key in memory, tokens don't survive a restart
+func NewEphemeralKeyManager() (*KeyManager, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &key{
+		id:      fmt.Sprintf("ephemeral-%x", public[:4]),
+		method:  jwt.SigningMethodEdDSA,
+		private: private,
+		public:  public,
+	}
+	return &KeyManager{keys: map[string]*key{k.id: k}, signing: k}, nil
+}
+
+func newKeyManager() *KeyManager {
+	if keysDir == "" {
+		log.Println("WARNING: AUTH_KEYS_DIR is not set, signing tokens with an ephemeral key. " +
+			"The tokens are invalidated by a restart and refused by the other instances, set AUTH_KEYS_DIR outside development")
+		km, err := NewEphemeralKeyManager()
+		if err != nil {
+			log.Fatalf("Failed to generate signing key: %v", err)
+		}
+		return km
+	}
+
+	km, err := NewKeyManager(keysDir, signingKid)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	return km
+}
+
+func loadKey(path string) (*key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	k := &key{id: strings.TrimSuffix(filepath.Base(path), ".pem")}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodRS256, v, &v.PublicKey
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodEdDSA, v, v.Public()
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, v
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, v
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return k, nil
+}
+
+// Sign signs the claims with the current signing key
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(km.signing.method, claims)
+	token.Header["kid"] = km.signing.id
+	return token.SignedString(km.signing.private)
+}
+
+// Keyfunc resolves the verification key from the kid of the token header
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("missing kid")
+	}
+	k, ok := km.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+	}
+	return k.public, nil
+}
+
+// Methods lists the algorithms tokens can be signed with
+func (km *KeyManager) Methods() []string {
+	return []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+}
+
+// JWKS returns the public keys in the JSON Web Key Set format
+func (km *KeyManager) JWKS() map[string]any {
+	kids := make([]string, 0, len(km.keys))
+	for kid := range km.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]map[string]any, 0, len(kids))
+	for _, kid := range kids {
+		k := km.keys[kid]
+		jwk := map[string]any{
+			"kid": k.id,
+			"use": "sig",
+			"alg": k.method.Alg(),
+		}
+		switch public := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+
+	return map[string]any{"keys": keys}
+}
@@ -2,46 +2,121 @@ package autentication
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	_ "github.com/joho/godotenv/autoload"
 	"golang.org/x/oauth2"
 )
 
+// DefaultProvider is the provider name used when only the AUTH_* variables are set
+const DefaultProvider = "default"
+
 type Service struct {
+	// Providers are the OIDC providers users can log in with, by name
+	Providers map[string]*Provider
+	// Default is the provider behind /login and /callback
+	Default *Provider
+	// Local enables the mail and password login, for deployments without an OIDC provider
+	Local bool
+	// Audience is the aud of the tokens Harmony signs
+	Audience string
+	Keys     *KeyManager
+}
+
+type Provider struct {
+	Name         string
 	Oauth2Config *oauth2.Config
 	OidcVerifier *oidc.IDTokenVerifier
 }
 
 var (
-	clientID     = os.Getenv("AUTH_CLIENT_ID")
-	clientSecret = os.Getenv("AUTH_CLIENT_SECRET")
-	redirectURL  = os.Getenv("AUTH_REDIRECT_URL")
-	providerURL  = os.Getenv("AUTH_PROVIDER_URL")
+	providers   = os.Getenv("AUTH_PROVIDERS")
+	providerURL = os.Getenv("AUTH_PROVIDER_URL")
+	audience    = os.Getenv("AUTH_AUDIENCE")
+	local       = os.Getenv("AUTH_LOCAL")
 )
 
+// New configures the providers listed in AUTH_PROVIDERS (e.g. "corp,google"), each one reads
+// AUTH_<NAME>_CLIENT_ID, AUTH_<NAME>_CLIENT_SECRET, AUTH_<NAME>_REDIRECT_URL and AUTH_<NAME>_PROVIDER_URL.
+// Without AUTH_PROVIDERS a single provider is read from the unprefixed variables.
+// With AUTH_LOCAL=true the providers are optional.
 func New() Service {
+	service := Service{
+		Providers: map[string]*Provider{},
+		Local:     local == "true",
+		Audience:  audience,
+		Keys:      newKeyManager(),
+	}
+	if service.Audience == "" {
+		service.Audience = "harmony"
+	}
+
+	if providers == "" {
+		if providerURL == "" {
+			if !service.Local {
+				log.Fatalf("No provider configured, set AUTH_PROVIDER_URL, AUTH_PROVIDERS or AUTH_LOCAL=true")
+			}
+			return service
+		}
+		service.Default = newProvider(DefaultProvider, "AUTH_")
+		service.Providers[DefaultProvider] = service.Default
+		return service
+	}
+
+	for _, name := range strings.Split(providers, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		provider := newProvider(name, fmt.Sprintf("AUTH_%s_", strings.ToUpper(name)))
+		service.Providers[name] = provider
+		if service.Default == nil {
+			service.Default = provider
+		}
+	}
+	if service.Default == nil {
+		log.Fatalf("No provider in AUTH_PROVIDERS")
+	}
+
+	return service
+}
+
+func newProvider(name string, prefix string) *Provider {
 	ctx := context.Background()
 
-	providerInternal, err := oidc.NewProvider(ctx, providerURL)
+	clientID := os.Getenv(prefix + "CLIENT_ID")
+
+	providerInternal, err := oidc.NewProvider(ctx, os.Getenv(prefix+"PROVIDER_URL"))
 	if err != nil {
-		log.Fatalf("Failed to get provider: %v", err)
+		log.Fatalf("Failed to get provider %s: %v", name, err)
 	}
 
 	oauth2Config := &oauth2.Config{
 		ClientID:     clientID,
-		ClientSecret: clientSecret,
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
 		Endpoint:     providerInternal.Endpoint(),
-		RedirectURL:  redirectURL,
+		RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
 		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
 	}
 
 	oidcVerifier := providerInternal.Verifier(&oidc.Config{ClientID: clientID})
 
-	return Service{
+	return &Provider{
+		Name:         name,
 		Oauth2Config: oauth2Config,
 		OidcVerifier: oidcVerifier,
 	}
 }
+
+// Provider returns the provider by name, an empty name is the default one
+func (s *Service) Provider(name string) (*Provider, bool) {
+	if name == "" {
+		return s.Default, s.Default != nil
+	}
+	provider, ok := s.Providers[name]
+	return provider, ok
+}
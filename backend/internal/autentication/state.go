@@ -0,0 +1,99 @@
+package autentication
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"harmony/utils"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// LoginStateTTL is how long the user has to complete the login at the provider
+const LoginStateTTL = 10 * time.Minute
+
+// loginStateAudience keeps the login state from being accepted as an access token
+const loginStateAudience = "harmony-login-state"
+
+// LoginState is what the callback needs to check the login it receives is the one it started
+type LoginState struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+	Redirect string
+	// LinkUser is set when a logged in user adds the identity to its account
+	LinkUser string
+}
+
+func NewLoginState(provider string, redirect string) LoginState {
+	return LoginState{
+		Provider: provider,
+		State:    utils.GetRandomToken(16),
+		Nonce:    utils.GetRandomToken(16),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: redirect,
+	}
+}
+
+// AuthCodeURL is the provider login url carrying the state, the nonce and the PKCE challenge
+func (p *Provider) AuthCodeURL(ls LoginState) string {
+	return p.Oauth2Config.AuthCodeURL(ls.State,
+		oauth2.AccessTypeOffline,
+		oidc.Nonce(ls.Nonce),
+		oauth2.S256ChallengeOption(ls.Verifier),
+	)
+}
+
+// Exchange trades the code for the provider tokens proving it started the login with the verifier
+func (p *Provider) Exchange(ctx context.Context, ls LoginState, code string) (*oauth2.Token, error) {
+	return p.Oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(ls.Verifier))
+}
+
+// EncodeLoginState signs the state so it can be kept in a cookie
+func (s *Service) EncodeLoginState(ls LoginState) (string, error) {
+	now := time.Now()
+	return s.Keys.Sign(jwt.MapClaims{
+		"provider": ls.Provider,
+		"state":    ls.State,
+		"nonce":    ls.Nonce,
+		"verifier": ls.Verifier,
+		"redirect": ls.Redirect,
+		"link":     ls.LinkUser,
+		"aud":      loginStateAudience,
+		"exp":      now.Add(LoginStateTTL).Unix(),
+		"iat":      now.Unix(),
+	})
+}
+
+func (s *Service) DecodeLoginState(value string) (LoginState, error) {
+	token, err := jwt.Parse(value, s.Keys.Keyfunc,
+		jwt.WithValidMethods(s.Keys.Methods()),
+		jwt.WithAudience(loginStateAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return LoginState{}, errors.New("invalid login state")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return LoginState{}, errors.New("invalid login state")
+	}
+
+	var ls LoginState
+	ls.Provider, _ = claims["provider"].(string)
+	ls.State, _ = claims["state"].(string)
+	ls.Nonce, _ = claims["nonce"].(string)
+	ls.Verifier, _ = claims["verifier"].(string)
+	ls.Redirect, _ = claims["redirect"].(string)
+	ls.LinkUser, _ = claims["link"].(string)
+	if ls.Provider == "" || ls.State == "" || ls.Nonce == "" || ls.Verifier == "" {
+		return LoginState{}, errors.New("invalid login state")
+	}
+
+	return ls, nil
+}
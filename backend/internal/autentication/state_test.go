@@ -0,0 +1,144 @@
+package autentication
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	keys, err := NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return &Service{Audience: "harmony", Keys: keys}
+}
+
+func TestLoginStateRoundTrip(t *testing.T) {
+	s := newTestService(t)
+
+	ls := NewLoginState("corp", "http://localhost:5173/app")
+	ls.LinkUser = "alice"
+	value, err := s.EncodeLoginState(ls)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	decoded, err := s.DecodeLoginState(value)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded != ls {
+		t.Fatalf("expected %+v, got %+v", ls, decoded)
+	}
+
+	// every login gets its own secrets
+	other := NewLoginState("corp", "")
+	if other.State == ls.State || other.Nonce == ls.Nonce || other.Verifier == ls.Verifier {
+		t.Fatal("expected new state, nonce and verifier")
+	}
+}
+
+func TestLoginStateRejected(t *testing.T) {
+	s := newTestService(t)
+	ls := NewLoginState("corp", "")
+	now := time.Now()
+
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"provider": ls.Provider,
+			"state":    ls.State,
+			"nonce":    ls.Nonce,
+			"verifier": ls.Verifier,
+			"aud":      loginStateAudience,
+			"exp":      now.Add(LoginStateTTL).Unix(),
+			"iat":      now.Unix(),
+		}
+		change(c)
+		return c
+	}
+	sign := func(c jwt.MapClaims) string {
+		value, err := s.Keys.Sign(c)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return value
+	}
+	valid, err := s.EncodeLoginState(ls)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	parts := strings.Split(valid, ".")
+
+	other := newTestService(t)
+	foreign, err := other.EncodeLoginState(ls)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		value string
+	}{
+		{"empty", ""},
+		{"tampered", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"provider":"other"}`)) + "." + parts[2]},
+		{"other key", foreign},
+		{"expired", sign(claims(func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }))},
+		{"no expiration", sign(claims(func(c jwt.MapClaims) { delete(c, "exp") }))},
+		{"access token", sign(claims(func(c jwt.MapClaims) { c["aud"] = s.Audience }))},
+		{"no provider", sign(claims(func(c jwt.MapClaims) { delete(c, "provider") }))},
+		{"no state", sign(claims(func(c jwt.MapClaims) { delete(c, "state") }))},
+		{"no nonce", sign(claims(func(c jwt.MapClaims) { c["nonce"] = "" }))},
+		{"no verifier", sign(claims(func(c jwt.MapClaims) { delete(c, "verifier") }))},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := s.DecodeLoginState(tc.value); err == nil {
+				t.Fatal("expected the login state to be rejected")
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := &Provider{
+		Name: "corp",
+		Oauth2Config: &oauth2.Config{
+			ClientID: "harmony",
+			Endpoint: oauth2.Endpoint{AuthURL: "https://idp.example.com/auth"},
+		},
+	}
+	ls := NewLoginState(p.Name, "")
+
+	target, err := url.Parse(p.AuthCodeURL(ls))
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+	query := target.Query()
+
+	if query.Get("state") != ls.State {
+		t.Fatalf("expected state %q, got %q", ls.State, query.Get("state"))
+	}
+	if query.Get("nonce") != ls.Nonce {
+		t.Fatalf("expected nonce %q, got %q", ls.Nonce, query.Get("nonce"))
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected S256 challenge, got %q", query.Get("code_challenge_method"))
+	}
+	sum := sha256.Sum256([]byte(ls.Verifier))
+	if query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Fatal("expected the challenge of the verifier")
+	}
+	// only the challenge leaves the backend, the verifier is sent with the code exchange
+	if strings.Contains(target.String(), ls.Verifier) {
+		t.Fatal("expected the verifier to stay secret")
+	}
+}
@@ -34,6 +34,13 @@ func New() Service {
 }
 
 func (s *Service) Health() map[string]string {
+	// running with the in-memory storage
+	if s.Mongo == nil {
+		return map[string]string{
+			"message": "It's healthy",
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
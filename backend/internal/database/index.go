@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var duplicateKeyIndex = regexp.MustCompile(`index: (\S+) dup key`)
+
+type indexSpec struct {
+	Name                    string `bson:"name"`
+	Key                     bson.D `bson:"key"`
+	Unique                  bool   `bson:"unique"`
+	Sparse                  bool   `bson:"sparse"`
+	ExpireAfterSeconds      *int32 `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.M `bson:"partialFilterExpression"`
+}
+
+// EnsureIndexes creates the declared indexes that are missing, the ones already there are left as they are.
+// An index on the same keys with other options is an error: dropping it here would leave the other
+// instances starting together without it, changing one is the job of a migration.
+// Indexes that are not declared are left alone
+func EnsureIndexes(ctx context.Context, c *mongo.Collection, models []mongo.IndexModel) error {
+	cursor, err := c.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	existing := []indexSpec{}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+	byKeys := make(map[string]indexSpec, len(existing))
+	for _, spec := range existing {
+		byKeys[keysName(spec.Key)] = spec
+	}
+
+	missing := []mongo.IndexModel{}
+	for _, model := range models {
+		want, err := declaredSpec(model)
+		if err != nil {
+			return err
+		}
+
+		current, exists := byKeys[keysName(want.Key)]
+		if !exists {
+			missing = append(missing, model)
+			continue
+		}
+		if !sameOptions(current, want) {
+			return fmt.Errorf("index %s of %s exists with other options than declared, a migration has to replace it", current.Name, c.Name())
+		}
+	}
+
+	// one at a time so the error names the index, e.g. the unique one existing duplicates prevent
+	for _, model := range missing {
+		if _, err := c.Indexes().CreateOne(ctx, model); err != nil {
+			spec, _ := declaredSpec(model)
+			return fmt.Errorf("index %s of %s: %w", keysName(spec.Key), c.Name(), err)
+		}
+	}
+	return nil
+}
+
+// DuplicateKeyIndex tells if err is a duplicate key error and the name of the unique index it hit,
+// so repositories can return the error of the field that is taken
+func DuplicateKeyIndex(err error) (string, bool) {
+	if !mongo.IsDuplicateKeyError(err) {
+		return "", false
+	}
+	match := duplicateKeyIndex.FindStringSubmatch(err.Error())
+	if match == nil {
+		return "", true
+	}
+	return match[1], true
+}
+
+// declaredSpec reads the model like the server would list it once created
+func declaredSpec(model mongo.IndexModel) (indexSpec, error) {
+	spec := indexSpec{}
+
+	raw, err := bson.Marshal(model.Keys)
+	if err != nil {
+		return spec, err
+	}
+	if err := bson.Unmarshal(raw, &spec.Key); err != nil {
+		return spec, err
+	}
+
+	opts := model.Options
+	if opts == nil {
+		opts = options.Index()
+	}
+	spec.Unique = opts.Unique != nil && *opts.Unique
+	spec.Sparse = opts.Sparse != nil && *opts.Sparse
+	spec.ExpireAfterSeconds = opts.ExpireAfterSeconds
+	if opts.PartialFilterExpression != nil {
+		raw, err := bson.Marshal(opts.PartialFilterExpression)
+		if err != nil {
+			return spec, err
+		}
+		if err := bson.Unmarshal(raw, &spec.PartialFilterExpression); err != nil {
+			return spec, err
+		}
+	}
+
+	return spec, nil
+}
+
+func sameOptions(current indexSpec, want indexSpec) bool {
+	sameExpire := (current.ExpireAfterSeconds == nil) == (want.ExpireAfterSeconds == nil) &&
+		(current.ExpireAfterSeconds == nil || *current.ExpireAfterSeconds == *want.ExpireAfterSeconds)
+
+	return current.Unique == want.Unique &&
+		current.Sparse == want.Sparse &&
+		sameExpire &&
+		fmt.Sprint(current.PartialFilterExpression) == fmt.Sprint(want.PartialFilterExpression)
+}
+
+// keysName is the default name mongo gives to an index on the keys, e.g. server_id_1_user_id_1
+func keysName(keys bson.D) string {
+	parts := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
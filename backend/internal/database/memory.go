@@ -0,0 +1,334 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Memory keeps the collections inside the process, it backs the in-memory repositories of the modules.
+// Documents are stored with their bson encoding so they are read back with the same fields mongo would return,
+// and the repositories of different modules can share it like they share the harmony database
+type Memory struct {
+	mu          sync.RWMutex
+	collections map[string]map[primitive.ObjectID]bson.Raw
+	// unique are the fields of the unique indexes by collection and index name
+	unique map[string]map[string][]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		collections: map[string]map[primitive.ObjectID]bson.Raw{},
+		unique:      map[string]map[string][]string{},
+	}
+}
+
+// Unique declares a unique index on the fields like the one of the mongo repository, the writes breaking it
+// fail with the duplicate key error mongo returns so the repositories map it the same way.
+// Documents missing one of the fields or with it empty are not indexed, like the partial index on the mail.
+// Fields of the documents of an array, like identities.issuer, index every document of the array
+func (m *Memory) Unique(collection string, fields ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.unique[collection] == nil {
+		m.unique[collection] = map[string][]string{}
+	}
+	m.unique[collection][strings.Join(fields, "_1_")+"_1"] = fields
+}
+
+// Update runs fn holding the write lock, the changes of fn are seen by the others all at once
+func (m *Memory) Update(fn func(tx *MemoryTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return fn(&MemoryTx{m: m})
+}
+
+// View runs fn holding the read lock, fn must not write
+func (m *Memory) View(fn func(tx *MemoryTx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return fn(&MemoryTx{m: m})
+}
+
+// MemoryTx gives access to the collections while the lock is held
+type MemoryTx struct {
+	m *Memory
+}
+
+// Insert stores the document, an _id is generated when it has none and returned
+func (tx *MemoryTx) Insert(collection string, document any) (primitive.ObjectID, error) {
+	raw, id, err := encode(document, primitive.NilObjectID)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	c := tx.collection(collection)
+	if _, exists := c[id]; exists {
+		return primitive.NilObjectID, errors.New("memory: duplicate _id")
+	}
+	if err := tx.checkUnique(collection, id, raw); err != nil {
+		return primitive.NilObjectID, err
+	}
+	c[id] = raw
+
+	return id, nil
+}
+
+// Replace overwrites the document with the given _id, it returns false when there is none
+func (tx *MemoryTx) Replace(collection string, id primitive.ObjectID, document any) (bool, error) {
+	c := tx.m.collections[collection]
+	if _, exists := c[id]; !exists {
+		return false, nil
+	}
+
+	raw, _, err := encode(document, id)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.checkUnique(collection, id, raw); err != nil {
+		return false, err
+	}
+	c[id] = raw
+
+	return true, nil
+}
+
+// Modify decodes the document with the given _id into doc, lets fn change it and stores it back,
+// it returns false when there is none like an update by id that matches nothing
+func (tx *MemoryTx) Modify(collection string, id primitive.ObjectID, doc any, fn func()) (bool, error) {
+	raw, exists := tx.m.collections[collection][id]
+	if !exists {
+		return false, nil
+	}
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return false, err
+	}
+
+	fn()
+	return tx.Replace(collection, id, doc)
+}
+
+// FindOne decodes the first document matching the filter into result, mongo.ErrNoDocuments is returned
+// when none does so the callers handle both backends the same way
+func (tx *MemoryTx) FindOne(collection string, filter bson.M, result any) error {
+	for _, raw := range tx.sorted(collection) {
+		if match(raw, filter) {
+			return bson.Unmarshal(raw, result)
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+// Find decodes the documents matching the filter into results, a pointer to a slice, in insertion order
+func (tx *MemoryTx) Find(collection string, filter bson.M, results any) error {
+	slice := reflect.ValueOf(results)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("memory: results must be a pointer to a slice")
+	}
+	items := reflect.MakeSlice(slice.Elem().Type(), 0, 0)
+
+	for _, raw := range tx.sorted(collection) {
+		if !match(raw, filter) {
+			continue
+		}
+		item := reflect.New(slice.Elem().Type().Elem())
+		if err := bson.Unmarshal(raw, item.Interface()); err != nil {
+			return err
+		}
+		items = reflect.Append(items, item.Elem())
+	}
+	slice.Elem().Set(items)
+
+	return nil
+}
+
+// Delete removes the documents matching the filter and returns how many they were
+func (tx *MemoryTx) Delete(collection string, filter bson.M) int {
+	c := tx.m.collections[collection]
+	deleted := 0
+	for id, raw := range c {
+		if match(raw, filter) {
+			delete(c, id)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// checkUnique returns a duplicate key error when another document of the collection has the same
+// values of the fields of a unique index
+func (tx *MemoryTx) checkUnique(collection string, id primitive.ObjectID, raw bson.Raw) error {
+	for name, fields := range tx.m.unique[collection] {
+		keys := uniqueKeys(raw, fields)
+		if len(keys) == 0 {
+			continue
+		}
+		for otherId, other := range tx.m.collections[collection] {
+			if otherId == id {
+				continue
+			}
+			for _, otherKey := range uniqueKeys(other, fields) {
+				if slices.Contains(keys, otherKey) {
+					return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+						Code:    11000,
+						Message: fmt.Sprintf("E11000 duplicate key error collection: harmony.%s index: %s dup key", collection, name),
+					}}}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// uniqueKeys returns the keys of the document in the index, one for each document of the array
+// when the fields are inside one
+func uniqueKeys(raw bson.Raw, fields []string) []string {
+	prefix, _, nested := strings.Cut(fields[0], ".")
+	if !nested {
+		if key, indexed := uniqueKey(raw, fields); indexed {
+			return []string{key}
+		}
+		return nil
+	}
+
+	array, ok := raw.Lookup(prefix).ArrayOK()
+	if !ok {
+		return nil
+	}
+	values, err := array.Values()
+	if err != nil {
+		return nil
+	}
+	inner := make([]string, len(fields))
+	for i, field := range fields {
+		inner[i] = strings.TrimPrefix(field, prefix+".")
+	}
+	keys := []string{}
+	for _, value := range values {
+		document, ok := value.DocumentOK()
+		if !ok {
+			continue
+		}
+		if key, indexed := uniqueKey(document, inner); indexed {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// uniqueKey joins the encodings of the fields, false when the document is not indexed
+func uniqueKey(raw bson.Raw, fields []string) (string, bool) {
+	var key strings.Builder
+	for _, field := range fields {
+		value, err := raw.LookupErr(field)
+		if err != nil || value.Type == bson.TypeNull {
+			return "", false
+		}
+		if str, ok := value.StringValueOK(); ok && str == "" {
+			return "", false
+		}
+		fmt.Fprintf(&key, "%d:%x;", value.Type, value.Value)
+	}
+	return key.String(), true
+}
+
+// encode marshals the document with the given _id, a zero id keeps the one of the document or generates it
+func encode(document any, id primitive.ObjectID) (bson.Raw, primitive.ObjectID, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, id, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, id, err
+	}
+
+	for i, elem := range doc {
+		if elem.Key == "_id" {
+			if current, ok := elem.Value.(primitive.ObjectID); ok && id.IsZero() {
+				id = current
+			}
+			doc = append(doc[:i], doc[i+1:]...)
+			break
+		}
+	}
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+
+	raw, err = bson.Marshal(append(bson.D{{Key: "_id", Value: id}}, doc...))
+	return raw, id, err
+}
+
+// collection returns the documents of the collection creating it, only for writes
+func (tx *MemoryTx) collection(name string) map[primitive.ObjectID]bson.Raw {
+	c, exists := tx.m.collections[name]
+	if !exists {
+		c = map[primitive.ObjectID]bson.Raw{}
+		tx.m.collections[name] = c
+	}
+	return c
+}
+
+// sorted returns the documents by _id, generated ids grow so it is the insertion order
+func (tx *MemoryTx) sorted(name string) []bson.Raw {
+	c := tx.m.collections[name]
+	ids := make([]primitive.ObjectID, 0, len(c))
+	for id := range c {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Hex() < ids[j].Hex()
+	})
+
+	docs := make([]bson.Raw, 0, len(ids))
+	for _, id := range ids {
+		docs = append(docs, c[id])
+	}
+	return docs
+}
+
+// match compares the top level fields of the document with the filter, a nil value matches a missing field
+func match(raw bson.Raw, filter bson.M) bool {
+	for key, expected := range filter {
+		value, err := raw.LookupErr(key)
+		missing := err != nil || value.Type == bson.TypeNull
+		if expected == nil {
+			if !missing {
+				return false
+			}
+			continue
+		}
+		if missing || !equal(value, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal compares the encodings so ids and strings are matched by value, integers whatever their width
+func equal(value bson.RawValue, expected any) bool {
+	typ, data, err := bson.MarshalValue(expected)
+	if err != nil {
+		return false
+	}
+	want := bson.RawValue{Type: typ, Value: data}
+	if want.Type == value.Type {
+		return bytes.Equal(want.Value, value.Value)
+	}
+	n, ok := value.AsInt64OK()
+	e, wantOk := want.AsInt64OK()
+	return ok && wantOk && n == e
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type member struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID primitive.ObjectID `bson:"server_id"`
+	UserID   string             `bson:"user_id"`
+	Mail     string             `bson:"mail,omitempty"`
+}
+
+func TestMemoryUnique(t *testing.T) {
+	mem := NewMemory()
+	mem.Unique("members", "server_id", "user_id")
+	mem.Unique("members", "mail")
+
+	serverId := primitive.NewObjectID()
+	insert := func(m member) (primitive.ObjectID, error) {
+		var id primitive.ObjectID
+		err := mem.Update(func(tx *MemoryTx) error {
+			var err error
+			id, err = tx.Insert("members", m)
+			return err
+		})
+		return id, err
+	}
+
+	alice, err := insert(member{ServerID: serverId, UserID: "alice", Mail: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		member    member
+		duplicate string
+	}{
+		{"same keys", member{ServerID: serverId, UserID: "alice"}, "server_id_1_user_id_1"},
+		{"other server", member{ServerID: primitive.NewObjectID(), UserID: "alice"}, ""},
+		{"same mail", member{ServerID: serverId, UserID: "bob", Mail: "alice@example.com"}, "mail_1"},
+		{"no mail", member{ServerID: serverId, UserID: "carol"}, ""},
+		{"no mail again", member{ServerID: serverId, UserID: "dave"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := insert(tt.member)
+			index, isDuplicate := DuplicateKeyIndex(err)
+			if tt.duplicate == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.duplicate != "" && (!isDuplicate || index != tt.duplicate) {
+				t.Fatalf("expected a duplicate on %s, got %v", tt.duplicate, err)
+			}
+		})
+	}
+
+	// a replace can't take the keys of another document but can keep its own
+	err = mem.Update(func(tx *MemoryTx) error {
+		var carol member
+		if err := tx.FindOne("members", bson.M{"user_id": "carol"}, &carol); err != nil {
+			return err
+		}
+		carol.UserID = "alice"
+		_, err := tx.Replace("members", carol.ID, carol)
+		return err
+	})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("expected a duplicate key error, got %v", err)
+	}
+	err = mem.Update(func(tx *MemoryTx) error {
+		_, err := tx.Replace("members", alice, member{ServerID: serverId, UserID: "alice", Mail: "alice@example.com"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+}
+
+func TestMemoryUniqueArray(t *testing.T) {
+	type identity struct {
+		Issuer  string `bson:"issuer"`
+		Subject string `bson:"subject"`
+	}
+	type user struct {
+		ID         primitive.ObjectID `bson:"_id,omitempty"`
+		Identities []identity         `bson:"identities"`
+	}
+
+	mem := NewMemory()
+	mem.Unique("users", "identities.issuer", "identities.subject")
+	insert := func(u user) error {
+		return mem.Update(func(tx *MemoryTx) error {
+			_, err := tx.Insert("users", u)
+			return err
+		})
+	}
+
+	if err := insert(user{Identities: []identity{{"a", "1"}, {"b", "2"}}}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		user      user
+		duplicate bool
+	}{
+		{"same identity", user{Identities: []identity{{"c", "3"}, {"b", "2"}}}, true},
+		{"other subject", user{Identities: []identity{{"a", "2"}}}, false},
+		{"no identities", user{}, false},
+		{"no identities again", user{Identities: []identity{}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := insert(tt.user)
+			index, isDuplicate := DuplicateKeyIndex(err)
+			if !tt.duplicate && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.duplicate && (!isDuplicate || index != "identities.issuer_1_identities.subject_1") {
+				t.Fatalf("expected a duplicate identity, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// lockTTL is how long the lock survives an instance that stopped, the holder renews it
+	// every lockRenew while the migrations run
+	lockTTL   = 2 * time.Minute
+	lockRenew = lockTTL / 4
+	lockRetry = time.Second
+	lockID    = "migrations"
+)
+
+// ErrLockLost is returned when the lock expired or was taken over while the migrations were running
+var ErrLockLost = errors.New("migration lock lost")
+
+// ErrIrreversible is returned when reverting a migration that has no Down
+var ErrIrreversible = errors.New("migration cannot be reverted")
+
+// Migration changes the stored documents, it is applied once and recorded by version in schema_migrations
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, client *mongo.Client) error
+	// Down reverts Up, it is nil when the migration cannot be reverted
+	Down func(ctx context.Context, client *mongo.Client) error
+}
+
+// MigrationStatus tells if a migration is applied, AppliedAt is nil when it is pending
+type MigrationStatus struct {
+	Version   int        `bson:"_id"`
+	Name      string     `bson:"name"`
+	AppliedAt *time.Time `bson:"applied_at"`
+}
+
+// Migrator applies the migrations in order of version holding a lock, so only one instance runs them
+type Migrator struct {
+	client     *mongo.Client
+	db         *mongo.Database
+	migrations []Migration
+	lock       migrationLock
+	lockTTL    time.Duration
+	lockRenew  time.Duration
+	lockRetry  time.Duration
+}
+
+// migrationLock stores the lock of the migrations, the holder is identified by owner
+type migrationLock interface {
+	// acquire takes the lock when it is free or expired, it returns false while another owner holds it
+	acquire(ctx context.Context, owner string, expiresAt time.Time) (bool, error)
+	// renew extends the lock, it returns false when owner doesn't hold it anymore
+	renew(ctx context.Context, owner string, expiresAt time.Time) (bool, error)
+	release(ctx context.Context, owner string) error
+}
+
+func NewMigrator(client *mongo.Client, migrations []Migration) (*Migrator, error) {
+	sorted := slices.Clone(migrations)
+	slices.SortFunc(sorted, func(a, b Migration) int { return a.Version - b.Version })
+	for i, migration := range sorted {
+		if migration.Version <= 0 || migration.Up == nil {
+			return nil, fmt.Errorf("invalid migration %d %s", migration.Version, migration.Name)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migration.Version)
+		}
+	}
+
+	db := client.Database("harmony")
+	return &Migrator{
+		client:     client,
+		db:         db,
+		migrations: sorted,
+		lock:       mongoLock{c: db.Collection("schema_migrations_lock")},
+		lockTTL:    lockTTL,
+		lockRenew:  lockRenew,
+		lockRetry:  lockRetry,
+	}, nil
+}
+
+// Up applies the pending migrations and returns them, it stops at the first that fails
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	cMigrations := m.db.Collection("schema_migrations")
+
+	applied := []Migration{}
+	err := m.locked(ctx, func(ctx context.Context) error {
+		versions, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, exists := versions[migration.Version]; exists {
+				continue
+			}
+			if err := migration.Up(ctx, m.client); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+
+			appliedAt := time.Now()
+			_, err := cMigrations.InsertOne(ctx, MigrationStatus{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: &appliedAt,
+			})
+			if err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	cMigrations := m.db.Collection("schema_migrations")
+
+	reverted := []Migration{}
+	err := m.locked(ctx, func(ctx context.Context) error {
+		versions, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, exists := versions[migration.Version]; !exists {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, ErrIrreversible)
+			}
+			if err := migration.Down(ctx, m.client); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+
+			_, err := cMigrations.DeleteOne(ctx, bson.M{"_id": migration.Version})
+			if err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status lists the known migrations and the applied ones this version doesn't know about
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	versions, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status = append(status, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: versions[migration.Version].AppliedAt,
+		})
+		delete(versions, migration.Version)
+	}
+	for _, unknown := range versions {
+		status = append(status, unknown)
+	}
+	slices.SortFunc(status, func(a, b MigrationStatus) int { return a.Version - b.Version })
+
+	return status, nil
+}
+
+// applied returns the recorded migrations by version
+func (m *Migrator) applied(ctx context.Context) (map[int]MigrationStatus, error) {
+	cMigrations := m.db.Collection("schema_migrations")
+
+	cursor, err := cMigrations.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	records := []MigrationStatus{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]MigrationStatus, len(records))
+	for _, record := range records {
+		versions[record.Version] = record
+	}
+	return versions, nil
+}
+
+// locked runs fn holding the lock, it waits for the other instances until ctx is done.
+// The lock is renewed until fn returns, if it is lost the context of fn is canceled so
+// the migration stops
+func (m *Migrator) locked(ctx context.Context, fn func(ctx context.Context) error) error {
+	owner := primitive.NewObjectID().Hex()
+
+	for {
+		acquired, err := m.lock.acquire(ctx, owner, time.Now().Add(m.lockTTL))
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.lockRetry):
+		}
+	}
+
+	defer func() {
+		// released even when ctx is done
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m.lock.release(releaseCtx, owner)
+	}()
+
+	fnCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+
+		ticker := time.NewTicker(m.lockRenew)
+		defer ticker.Stop()
+		expiresAt := time.Now().Add(m.lockTTL)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			renewedAt := time.Now()
+			held, err := m.lock.renew(fnCtx, owner, renewedAt.Add(m.lockTTL))
+			if err == nil && held {
+				expiresAt = renewedAt.Add(m.lockTTL)
+				continue
+			}
+			// a failed renewal is retried while the lock has not expired yet
+			if err == nil || time.Until(expiresAt) < m.lockRenew {
+				cancel(ErrLockLost)
+				return
+			}
+		}
+	}()
+
+	err := fn(fnCtx)
+	close(done)
+	<-renewed
+
+	if cause := context.Cause(fnCtx); errors.Is(cause, ErrLockLost) {
+		return ErrLockLost
+	}
+	return err
+}
+
+// mongoLock is a single document taken by upserting it only when expired: while another
+// instance holds it the upsert collides on the _id
+type mongoLock struct {
+	c *mongo.Collection
+}
+
+func (l mongoLock) acquire(ctx context.Context, owner string, expiresAt time.Time) (bool, error) {
+	_, err := l.c.UpdateOne(ctx,
+		bson.M{"_id": lockID, "expires_at": bson.M{"$lt": time.Now()}},
+		bson.M{"$set": bson.M{"owner": owner, "expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l mongoLock) renew(ctx context.Context, owner string, expiresAt time.Time) (bool, error) {
+	result, err := l.c.UpdateOne(ctx,
+		bson.M{"_id": lockID, "owner": owner},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (l mongoLock) release(ctx context.Context, owner string) error {
+	_, err := l.c.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner})
+	return err
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryLock is the lock document of the migrations kept in memory
+type memoryLock struct {
+	mu        sync.Mutex
+	owner     string
+	expiresAt time.Time
+	// renewErr fails the next renewals with the error
+	renewErr error
+}
+
+func (l *memoryLock) acquire(ctx context.Context, owner string, expiresAt time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.owner != "" && time.Now().Before(l.expiresAt) {
+		return false, nil
+	}
+	l.owner, l.expiresAt = owner, expiresAt
+	return true, nil
+}
+
+func (l *memoryLock) renew(ctx context.Context, owner string, expiresAt time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.renewErr != nil {
+		return false, l.renewErr
+	}
+	if l.owner != owner {
+		return false, nil
+	}
+	l.expiresAt = expiresAt
+	return true, nil
+}
+
+func (l *memoryLock) release(ctx context.Context, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.owner == owner {
+		l.owner = ""
+	}
+	return nil
+}
+
+func (l *memoryLock) holder() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.owner
+}
+
+func (l *memoryLock) set(fn func(l *memoryLock)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn(l)
+}
+
+// newLockedMigrator is a migrator running only the lock, with durations short enough for a test
+func newLockedMigrator(lock *memoryLock) *Migrator {
+	return &Migrator{
+		lock:      lock,
+		lockTTL:   200 * time.Millisecond,
+		lockRenew: 20 * time.Millisecond,
+		lockRetry: 5 * time.Millisecond,
+	}
+}
+
+func TestNewMigrator(t *testing.T) {
+	client := &mongo.Client{}
+	up := func(ctx context.Context, client *mongo.Client) error { return nil }
+
+	m, err := NewMigrator(client, []Migration{{Version: 2, Name: "b", Up: up}, {Version: 1, Name: "a", Up: up}})
+	if err != nil {
+		t.Fatalf("new migrator: %v", err)
+	}
+	if m.migrations[0].Version != 1 || m.migrations[1].Version != 2 {
+		t.Fatalf("expected the migrations in order of version, got %v", m.migrations)
+	}
+
+	cases := []struct {
+		name       string
+		migrations []Migration
+	}{
+		{"no version", []Migration{{Name: "a", Up: up}}},
+		{"no up", []Migration{{Version: 1, Name: "a"}}},
+		{"duplicate version", []Migration{{Version: 1, Name: "a", Up: up}, {Version: 1, Name: "b", Up: up}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewMigrator(client, tc.migrations); err == nil {
+				t.Fatal("expected the migrations to be refused")
+			}
+		})
+	}
+}
+
+func TestMigrationLockExclusive(t *testing.T) {
+	lock := &memoryLock{}
+	first, second := newLockedMigrator(lock), newLockedMigrator(lock)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	result := make(chan error)
+	go func() {
+		result <- first.locked(context.Background(), func(ctx context.Context) error {
+			close(started)
+			<-finish
+			return nil
+		})
+	}()
+	<-started
+
+	// the second instance waits for the lock until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := second.locked(ctx, func(ctx context.Context) error {
+		t.Error("expected the migrations not to run twice")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// once released it runs
+	close(finish)
+	if err := <-result; err != nil {
+		t.Fatalf("locked: %v", err)
+	}
+	if lock.holder() != "" {
+		t.Fatal("expected the lock to be released")
+	}
+	ran := false
+	err = second.locked(context.Background(), func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	if err != nil || !ran {
+		t.Fatalf("expected the migrations to run, got %v", err)
+	}
+}
+
+func TestMigrationLockExpired(t *testing.T) {
+	// an instance stopped while holding the lock
+	lock := &memoryLock{owner: "stopped", expiresAt: time.Now().Add(-time.Second)}
+	m := newLockedMigrator(lock)
+
+	ran := false
+	err := m.locked(context.Background(), func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	if err != nil || !ran {
+		t.Fatalf("expected the expired lock to be taken over, got %v", err)
+	}
+}
+
+func TestMigrationLockRenewed(t *testing.T) {
+	lock := &memoryLock{}
+	m := newLockedMigrator(lock)
+
+	// the migration lasts longer than the ttl of the lock
+	err := m.locked(context.Background(), func(ctx context.Context) error {
+		deadline := time.Now().Add(3 * m.lockTTL)
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(m.lockRenew):
+			}
+			var expiresAt time.Time
+			lock.set(func(l *memoryLock) { expiresAt = l.expiresAt })
+			if !time.Now().Before(expiresAt) {
+				return errors.New("lock expired")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the lock to be renewed, got %v", err)
+	}
+}
+
+func TestMigrationLockRenewRetried(t *testing.T) {
+	lock := &memoryLock{}
+	m := newLockedMigrator(lock)
+
+	// a renewal failing once is retried before the lock expires
+	err := m.locked(context.Background(), func(ctx context.Context) error {
+		lock.set(func(l *memoryLock) { l.renewErr = errors.New("network error") })
+		time.Sleep(2 * m.lockRenew)
+		lock.set(func(l *memoryLock) { l.renewErr = nil })
+		time.Sleep(2 * m.lockRenew)
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("expected the lock to be kept, got %v", err)
+	}
+}
+
+func TestMigrationLockLost(t *testing.T) {
+	t.Run("taken over", func(t *testing.T) {
+		lock := &memoryLock{}
+		m := newLockedMigrator(lock)
+
+		err := m.locked(context.Background(), func(ctx context.Context) error {
+			// the lock expired while the instance was paused and another one took it
+			lock.set(func(l *memoryLock) { l.owner = "other" })
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		if !errors.Is(err, ErrLockLost) {
+			t.Fatalf("expected %v, got %v", ErrLockLost, err)
+		}
+		// the lock of the other instance is left alone
+		if lock.holder() != "other" {
+			t.Fatal("expected the other instance to keep the lock")
+		}
+	})
+
+	t.Run("renewal failing", func(t *testing.T) {
+		lock := &memoryLock{}
+		m := newLockedMigrator(lock)
+
+		err := m.locked(context.Background(), func(ctx context.Context) error {
+			lock.set(func(l *memoryLock) { l.renewErr = errors.New("network error") })
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(2 * m.lockTTL):
+				return errors.New("expected the migration to be stopped")
+			}
+		})
+		if !errors.Is(err, ErrLockLost) {
+			t.Fatalf("expected %v, got %v", ErrLockLost, err)
+		}
+	})
+}
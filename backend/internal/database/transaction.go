@@ -0,0 +1,23 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transaction runs fn in a transaction of a new session, the writes of fn must use the session context.
+// The driver runs fn again when mongo labels the error as transient (e.g. a write conflict or an election)
+// and retries the commit when its outcome is unknown, until ctx is done
+func Transaction(ctx context.Context, client *mongo.Client, fn func(ctx mongo.SessionContext) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package event
+
+import (
+	"encoding/json"
+	"harmony/utils"
+)
+
+// types of the published events
+const (
+	MessageCreated = "MESSAGE_CREATED"
+	MessageUpdated = "MESSAGE_UPDATED"
+	MessageDeleted = "MESSAGE_DELETED"
+	MemberJoined   = "MEMBER_JOINED"
+	MemberLeft     = "MEMBER_LEFT"
+	ServerUpdated  = "SERVER_UPDATED"
+	ServerDeleted  = "SERVER_DELETED"
+	VoiceState     = "VOICE_STATE_UPDATE"
+	VoiceSignal    = "VOICE_SIGNAL"
+	// interactions are sent to the bot that owns the command, the invoker is told when it waits or fails
+	InteractionCreate   = "INTERACTION_CREATE"
+	InteractionDeferred = "INTERACTION_DEFERRED"
+	InteractionFailed   = "INTERACTION_FAILED"
+)
+
+// Event is something that happened inside a server
+type Event struct {
+	// ID is set on publish, subscribers running on every instance use it to act only once
+	ID       string
+	Type     string
+	ServerID string
+	// UserID is the member the event is about, set on membership changes
+	UserID string
+	// Audience restricts the delivery to the given members of the server, nil means everyone
+	Audience []string
+	// Excluded are the members the event is never delivered to, whatever the audience
+	Excluded []string
+	// Data is published as any value and delivered as its json.RawMessage by every bus, the subscribers
+	// see the same payload whether the event was published by this instance or another one
+	Data any
+}
+
+// Bus delivers the published events to every subscriber of every api instance
+type Bus interface {
+	Publish(evt Event) error
+	Subscribe(handler func(Event))
+	Close() error
+}
+
+// encode turns the data into the json delivered to the subscribers
+func (e *Event) encode() error {
+	if _, raw := e.Data.(json.RawMessage); raw {
+		return nil
+	}
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	e.Data = json.RawMessage(data)
+	return nil
+}
+
+func newID() string {
+	return utils.GetRandomToken(12)
+}
@@ -0,0 +1,44 @@
+package event
+
+import "sync"
+
+// LocalBus delivers the events only inside the current process
+type LocalBus struct {
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+func (b *LocalBus) Publish(evt Event) error {
+	if evt.ID == "" {
+		evt.ID = newID()
+	}
+	// delivered like the mongo bus does, the handlers must not share the published values
+	if err := evt.encode(); err != nil {
+		return err
+	}
+
+	// handlers are called without holding the lock as they may publish in turn
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(evt)
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(handler func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *LocalBus) Close() error {
+	return nil
+}
@@ -0,0 +1,170 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"harmony/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	// retention is how long the events are kept in the collection
+	retention = time.Hour
+)
+
+type document struct {
+	ID        string    `bson:"event_id"`
+	Type      string    `bson:"type"`
+	ServerID  string    `bson:"server_id"`
+	UserID    string    `bson:"user_id"`
+	Audience  []string  `bson:"audience"`
+	Excluded  []string  `bson:"excluded"`
+	Data      string    `bson:"data"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// MongoBus shares the events between the api instances through a change stream on the events collection,
+// it requires mongo to run as a replica set
+type MongoBus struct {
+	collection *mongo.Collection
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+func NewMongoBus(db *mongo.Client) (*MongoBus, error) {
+	collection := db.Database("harmony").Collection("events")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// old events are only useful to the streams that are catching up
+	err := database.EnsureIndexes(ctx, collection, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds()))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	watchCtx, watchCancel := context.WithCancel(context.Background())
+	b := &MongoBus{
+		collection: collection,
+		cancel:     watchCancel,
+		done:       make(chan struct{}),
+	}
+	go b.watch(watchCtx)
+
+	return b, nil
+}
+
+func (b *MongoBus) Publish(evt Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// the payload is stored as json so that it reaches the clients exactly as it was published
+	if err := evt.encode(); err != nil {
+		return err
+	}
+
+	if evt.ID == "" {
+		evt.ID = newID()
+	}
+	_, err := b.collection.InsertOne(ctx, document{
+		ID:        evt.ID,
+		Type:      evt.Type,
+		ServerID:  evt.ServerID,
+		UserID:    evt.UserID,
+		Audience:  evt.Audience,
+		Excluded:  evt.Excluded,
+		Data:      string(evt.Data.(json.RawMessage)),
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+func (b *MongoBus) Subscribe(handler func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *MongoBus) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+// watch follows the change stream reopening it from the last seen event when it breaks
+func (b *MongoBus) watch(ctx context.Context) {
+	defer close(b.done)
+
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	var resumeToken bson.Raw
+	backoff := time.Second
+
+	for ctx.Err() == nil {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := b.collection.Watch(ctx, pipeline, opts)
+		if err != nil {
+			log.Printf("event bus: failed to open change stream: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument document `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				log.Printf("event bus: failed to decode event: %v", err)
+				continue
+			}
+			resumeToken = stream.ResumeToken()
+			b.deliver(change.FullDocument)
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			log.Printf("event bus: change stream interrupted: %v", err)
+		}
+		stream.Close(context.Background())
+	}
+}
+
+func (b *MongoBus) deliver(doc document) {
+	evt := Event{
+		ID:       doc.ID,
+		Type:     doc.Type,
+		ServerID: doc.ServerID,
+		UserID:   doc.UserID,
+		Audience: doc.Audience,
+		Excluded: doc.Excluded,
+		Data:     json.RawMessage(doc.Data),
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(evt)
+	}
+}
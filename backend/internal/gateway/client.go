@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const writeTimeout = 10 * time.Second
+
+type client struct {
+	conn *websocket.Conn
+	send chan Frame
+	// done is closed with the client, send is never closed as the hub may still hold the client
+	done chan struct{}
+
+	closeOnce sync.Once
+}
+
+func newClient(conn *websocket.Conn) *client {
+	return &client{
+		conn: conn,
+		send: make(chan Frame, 256),
+		done: make(chan struct{}),
+	}
+}
+
+// write queues the frame, a client too slow to keep up is disconnected and will have to resume.
+// The frames written after the client is closed are dropped
+func (c *client) write(frame Frame) {
+	select {
+	case <-c.done:
+	case c.send <- frame:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *client) writePump() {
+	for {
+		select {
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteJSON(frame); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
@@ -0,0 +1,59 @@
+package gateway
+
+import "encoding/json"
+
+// operations exchanged on the socket
+const (
+	OpDispatch       = "dispatch"
+	OpHello          = "hello"
+	OpIdentify       = "identify"
+	OpHeartbeat      = "heartbeat"
+	OpHeartbeatAck   = "heartbeat_ack"
+	OpResume         = "resume"
+	OpResumed        = "resumed"
+	OpInvalidSession = "invalid_session"
+	OpError          = "error"
+	OpVoiceJoin      = "voice_join"
+	OpVoiceLeave     = "voice_leave"
+	OpVoiceState     = "voice_state"
+	OpVoiceSignal    = "voice_signal"
+)
+
+// Frame is the envelope sent to the clients
+type Frame struct {
+	Op   string `json:"op"`
+	Type string `json:"t,omitempty"`
+	Seq  uint64 `json:"s,omitempty"`
+	Data any    `json:"d,omitempty"`
+}
+
+type incomingFrame struct {
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"d"`
+}
+
+type identifyPayload struct {
+	Token string `json:"token"`
+}
+
+type resumePayload struct {
+	SessionID string `json:"session_id"`
+	Seq       uint64 `json:"seq"`
+}
+
+type voiceJoinPayload struct {
+	ChannelID string `json:"channel_id"`
+	Muted     bool   `json:"muted"`
+	Deafened  bool   `json:"deafened"`
+}
+
+type voiceStatePayload struct {
+	Muted    bool `json:"muted"`
+	Deafened bool `json:"deafened"`
+}
+
+type voiceSignalPayload struct {
+	To      string          `json:"to"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
@@ -0,0 +1,328 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"harmony/internal/event"
+	"harmony/internal/voice"
+	"harmony/utils"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	HeartbeatInterval = 30 * time.Second
+	// ResumeWindow is how long a disconnected session is kept to allow resuming it
+	ResumeWindow = 2 * time.Minute
+	// bufferSize is how many dispatched frames a session keeps for replay
+	bufferSize = 200
+	// readLimit leaves room for the SDP payloads
+	readLimit = 64 * 1024
+	// IdentifyTimeout is how long a connection opened without credentials has to send them
+	IdentifyTimeout = 10 * time.Second
+)
+
+type session struct {
+	id             string
+	user           string
+	servers        map[string]bool
+	seq            uint64
+	buffer         []Frame
+	client         *client
+	disconnectedAt time.Time
+	peer           *sessionPeer
+}
+
+func (s *session) dispatch(evt event.Event) {
+	s.seq++
+	frame := Frame{
+		Op:   OpDispatch,
+		Type: evt.Type,
+		Seq:  s.seq,
+		Data: evt.Data,
+	}
+	s.buffer = append(s.buffer, frame)
+	if len(s.buffer) > bufferSize {
+		s.buffer = s.buffer[len(s.buffer)-bufferSize:]
+	}
+	if s.client != nil {
+		s.client.write(frame)
+	}
+}
+
+// Hub keeps track of the gateway sessions and fans out the events to them, the sessions are indexed
+// by user and by server so an event only visits the sessions it is for
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	byUser   map[string]map[string]*session
+	byServer map[string]map[string]*session
+	voice    *voice.Manager
+}
+
+func NewHub(voice *voice.Manager) *Hub {
+	h := &Hub{
+		sessions: map[string]*session{},
+		byUser:   map[string]map[string]*session{},
+		byServer: map[string]map[string]*session{},
+		voice:    voice,
+	}
+	go h.expireSessions()
+	return h
+}
+
+// add registers the session in the indexes, h.mu must be held
+func (h *Hub) add(s *session) {
+	h.sessions[s.id] = s
+	if h.byUser[s.user] == nil {
+		h.byUser[s.user] = map[string]*session{}
+	}
+	h.byUser[s.user][s.id] = s
+	for serverId := range s.servers {
+		h.subscribe(s, serverId)
+	}
+}
+
+// remove drops the session from the indexes, h.mu must be held
+func (h *Hub) remove(s *session) {
+	delete(h.sessions, s.id)
+	delete(h.byUser[s.user], s.id)
+	if len(h.byUser[s.user]) == 0 {
+		delete(h.byUser, s.user)
+	}
+	for serverId := range s.servers {
+		h.unsubscribe(s, serverId)
+	}
+}
+
+func (h *Hub) subscribe(s *session, serverId string) {
+	s.servers[serverId] = true
+	if h.byServer[serverId] == nil {
+		h.byServer[serverId] = map[string]*session{}
+	}
+	h.byServer[serverId][s.id] = s
+}
+
+func (h *Hub) unsubscribe(s *session, serverId string) {
+	delete(s.servers, serverId)
+	delete(h.byServer[serverId], s.id)
+	if len(h.byServer[serverId]) == 0 {
+		delete(h.byServer, serverId)
+	}
+}
+
+// Deliver sends the event to every session subscribed to its server
+func (h *Hub) Deliver(evt event.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// the voice manager hands the signals to the peer of the target
+	if evt.Type == event.VoiceSignal {
+		return
+	}
+
+	if evt.Type == event.MemberJoined {
+		for _, s := range h.byUser[evt.UserID] {
+			h.subscribe(s, evt.ServerID)
+		}
+	}
+
+	// the sessions of the audience are fewer than the ones of the server
+	targets := []*session{}
+	if evt.Audience != nil {
+		for _, user := range evt.Audience {
+			for _, s := range h.byUser[user] {
+				if s.servers[evt.ServerID] {
+					targets = append(targets, s)
+				}
+			}
+		}
+	} else {
+		for _, s := range h.byServer[evt.ServerID] {
+			targets = append(targets, s)
+		}
+	}
+
+	for _, s := range targets {
+		if utils.Contains(evt.Excluded, s.user) {
+			continue
+		}
+
+		s.dispatch(evt)
+
+		if evt.Type == event.ServerDeleted || (evt.Type == event.MemberLeft && s.user == evt.UserID) {
+			h.unsubscribe(s, evt.ServerID)
+		}
+	}
+}
+
+// Serve runs a new session on the connection until it is closed
+func (h *Hub) Serve(conn *websocket.Conn, user string, servers []string) {
+	c := newClient(conn)
+	go c.writePump()
+
+	s := &session{
+		id:      utils.GetRandomToken(16),
+		user:    user,
+		servers: map[string]bool{},
+		client:  c,
+	}
+	for _, serverId := range servers {
+		s.servers[serverId] = true
+	}
+	s.peer = &sessionPeer{hub: h, session: s}
+	conn.SetReadLimit(readLimit)
+
+	h.mu.Lock()
+	h.add(s)
+	h.mu.Unlock()
+
+	c.write(Frame{
+		Op: OpHello,
+		Data: map[string]any{
+			"session_id":         s.id,
+			"heartbeat_interval": HeartbeatInterval.Milliseconds(),
+		},
+	})
+
+	for {
+		var frame incomingFrame
+		conn.SetReadDeadline(time.Now().Add(HeartbeatInterval * 3 / 2))
+		if err := conn.ReadJSON(&frame); err != nil {
+			break
+		}
+
+		switch frame.Op {
+		case OpHeartbeat:
+			c.write(Frame{Op: OpHeartbeatAck})
+		case OpResume:
+			var payload resumePayload
+			if err := json.Unmarshal(frame.Data, &payload); err != nil {
+				c.write(Frame{Op: OpInvalidSession})
+				continue
+			}
+			current := s
+			s = h.resume(current, payload)
+			if s != current {
+				h.voice.Leave(current.peer)
+			}
+		case OpVoiceJoin, OpVoiceLeave, OpVoiceState, OpVoiceSignal:
+			h.handleVoice(s, frame)
+		default:
+			log.Printf("gateway: unknown op %q from %s", frame.Op, user)
+		}
+	}
+
+	h.mu.Lock()
+	if s.client == c {
+		s.client = nil
+		s.disconnectedAt = time.Now()
+	}
+	h.mu.Unlock()
+	c.close()
+}
+
+// ReadIdentify waits for the identify frame a connection opened without credentials starts with
+// and returns its token
+func ReadIdentify(conn *websocket.Conn) (string, error) {
+	conn.SetReadLimit(readLimit)
+	conn.SetReadDeadline(time.Now().Add(IdentifyTimeout))
+
+	var frame incomingFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		return "", err
+	}
+	if frame.Op != OpIdentify {
+		return "", errors.New("Identify expected")
+	}
+	var payload identifyPayload
+	if err := json.Unmarshal(frame.Data, &payload); err != nil || payload.Token == "" {
+		return "", errors.New("Missing token")
+	}
+	return payload.Token, nil
+}
+
+// Reject tells the client why it can't connect and closes the connection, no session is created
+func Reject(conn *websocket.Conn, reason string) {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	conn.WriteJSON(Frame{Op: OpError, Data: map[string]any{"error": reason}})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason))
+	conn.Close()
+}
+
+// resume moves the client of the current session onto a previous one replaying the missed frames,
+// the session the client ends up on is returned
+func (h *Hub) resume(current *session, payload resumePayload) *session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := current.client
+	previous, exists := h.sessions[payload.SessionID]
+	if !exists || previous == current || previous.user != current.user {
+		c.write(Frame{Op: OpInvalidSession})
+		return current
+	}
+
+	// the frames after the given sequence must still be in the buffer
+	if len(previous.buffer) > 0 && previous.buffer[0].Seq > payload.Seq+1 {
+		c.write(Frame{Op: OpInvalidSession})
+		return current
+	}
+
+	// a connection still attached to the session is stale, the new one takes over
+	if previous.client != nil {
+		previous.client.conn.Close()
+	}
+
+	h.remove(current)
+	previous.client = c
+	previous.disconnectedAt = time.Time{}
+	for _, frame := range previous.buffer {
+		if frame.Seq > payload.Seq {
+			c.write(frame)
+		}
+	}
+	c.write(Frame{
+		Op: OpResumed,
+		Data: map[string]any{
+			"session_id": previous.id,
+			"seq":        previous.seq,
+		},
+	})
+
+	return previous
+}
+
+func (h *Hub) reply(s *session, frame Frame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s.client != nil {
+		s.client.write(frame)
+	}
+}
+
+func (h *Hub) expireSessions() {
+	ticker := time.NewTicker(ResumeWindow / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired := []*session{}
+		h.mu.Lock()
+		for _, s := range h.sessions {
+			if s.client == nil && time.Since(s.disconnectedAt) > ResumeWindow {
+				h.remove(s)
+				expired = append(expired, s)
+			}
+		}
+		h.mu.Unlock()
+
+		// a session that cannot be resumed anymore also leaves the voice channel
+		for _, s := range expired {
+			h.voice.Leave(s.peer)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"harmony/internal/event"
+	"testing"
+)
+
+// newTestSession registers a session whose client only queues the frames
+func newTestSession(h *Hub, id string, user string, servers ...string) *session {
+	s := &session{
+		id:      id,
+		user:    user,
+		servers: map[string]bool{},
+		client:  &client{send: make(chan Frame, 16), done: make(chan struct{})},
+	}
+	for _, serverId := range servers {
+		s.servers[serverId] = true
+	}
+
+	h.mu.Lock()
+	h.add(s)
+	h.mu.Unlock()
+	return s
+}
+
+// received drains the frames queued for the session
+func received(s *session) []string {
+	types := []string{}
+	for {
+		select {
+		case frame := <-s.client.send:
+			types = append(types, frame.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	h := NewHub(nil)
+	alice := newTestSession(h, "a", "alice", "server-1")
+	bob := newTestSession(h, "b", "bob", "server-1", "server-2")
+	carol := newTestSession(h, "c", "carol")
+
+	h.Deliver(event.Event{Type: event.MessageCreated, ServerID: "server-1"})
+	h.Deliver(event.Event{Type: event.MessageCreated, ServerID: "server-2", Audience: []string{"alice", "bob"}})
+	h.Deliver(event.Event{Type: event.MessageUpdated, ServerID: "server-1", Excluded: []string{"bob"}})
+	h.Deliver(event.Event{Type: event.MemberJoined, ServerID: "server-2", UserID: "carol"})
+	h.Deliver(event.Event{Type: event.MemberLeft, ServerID: "server-1", UserID: "alice"})
+	h.Deliver(event.Event{Type: event.MessageDeleted, ServerID: "server-1"})
+	h.Deliver(event.Event{Type: event.ServerDeleted, ServerID: "server-2"})
+	h.Deliver(event.Event{Type: event.MessageCreated, ServerID: "server-2"})
+
+	tests := []struct {
+		name     string
+		session  *session
+		expected []string
+	}{
+		{"alice", alice, []string{event.MessageCreated, event.MessageUpdated, event.MemberLeft}},
+		{"bob", bob, []string{event.MessageCreated, event.MessageCreated, event.MemberJoined, event.MemberLeft, event.MessageDeleted, event.ServerDeleted}},
+		{"carol", carol, []string{event.MemberJoined, event.ServerDeleted}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			types := received(tt.session)
+			if len(types) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, types)
+			}
+			for i := range types {
+				if types[i] != tt.expected[i] {
+					t.Fatalf("expected %v, got %v", tt.expected, types)
+				}
+			}
+		})
+	}
+
+	if len(h.byServer) != 1 || len(h.byServer["server-1"]) != 1 {
+		t.Fatalf("expected only bob left in server-1, got %v", h.byServer)
+	}
+}
+
+// TestDeliverAfterClose delivers to a session whose client is gone, like a resumed one being replaced
+func TestDeliverAfterClose(t *testing.T) {
+	h := NewHub(nil)
+	s := newTestSession(h, "a", "alice", "server-1")
+
+	s.client.close()
+	s.client.close()
+	for range 32 {
+		h.Deliver(event.Event{Type: event.MessageCreated, ServerID: "server-1"})
+	}
+}
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"encoding/json"
+	"harmony/internal/event"
+)
+
+// EventVoiceJoined is dispatched only to the session that joined a voice channel
+const EventVoiceJoined = "VOICE_JOINED"
+
+// sessionPeer lets the voice manager reach a session, the frames go through the session buffer so they survive a resume
+type sessionPeer struct {
+	hub     *Hub
+	session *session
+}
+
+func (p *sessionPeer) UserID() string {
+	return p.session.user
+}
+
+func (p *sessionPeer) Send(eventType string, data any) {
+	p.hub.mu.Lock()
+	defer p.hub.mu.Unlock()
+
+	p.session.dispatch(event.Event{Type: eventType, Data: data})
+}
+
+// handleVoice runs a voice operation for the session and replies with its outcome
+func (h *Hub) handleVoice(s *session, frame incomingFrame) {
+	var err error
+
+	switch frame.Op {
+	case OpVoiceJoin:
+		var payload voiceJoinPayload
+		if err = json.Unmarshal(frame.Data, &payload); err != nil {
+			break
+		}
+		var participants any
+		participants, err = h.voice.Join(s.peer, payload.ChannelID, payload.Muted, payload.Deafened)
+		if err == nil {
+			s.peer.Send(EventVoiceJoined, map[string]any{
+				"channel_id":   payload.ChannelID,
+				"participants": participants,
+			})
+		}
+	case OpVoiceLeave:
+		err = h.voice.Leave(s.peer)
+	case OpVoiceState:
+		var payload voiceStatePayload
+		if err = json.Unmarshal(frame.Data, &payload); err != nil {
+			break
+		}
+		err = h.voice.UpdateState(s.peer, payload.Muted, payload.Deafened)
+	case OpVoiceSignal:
+		var payload voiceSignalPayload
+		if err = json.Unmarshal(frame.Data, &payload); err != nil {
+			break
+		}
+		err = h.voice.Signal(s.peer, payload.To, payload.Type, payload.Payload)
+	}
+
+	if err != nil {
+		h.reply(s, Frame{
+			Op: OpError,
+			Data: map[string]any{
+				"op":    frame.Op,
+				"error": err.Error(),
+			},
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes every mail as an .eml file, for development without an SMTP server
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	body, err := encode(m.from, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), filepath.Base(msg.To))
+	return os.WriteFile(filepath.Join(m.dir, name), body, 0o644)
+}
+
+// MemoryMailer keeps the mails it is asked to send, for tests
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the mails sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// address extracts the bare address from a "Name <address>" header value
+func address(value string) (string, error) {
+	parsed, err := mail.ParseAddress(value)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Address, nil
+}
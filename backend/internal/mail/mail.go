@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends transactional mails, the implementation is picked by MAIL_DRIVER
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	appURL   = os.Getenv("APP_URL")
+	driver   = os.Getenv("MAIL_DRIVER")
+	from     = os.Getenv("MAIL_FROM")
+	dir      = os.Getenv("MAIL_DIR")
+	smtpHost = os.Getenv("SMTP_HOST")
+	smtpPort = os.Getenv("SMTP_PORT")
+	smtpUser = os.Getenv("SMTP_USERNAME")
+	smtpPass = os.Getenv("SMTP_PASSWORD")
+)
+
+// New builds the mailer from MAIL_DRIVER: "smtp", "memory" or "file" (the default) writing to MAIL_DIR
+func New() Mailer {
+	if from == "" {
+		from = "Harmony <no-reply@localhost>"
+	}
+
+	switch driver {
+	case "smtp":
+		port, err := strconv.Atoi(smtpPort)
+		if err != nil {
+			log.Fatalf("Failed to parse SMTP_PORT: %v", err)
+		}
+		return NewSMTPMailer(smtpHost, port, smtpUser, smtpPass, from)
+	case "memory":
+		return NewMemoryMailer()
+	case "", "file":
+		if dir == "" {
+			dir = "mail"
+		}
+		return NewFileMailer(dir, from)
+	default:
+		log.Fatalf("Unknown MAIL_DRIVER %q", driver)
+		return nil
+	}
+}
+
+// Link is the frontend page the mail points to, the frontend then calls the API with the token
+func Link(path string, token string) string {
+	base := appURL
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(base, "/"), path, url.QueryEscape(token))
+}
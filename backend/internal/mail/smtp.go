@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"harmony/utils"
+)
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(host string, port int, username string, password string, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr: fmt.Sprintf("%s:%d", host, port),
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	body, err := encode(m.from, msg)
+	if err != nil {
+		return err
+	}
+	sender, err := address(m.from)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, sender, []string{msg.To}, body)
+}
+
+// encode builds the RFC 5322 message with a text and an html alternative
+func encode(from string, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@harmony>\r\n", utils.GetRandomToken(16))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, p := range parts {
+		if p.content == "" {
+			continue
+		}
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
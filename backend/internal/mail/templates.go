@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+const (
+	TemplateVerify = "verify"
+	TemplateReset  = "reset"
+)
+
+// every mail has its own set as the blocks have the same names
+type mailTemplate struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+var templates = map[string]mailTemplate{}
+
+func init() {
+	for _, name := range []string{TemplateVerify, TemplateReset} {
+		file := "templates/" + name + ".tmpl"
+		templates[name] = mailTemplate{
+			text: template.Must(template.ParseFS(templatesFS, file)),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templatesFS, file)),
+		}
+	}
+}
+
+// Render fills the subject, text and html blocks of templates/<name>.tmpl, html values are escaped
+func Render(name string, to string, data any) (Message, error) {
+	msg := Message{To: to}
+
+	t, ok := templates[name]
+	if !ok {
+		return msg, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return msg, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return msg, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "html", data); err != nil {
+		return msg, err
+	}
+
+	msg.Subject = strings.TrimSpace(subject.String())
+	msg.Text = text.String()
+	msg.HTML = html.String()
+
+	return msg, nil
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+
+	"harmony/internal/database"
+	"harmony/modules/server"
+	"harmony/modules/user"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// All lists the migrations of the harmony database, a new one takes the next version
+// and the applied ones must never change
+func All() []database.Migration {
+	return []database.Migration{
+		{
+			Version: 1,
+			Name:    "members",
+			// roles lists and nicknames don't fit the old maps, so there is no way back
+			Up: func(ctx context.Context, client *mongo.Client) error {
+				_, err := server.NewRepository(client).MigrateMembers(ctx)
+				return err
+			},
+		},
+		{
+			Version: 2,
+			Name:    "unique identities",
+			Up: func(ctx context.Context, client *mongo.Client) error {
+				return user.NewRepository(client).MigrateUniqueIdentities(ctx)
+			},
+			// the previous release declares the index allowing duplicates
+			Down: func(ctx context.Context, client *mongo.Client) error {
+				return user.NewRepository(client).DropUniqueIdentities(ctx)
+			},
+		},
+	}
+}
+
+// Up applies the pending migrations, the instances started together wait for the first one to finish
+func Up(ctx context.Context, client *mongo.Client) ([]database.Migration, error) {
+	migrator, err := database.NewMigrator(client, All())
+	if err != nil {
+		return nil, err
+	}
+	return migrator.Up(ctx)
+}
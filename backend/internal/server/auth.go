@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"harmony/modules/session"
+	"harmony/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// accessTTL is how long an access token is valid, the refresh token gets a new one
+const accessTTL = 15 * time.Minute
+
+// revocationsTTL is how long whether a session is active is cached, the access tokens of a
+// session revoked on another instance keep working up to this long
+const revocationsTTL = 30 * time.Second
+
+const (
+	refreshCookie    = "refresh_token"
+	loginStateCookie = "login_state"
+)
+
+// setTokens signs an access token for the session and sets both tokens as cookies
+func (s *Server) setTokens(c *gin.Context, sess *session.Session, refreshToken string) (gin.H, error) {
+	now := time.Now()
+	newClaims := jwt.MapClaims{
+		"sub": sess.UserID,
+		"sid": sess.ID.Hex(),
+		"exp": now.Add(accessTTL).Unix(),
+		"aud": s.auth.Audience,
+		"nbf": now.Add(time.Minute * -5).Unix(),
+		"iat": now.Unix(),
+	}
+	signedToken, err := s.auth.Keys.Sign(newClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetCookie("token", signedToken, int(accessTTL.Seconds()), "/", s.host, false, true)
+	c.SetCookie(refreshCookie, refreshToken, int(session.RefreshTTL.Seconds()), "/auth", s.host, false, true)
+
+	return gin.H{
+		"id_token":      signedToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTTL.Seconds()),
+	}, nil
+}
+
+// issueTokens is setTokens answering with the tokens
+func (s *Server) issueTokens(c *gin.Context, sess *session.Session, refreshToken string) {
+	result, err := s.setTokens(c, sess, refreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed in token sign"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) clearTokens(c *gin.Context) {
+	c.SetCookie("token", "", -1, "/", s.host, false, true)
+	c.SetCookie(refreshCookie, "", -1, "/auth", s.host, false, true)
+}
+
+// readRefreshToken reads the refresh token from the body, falling back to the cookie
+func readRefreshToken(c *gin.Context) string {
+	type RequestBody struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	var rb RequestBody
+
+	if err := c.ShouldBindJSON(&rb); err == nil && rb.RefreshToken != "" {
+		return rb.RefreshToken
+	}
+	token, err := c.Cookie(refreshCookie)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+func (s *Server) refreshHandler(c *gin.Context) {
+	token := readRefreshToken(c)
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token not found"})
+		return
+	}
+
+	sess, newToken, err := s.sessions.Rotate(token, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		if errors.Is(err, session.ErrTokenReused) {
+			s.clearTokens(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reused, session revoked"})
+			return
+		}
+		if errors.Is(err, session.ErrInvalidToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	s.issueTokens(c, sess, newToken)
+}
+
+func (s *Server) logoutHandler(c *gin.Context) {
+	token := readRefreshToken(c)
+	if token != "" {
+		sess, err := s.sessions.ReadByToken(token)
+		if err == nil {
+			if err := s.sessions.Revoke(sess); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+				return
+			}
+			s.revocations.Forget(sess.ID.Hex())
+		}
+	}
+
+	s.clearTokens(c)
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) logoutAllHandler(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	err := s.sessions.RevokeByUser(sub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	s.revocations.ForgetUser(sub)
+
+	s.clearTokens(c)
+	c.Status(http.StatusNoContent)
+}
+
+// redirectTarget accepts a path of the frontend or an absolute url on one of the allowed origins,
+// anything else could send the user and its fresh cookies to another site
+func redirectTarget(raw string) (string, bool) {
+	if raw == "" {
+		return "", true
+	}
+
+	target, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	if target.Scheme == "" && target.Host == "" {
+		if !strings.HasPrefix(target.Path, "/") || strings.HasPrefix(raw, "//") {
+			return "", false
+		}
+		return allowedOrigins[0] + target.RequestURI(), true
+	}
+
+	origin := fmt.Sprintf("%s://%s", target.Scheme, target.Host)
+	if !utils.Contains(allowedOrigins, origin) {
+		return "", false
+	}
+	return target.String(), true
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"harmony/modules/session"
+)
+
+// newSession logs the account in and returns its refresh token
+func newSession(t *testing.T, s *Server, a account) string {
+	t.Helper()
+
+	sess, refreshToken := session.NewSession(a.id, "test", "127.0.0.1")
+	if err := s.sessions.Create(&sess); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	return refreshToken
+}
+
+// refresh rotates the refresh token, returning the new pair
+func refresh(t *testing.T, handler http.Handler, refreshToken string) (int, string, string) {
+	t.Helper()
+
+	status, response := request(t, handler, "", http.MethodPost, "/auth/refresh", map[string]string{"refresh_token": refreshToken})
+	if status != http.StatusOK {
+		return status, "", ""
+	}
+	return status, response["id_token"].(string), response["refresh_token"].(string)
+}
+
+func TestRefreshRotation(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+	first := newSession(t, s, alice)
+
+	status, access, second := refresh(t, handler, first)
+	if status != http.StatusOK {
+		t.Fatalf("expected the refresh to succeed, got %d", status)
+	}
+	if second == first {
+		t.Fatal("expected the refresh token to be rotated")
+	}
+	status, response := request(t, handler, access, http.MethodGet, "/users/@me", nil)
+	expect(t, status, response, http.StatusOK)
+
+	status, _, third := refresh(t, handler, second)
+	if status != http.StatusOK {
+		t.Fatalf("expected the rotated token to refresh, got %d", status)
+	}
+
+	// the first token was rotated already, someone else has a copy
+	status, response = request(t, handler, "", http.MethodPost, "/auth/refresh", map[string]string{"refresh_token": first})
+	expect(t, status, response, http.StatusUnauthorized)
+
+	// the reuse revokes the whole session, current tokens included
+	if status, _, _ := refresh(t, handler, third); status != http.StatusUnauthorized {
+		t.Fatalf("expected the session to be revoked, got %d", status)
+	}
+	status, response = request(t, handler, access, http.MethodGet, "/users/@me", nil)
+	expect(t, status, response, http.StatusUnauthorized)
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	_, handler := newTestServer(t)
+
+	status, response := request(t, handler, "", http.MethodPost, "/auth/refresh", map[string]string{"refresh_token": "unknown"})
+	expect(t, status, response, http.StatusUnauthorized)
+}
+
+func TestLogoutRevokesAccessTokens(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+
+	_, first, refreshToken := refresh(t, handler, newSession(t, s, alice))
+	_, second, _ := refresh(t, handler, newSession(t, s, alice))
+
+	status, response := request(t, handler, "", http.MethodPost, "/auth/logout", map[string]string{"refresh_token": refreshToken})
+	expect(t, status, response, http.StatusNoContent)
+
+	status, response = request(t, handler, first, http.MethodGet, "/users/@me", nil)
+	expect(t, status, response, http.StatusUnauthorized)
+	status, response = request(t, handler, second, http.MethodGet, "/users/@me", nil)
+	expect(t, status, response, http.StatusOK)
+
+	// logging out everywhere ends the other session as well
+	status, response = request(t, handler, second, http.MethodPost, "/auth/logout/all", nil)
+	expect(t, status, response, http.StatusNoContent)
+	status, response = request(t, handler, second, http.MethodGet, "/users/@me", nil)
+	expect(t, status, response, http.StatusUnauthorized)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"harmony/internal/autentication"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const testClientID = "harmony-test"
+
+// fakeProvider is an OIDC provider answering the code exchange, the code is accepted only
+// with the verifier of the challenge received at the login
+type fakeProvider struct {
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	// nonce overrides the nonce of the login in the id token when set
+	nonce string
+}
+
+func newFakeProvider(t *testing.T, s *Server) *fakeProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	p := &fakeProvider{key: key}
+	p.srv = httptest.NewServer(http.HandlerFunc(p.token))
+	t.Cleanup(p.srv.Close)
+
+	provider := &autentication.Provider{
+		Name: "test",
+		Oauth2Config: &oauth2.Config{
+			ClientID:    testClientID,
+			RedirectURL: "http://localhost/callback/test",
+			Endpoint:    oauth2.Endpoint{AuthURL: p.srv.URL + "/auth", TokenURL: p.srv.URL + "/token"},
+			Scopes:      []string{oidc.ScopeOpenID, "email"},
+		},
+		OidcVerifier: oidc.NewVerifier(p.srv.URL,
+			&oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{&key.PublicKey}},
+			&oidc.Config{ClientID: testClientID}),
+	}
+	s.auth.Providers = map[string]*autentication.Provider{provider.Name: provider}
+	return p
+}
+
+// login starts a login, returning the state cookie and the query of the provider url
+func (p *fakeProvider) login(t *testing.T, handler http.Handler) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login/test", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected a redirect to the provider, got %d", w.Code)
+	}
+	target, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location: %v", err)
+	}
+	query := target.Query()
+	p.challenge = query.Get("code_challenge")
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == loginStateCookie {
+			return cookie, query
+		}
+	}
+	t.Fatal("expected the login state cookie")
+	return nil, nil
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if r.FormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                p.srv.URL,
+		"aud":                testClientID,
+		"sub":                "alice-subject",
+		"nonce":              p.nonce,
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"preferred_username": "alice",
+		"exp":                now.Add(time.Minute).Unix(),
+		"iat":                now.Unix(),
+	})
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func callback(handler http.Handler, cookie *http.Cookie, state string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/callback/test?code=code&state="+url.QueryEscape(state), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestCallback(t *testing.T) {
+	s, handler := newTestServer(t)
+	p := newFakeProvider(t, s)
+
+	cookie, query := p.login(t, handler)
+	p.nonce = query.Get("nonce")
+
+	w := callback(handler, cookie, query.Get("state"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d %s", w.Code, w.Body.String())
+	}
+	var response map[string]any
+	json.Unmarshal(w.Body.Bytes(), &response)
+	access, _ := response["id_token"].(string)
+	status, me := request(t, handler, access, http.MethodGet, "/users/@me", nil)
+	expect(t, status, me, http.StatusOK)
+	if me["mail"] != "alice@example.com" {
+		t.Fatalf("expected the user of the id token, got %v", me)
+	}
+}
+
+func TestCallbackRejected(t *testing.T) {
+	t.Run("no login state", func(t *testing.T) {
+		s, handler := newTestServer(t)
+		p := newFakeProvider(t, s)
+		_, query := p.login(t, handler)
+		p.nonce = query.Get("nonce")
+
+		if w := callback(handler, nil, query.Get("state")); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		s, handler := newTestServer(t)
+		p := newFakeProvider(t, s)
+		cookie, query := p.login(t, handler)
+		p.nonce = query.Get("nonce")
+
+		if w := callback(handler, cookie, "other"); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		s, handler := newTestServer(t)
+		p := newFakeProvider(t, s)
+		cookie, query := p.login(t, handler)
+		// the id token was issued for another login
+		p.nonce = "other"
+
+		if w := callback(handler, cookie, query.Get("state")); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("verifier of another login", func(t *testing.T) {
+		s, handler := newTestServer(t)
+		p := newFakeProvider(t, s)
+		cookie, query := p.login(t, handler)
+		// the code was requested with the challenge of a later login
+		p.login(t, handler)
+		p.nonce = query.Get("nonce")
+
+		if w := callback(handler, cookie, query.Get("state")); w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected the exchange to fail, got %d", w.Code)
+		}
+	})
+}
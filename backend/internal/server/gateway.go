@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"harmony/internal/gateway"
+	"harmony/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || utils.Contains(allowedOrigins, origin)
+	},
+}
+
+func (s *Server) gatewayHandler(c *gin.Context) {
+	// browsers cannot set headers on websocket requests, they send the token in the identify frame
+	// or the cookie. The query string is never read as it ends up in the access log
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if tokenString == "" {
+		tokenString, _ = c.Cookie("token")
+	}
+
+	var claims jwt.MapClaims
+	if tokenString != "" {
+		var err error
+		claims, err = s.authenticate(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !checkScope(c, claims, scopeGateway) {
+			return
+		}
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader already replied to the client
+		return
+	}
+
+	if claims == nil {
+		claims, err = s.identify(conn)
+		if err != nil {
+			gateway.Reject(conn, err.Error())
+			return
+		}
+	}
+	c.Set("claims", claims)
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		gateway.Reject(conn, "Failed to retreive user")
+		return
+	}
+
+	// subscribe to every server the user is member of
+	servers, err := s.store.Servers.ReadByMember(sub)
+	if err != nil {
+		gateway.Reject(conn, "Failed to retreive servers")
+		return
+	}
+	serverIds := make([]string, 0, len(servers))
+	for _, server := range servers {
+		serverIds = append(serverIds, server.ID.Hex())
+	}
+
+	s.hub.Serve(conn, sub, serverIds)
+}
+
+// identify authenticates a connection opened without credentials with the token of its first frame
+func (s *Server) identify(conn *websocket.Conn) (jwt.MapClaims, error) {
+	tokenString, err := gateway.ReadIdentify(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.authenticate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !hasScope(claims, scopeGateway, http.MethodGet) {
+		return nil, errors.New("Token scope not sufficient")
+	}
+	return claims, nil
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"harmony/modules/command"
+	"harmony/modules/incoming"
+	"harmony/modules/server"
+	"harmony/modules/token"
+	"harmony/modules/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// account is a user of the test server with a token of every scope
+type account struct {
+	id    string
+	token string
+}
+
+func newAccount(t *testing.T, s *Server, name string) account {
+	t.Helper()
+
+	u := user.NewUser(name, name+"@example.com")
+	if err := s.store.Users.Create(&u); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := s.store.Users.MarkVerified(&u); err != nil {
+		t.Fatalf("verify user: %v", err)
+	}
+	return account{id: u.UniqueName, token: newToken(t, s, u.UniqueName, token.Scopes...)}
+}
+
+// request sends the body as json and decodes the response
+func request(t *testing.T, handler http.Handler, tokenValue string, method string, path string, body any) (int, map[string]any) {
+	t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/json")
+	if tokenValue != "" {
+		req.Header.Set("Authorization", "Bearer "+tokenValue)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	response := map[string]any{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+// expect fails the test when the status is not the expected one
+func expect(t *testing.T, status int, response map[string]any, expected int) {
+	t.Helper()
+
+	if status != expected {
+		t.Fatalf("expected %d, got %d %v", expected, status, response)
+	}
+}
+
+func mustObjectID(t *testing.T, id string) primitive.ObjectID {
+	t.Helper()
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		t.Fatalf("object id: %v", err)
+	}
+	return objectId
+}
+
+// newGuild creates a server of the owner with a text channel, the other accounts join it with an invite
+func newGuild(t *testing.T, handler http.Handler, owner account, members ...account) (string, string) {
+	t.Helper()
+
+	status, srv := request(t, handler, owner.token, http.MethodPost, "/servers/", map[string]any{"name": "guild"})
+	expect(t, status, srv, http.StatusCreated)
+	id := srv["id"].(string)
+
+	status, ch := request(t, handler, owner.token, http.MethodPost, "/servers/"+id+"/channels/", map[string]any{"name": "general", "type": "text"})
+	expect(t, status, ch, http.StatusCreated)
+
+	status, invite := request(t, handler, owner.token, http.MethodPost, "/servers/"+id+"/invites/", map[string]any{})
+	expect(t, status, invite, http.StatusCreated)
+	for _, member := range members {
+		status, response := request(t, handler, member.token, http.MethodPost, "/invites/"+invite["code"].(string), nil)
+		expect(t, status, response, http.StatusOK)
+	}
+
+	return id, ch["id"].(string)
+}
+
+func TestRegistrationConflict(t *testing.T) {
+	s, handler := newTestServer(t)
+
+	body := map[string]any{"name": "alice", "mail": "alice@example.com"}
+	status, response := request(t, handler, "", http.MethodPost, "/user/registration/", body)
+	expect(t, status, response, http.StatusCreated)
+
+	status, response = request(t, handler, "", http.MethodPost, "/user/registration/", body)
+	expect(t, status, response, http.StatusConflict)
+
+	// the unique index catches what the handler check can't see, like a registration meanwhile
+	duplicate := user.NewUser("other", "alice@example.com")
+	if err := s.store.Users.Create(&duplicate); !errors.Is(err, user.ErrMailTaken) {
+		t.Fatalf("expected %v, got %v", user.ErrMailTaken, err)
+	}
+
+	// bots have no mail and don't collide
+	for range 2 {
+		bot := user.NewBot("bot", "alice")
+		if err := s.store.Users.Create(&bot); err != nil {
+			t.Fatalf("create bot: %v", err)
+		}
+	}
+}
+
+func TestMembership(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+	bob := newAccount(t, s, "bob")
+	carol := newAccount(t, s, "carol")
+
+	id, _ := newGuild(t, handler, alice, bob)
+
+	members := "/servers/" + id + "/members"
+	status, response := request(t, handler, bob.token, http.MethodGet, members, nil)
+	expect(t, status, response, http.StatusOK)
+	status, response = request(t, handler, carol.token, http.MethodGet, members, nil)
+	expect(t, status, response, http.StatusUnauthorized)
+
+	// joining twice is refused by the handler and by the unique index
+	status, invite := request(t, handler, alice.token, http.MethodPost, "/servers/"+id+"/invites/", map[string]any{})
+	expect(t, status, invite, http.StatusCreated)
+	status, response = request(t, handler, bob.token, http.MethodPost, "/invites/"+invite["code"].(string), nil)
+	expect(t, status, response, http.StatusBadRequest)
+
+	srv, err := s.store.Servers.Read(mustObjectID(t, id))
+	if err != nil {
+		t.Fatalf("read server: %v", err)
+	}
+	if err := s.store.Servers.AddMember(srv, bob.id, server.RoleMember); !errors.Is(err, server.ErrAlreadyMember) {
+		t.Fatalf("expected %v, got %v", server.ErrAlreadyMember, err)
+	}
+
+	status, response = request(t, handler, bob.token, http.MethodPost, "/servers/"+id+"/leave", nil)
+	if status >= 300 {
+		t.Fatalf("leave: %d %v", status, response)
+	}
+	status, response = request(t, handler, bob.token, http.MethodGet, members, nil)
+	expect(t, status, response, http.StatusUnauthorized)
+}
+
+func TestChannelPermissions(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+	bob := newAccount(t, s, "bob")
+	carol := newAccount(t, s, "carol")
+
+	id, channelId := newGuild(t, handler, alice, bob)
+	messages := "/servers/" + id + "/channels/" + channelId + "/messages/"
+
+	status, response := request(t, handler, bob.token, http.MethodPost, messages, map[string]any{"content": "hello"})
+	expect(t, status, response, http.StatusCreated)
+	status, response = request(t, handler, carol.token, http.MethodGet, messages, nil)
+	expect(t, status, response, http.StatusUnauthorized)
+
+	// bob can still read but no longer send
+	overwrite := fmt.Sprintf("/servers/%s/channels/%s/overwrites/%s", id, channelId, bob.id)
+	status, response = request(t, handler, alice.token, http.MethodPut, overwrite, map[string]any{"type": "member", "deny": server.PermissionSendMessages})
+	if status >= 300 {
+		t.Fatalf("set overwrite: %d %v", status, response)
+	}
+	status, response = request(t, handler, bob.token, http.MethodPost, messages, map[string]any{"content": "hello again"})
+	expect(t, status, response, http.StatusUnauthorized)
+	status, response = request(t, handler, bob.token, http.MethodGet, messages, nil)
+	expect(t, status, response, http.StatusOK)
+
+	// the overwrite of a user outside the server is refused
+	outsider := fmt.Sprintf("/servers/%s/channels/%s/overwrites/%s", id, channelId, carol.id)
+	status, response = request(t, handler, alice.token, http.MethodPut, outsider, map[string]any{"type": "member", "deny": server.PermissionSendMessages})
+	expect(t, status, response, http.StatusBadRequest)
+}
+
+func TestCommandNameTaken(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+
+	bot := user.NewBot("bot", alice.id)
+	if err := s.store.Users.Create(&bot); err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	botToken := newToken(t, s, bot.UniqueName, token.ScopeServersRead, token.ScopeServersWrite)
+
+	id, _ := newGuild(t, handler, alice)
+	srv, err := s.store.Servers.Read(mustObjectID(t, id))
+	if err != nil {
+		t.Fatalf("read server: %v", err)
+	}
+	if err := s.store.Servers.AddMember(srv, bot.UniqueName, server.RoleMember); err != nil {
+		t.Fatalf("add bot: %v", err)
+	}
+
+	commands := "/servers/" + id + "/commands/"
+	status, ping := request(t, handler, botToken, http.MethodPost, commands, map[string]any{"name": "ping", "description": "Answers pong"})
+	expect(t, status, ping, http.StatusCreated)
+	status, response := request(t, handler, botToken, http.MethodPost, commands, map[string]any{"name": "ping", "description": "Again"})
+	expect(t, status, response, http.StatusConflict)
+
+	status, echo := request(t, handler, botToken, http.MethodPost, commands, map[string]any{"name": "echo", "description": "Repeats"})
+	expect(t, status, echo, http.StatusCreated)
+	status, response = request(t, handler, botToken, http.MethodPatch, commands+echo["id"].(string), map[string]any{"name": "ping"})
+	expect(t, status, response, http.StatusConflict)
+
+	// renaming to its own name is not a conflict
+	status, response = request(t, handler, botToken, http.MethodPatch, commands+echo["id"].(string), map[string]any{"name": "echo", "description": "Repeats the text"})
+	expect(t, status, response, http.StatusOK)
+
+	// only bots register commands
+	status, response = request(t, handler, alice.token, http.MethodPost, commands, map[string]any{"name": "help", "description": "Helps"})
+	expect(t, status, response, http.StatusUnauthorized)
+
+	if _, err := s.store.Commands.ReadByName(srv.ID, "ping"); err != nil {
+		t.Fatalf("read command: %v", err)
+	}
+	cmd := command.NewCommand(srv.ID, bot.UniqueName, "ping", "Duplicate", nil)
+	if err := s.store.Commands.Create(&cmd); !errors.Is(err, command.ErrNameTaken) {
+		t.Fatalf("expected %v, got %v", command.ErrNameTaken, err)
+	}
+}
+
+func TestCreateChannelOverwrites(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+	bob := newAccount(t, s, "bob")
+
+	id, _ := newGuild(t, handler, alice, bob)
+
+	// bob manages the channels and the roles but not the webhooks
+	srv, err := s.store.Servers.Read(mustObjectID(t, id))
+	if err != nil {
+		t.Fatalf("read server: %v", err)
+	}
+	manager := server.NewRole("manager", 0, server.PermissionDefault|server.PermissionManageChannels|server.PermissionManageRoles)
+	srv.Roles = append(srv.Roles, manager)
+	if err := s.store.Servers.Update(srv); err != nil {
+		t.Fatalf("update server: %v", err)
+	}
+	if err := s.store.Servers.SetRoles(srv, bob.id, []string{server.RoleMember, manager.ID.Hex()}); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		allow  server.Permission
+		deny   server.Permission
+		status int
+	}{
+		{"permission held", server.PermissionSendMessages, 0, http.StatusCreated},
+		{"deny held", 0, server.PermissionReadMessages, http.StatusCreated},
+		{"permission not held", server.PermissionManageWebhooks, 0, http.StatusUnauthorized},
+		{"administrator", server.PermissionAdministrator, 0, http.StatusUnauthorized},
+		{"deny not held", 0, server.PermissionManageWebhooks, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]any{
+				"name":       "private",
+				"overwrites": []map[string]any{{"id": bob.id, "type": "member", "allow": tt.allow, "deny": tt.deny}},
+			}
+			status, response := request(t, handler, bob.token, http.MethodPost, "/servers/"+id+"/channels/", body)
+			expect(t, status, response, tt.status)
+		})
+	}
+}
+
+func TestUpdateChannel(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+
+	id, channelId := newGuild(t, handler, alice)
+	status, second := request(t, handler, alice.token, http.MethodPost, "/servers/"+id+"/channels/", map[string]any{"name": "second", "topic": "kept"})
+	expect(t, status, second, http.StatusCreated)
+	path := "/servers/" + id + "/channels/" + second["id"].(string)
+
+	// a patch changes only the fields it has
+	status, response := request(t, handler, alice.token, http.MethodPatch, path, map[string]any{"name": "renamed"})
+	expect(t, status, response, http.StatusOK)
+	if response["name"] != "renamed" || response["topic"] != "kept" {
+		t.Fatalf("expected the topic kept, got %v", response)
+	}
+	status, response = request(t, handler, alice.token, http.MethodPatch, path, map[string]any{"topic": ""})
+	expect(t, status, response, http.StatusOK)
+	if response["name"] != "renamed" || response["topic"] != "" {
+		t.Fatalf("expected the topic cleared, got %v", response)
+	}
+	status, response = request(t, handler, alice.token, http.MethodPatch, path, map[string]any{"name": ""})
+	expect(t, status, response, http.StatusBadRequest)
+
+	// a move by a channel read before another move still leaves the positions contiguous
+	general, err := s.store.Channels.Read(mustObjectID(t, channelId))
+	if err != nil {
+		t.Fatalf("read channel: %v", err)
+	}
+	status, response = request(t, handler, alice.token, http.MethodPatch, path, map[string]any{"position": 0})
+	expect(t, status, response, http.StatusOK)
+	if err := s.store.Channels.Move(general, 1); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+
+	channels, err := s.store.Channels.ReadByServer(mustObjectID(t, id))
+	if err != nil {
+		t.Fatalf("read channels: %v", err)
+	}
+	for i, ch := range channels {
+		if ch.Position != i {
+			t.Fatalf("expected contiguous positions, got %d at %d", ch.Position, i)
+		}
+	}
+}
+
+func TestDeleteCascade(t *testing.T) {
+	s, handler := newTestServer(t)
+	owner := newAccount(t, s, "owner")
+	serverId, channelId := newGuild(t, handler, owner)
+
+	status, second := request(t, handler, owner.token, http.MethodPost, "/servers/"+serverId+"/channels/", map[string]any{"name": "alerts", "type": "text"})
+	expect(t, status, second, http.StatusCreated)
+
+	// the webhooks are managed with session tokens only, they are stored directly
+	newIncoming := func(channelId string) primitive.ObjectID {
+		webhook, _ := incoming.NewWebhook(mustObjectID(t, serverId), mustObjectID(t, channelId), "ci", "", owner.id)
+		if err := s.store.Incoming.Create(&webhook); err != nil {
+			t.Fatalf("create webhook: %v", err)
+		}
+		return webhook.ID
+	}
+	first := newIncoming(channelId)
+	other := newIncoming(second["id"].(string))
+
+	cmd := command.Command{ServerID: mustObjectID(t, serverId), BotID: "bot", Name: "deploy"}
+	if err := s.store.Commands.Create(&cmd); err != nil {
+		t.Fatalf("create command: %v", err)
+	}
+
+	// the webhooks of a deleted channel go with it
+	status, response := request(t, handler, owner.token, http.MethodDelete, "/servers/"+serverId+"/channels/"+channelId, nil)
+	expect(t, status, response, http.StatusNoContent)
+	if _, err := s.store.Incoming.Read(first); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("expected the webhook of the channel to be deleted, got %v", err)
+	}
+	if _, err := s.store.Incoming.Read(other); err != nil {
+		t.Fatalf("expected the webhook of the other channel to stay, got %v", err)
+	}
+
+	// and everything of a deleted server
+	status, response = request(t, handler, owner.token, http.MethodDelete, "/servers/"+serverId, nil)
+	expect(t, status, response, http.StatusNoContent)
+	if _, err := s.store.Incoming.Read(other); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("expected the webhook of the server to be deleted, got %v", err)
+	}
+	if _, err := s.store.Commands.Read(cmd.ID); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("expected the command of the server to be deleted, got %v", err)
+	}
+}
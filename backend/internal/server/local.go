@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"harmony/modules/session"
+	"harmony/modules/user"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// verifyDummy spends the time of a password check so unknown mails can't be told apart by timing
+func verifyDummy(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = user.HashPassword("harmony-dummy-password")
+	})
+	_, _ = user.VerifyPassword(password, dummyHash)
+}
+
+func (s *Server) localLoginHandler(c *gin.Context) {
+	type RequestBody struct {
+		Mail     string `json:"mail"`
+		Password string `json:"password"`
+	}
+	var rb RequestBody
+
+	if !s.auth.Local {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Local authentication is disabled"})
+		return
+	}
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search user
+	userRepo := s.store.Users
+	account, err := userRepo.ReadByMail(rb.Mail)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed in get user"})
+			return
+		}
+		verifyDummy(rb.Password)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+	if !account.HasPassword() {
+		verifyDummy(rb.Password)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+	if account.IsLocked() {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, retry later"})
+		return
+	}
+
+	isValid, err := user.VerifyPassword(rb.Password, account.PasswordHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify password"})
+		return
+	}
+	if !isValid {
+		if err := userRepo.RecordLoginFailure(account); err != nil {
+			log.Printf("failed to record login failure of %s: %v", account.UniqueName, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+	if err := userRepo.ResetLoginFailures(account); err != nil {
+		log.Printf("failed to reset login failures of %s: %v", account.UniqueName, err)
+	}
+
+	sess, refreshToken := session.NewSession(account.UniqueName, c.Request.UserAgent(), c.ClientIP())
+	err = s.sessions.Create(&sess)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	s.issueTokens(c, &sess, refreshToken)
+}
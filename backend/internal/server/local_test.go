@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"harmony/internal/mail"
+	"harmony/modules/user"
+)
+
+const testPassword = "correct horse battery"
+
+// newLocalTestServer is newTestServer with the local login enabled
+func newLocalTestServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+
+	s, _ := newTestServer(t)
+	// the handlers read the setting when the routes are registered
+	s.auth.Local = true
+	return s, s.RegisterRoutes()
+}
+
+// newLocalAccount creates a user logging in with a password
+func newLocalAccount(t *testing.T, s *Server, name string) *user.User {
+	t.Helper()
+
+	u := user.NewUser(name, name+"@example.com")
+	if err := u.SetPassword(testPassword); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if err := s.store.Users.Create(&u); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return &u
+}
+
+func login(t *testing.T, handler http.Handler, mail string, password string) int {
+	t.Helper()
+
+	status, _ := request(t, handler, "", http.MethodPost, "/auth/login", map[string]string{"mail": mail, "password": password})
+	return status
+}
+
+var tokenParam = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+// lastToken is the token of the link in the last mail sent
+func lastToken(t *testing.T, s *Server) string {
+	t.Helper()
+
+	messages := s.mailer.(*mail.MemoryMailer).Messages()
+	if len(messages) == 0 {
+		t.Fatal("expected a mail")
+	}
+	match := tokenParam.FindStringSubmatch(messages[len(messages)-1].Text)
+	if match == nil {
+		t.Fatal("expected a link with a token in the mail")
+	}
+	return match[1]
+}
+
+func TestLocalLoginDisabled(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newLocalAccount(t, s, "alice")
+
+	if status := login(t, handler, alice.Mail, testPassword); status != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", status)
+	}
+}
+
+func TestLocalLoginLockout(t *testing.T) {
+	s, handler := newLocalTestServer(t)
+	alice := newLocalAccount(t, s, "alice")
+
+	if status := login(t, handler, alice.Mail, testPassword); status != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d", status)
+	}
+
+	// a successful login starts the count again
+	for i := 0; i < user.MaxFailedLogins-1; i++ {
+		if status := login(t, handler, alice.Mail, "wrong password"); status != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", status)
+		}
+	}
+	if status := login(t, handler, alice.Mail, testPassword); status != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d", status)
+	}
+
+	for i := 0; i < user.MaxFailedLogins; i++ {
+		if status := login(t, handler, alice.Mail, "wrong password"); status != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", status)
+		}
+	}
+	// the right password doesn't help while the account is locked
+	if status := login(t, handler, alice.Mail, testPassword); status != http.StatusTooManyRequests {
+		t.Fatalf("expected the account to be locked, got %d", status)
+	}
+
+	// other accounts are not affected
+	bob := newLocalAccount(t, s, "bob")
+	if status := login(t, handler, bob.Mail, testPassword); status != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d", status)
+	}
+}
+
+func TestLocalLoginUnknownUser(t *testing.T) {
+	s, handler := newLocalTestServer(t)
+	// a user logging in only with a provider has no password to guess
+	newAccount(t, s, "alice")
+
+	if status := login(t, handler, "alice@example.com", ""); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", status)
+	}
+	if status := login(t, handler, "nobody@example.com", testPassword); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", status)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	s, handler := newLocalTestServer(t)
+	alice := newLocalAccount(t, s, "alice")
+
+	for i := 0; i < user.MaxFailedLogins; i++ {
+		login(t, handler, alice.Mail, "wrong password")
+	}
+	if status := login(t, handler, alice.Mail, testPassword); status != http.StatusTooManyRequests {
+		t.Fatalf("expected the account to be locked, got %d", status)
+	}
+
+	status, response := request(t, handler, "", http.MethodPost, "/user/password/reset", map[string]string{"mail": alice.Mail})
+	expect(t, status, response, http.StatusAccepted)
+	resetToken := lastToken(t, s)
+
+	confirm := map[string]string{"token": resetToken, "password": "another long password"}
+	status, response = request(t, handler, "", http.MethodPost, "/user/password/reset/confirm", confirm)
+	expect(t, status, response, http.StatusNoContent)
+
+	// the new password works at once, the reset clears the lockout
+	if status := login(t, handler, alice.Mail, testPassword); status != http.StatusUnauthorized {
+		t.Fatalf("expected the old password to be refused, got %d", status)
+	}
+	if status := login(t, handler, alice.Mail, "another long password"); status != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d", status)
+	}
+
+	// the token can be used once
+	confirm["password"] = "a third long password"
+	status, response = request(t, handler, "", http.MethodPost, "/user/password/reset/confirm", confirm)
+	expect(t, status, response, http.StatusBadRequest)
+}
@@ -2,42 +2,118 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"crypto/subtle"
+	"errors"
+	"log"
 	"net/http"
-	"time"
+	"strings"
 
+	"harmony/internal/autentication"
+	"harmony/modules/channel"
+	"harmony/modules/command"
+	"harmony/modules/incoming"
+	"harmony/modules/invite"
+	"harmony/modules/message"
 	"harmony/modules/server"
+	"harmony/modules/session"
+	"harmony/modules/token"
 	"harmony/modules/user"
+	"harmony/modules/webhook"
+	"harmony/utils"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/oauth2"
 )
 
+// Add your frontend URL
+var allowedOrigins = []string{"http://localhost:5173"}
+
 func (s *Server) RegisterRoutes() http.Handler {
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:5173"}, // Add your frontend URL
+		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
 		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type"},
 		AllowCredentials: true, // Enable cookies/auth
 	}))
 
 	r.GET("/health", s.healthHandler)
+	r.GET("/.well-known/jwks.json", s.jwksHandler)
 	r.GET("/login", s.loginHandler)
+	r.GET("/login/:provider", s.loginHandler)
 	r.GET("/callback", s.callbackHandler)
+	r.GET("/callback/:provider", s.callbackHandler)
+	r.POST("/users/@me/identities/:provider", s.authMiddleware(scopeNone), s.linkHandler)
+	r.POST("/auth/login", s.localLoginHandler)
+	r.POST("/auth/refresh", s.refreshHandler)
+	r.POST("/auth/logout", s.logoutHandler)
+	r.POST("/auth/logout/all", s.authMiddleware(scopeNone), s.logoutAllHandler)
+	r.GET("/gateway", s.gatewayHandler)
+	r.GET("/servers/:id/voice", s.authMiddleware(scopeServers), s.voiceStatesHandler)
 
-	serverHandler := server.NewHandler(&s.db)
-	serverGroup := r.Group("/servers", s.authMiddleware())
+	serverHandler := server.NewHandler(s.store.Servers, s.store.Users, s.membership, s.bus)
+	serverGroup := r.Group("/servers", s.authMiddleware(scopeServers))
 	server.RegisterRoutes(serverGroup, serverHandler)
+	userServerGroup := r.Group("/users/@me/servers", s.authMiddleware(scopeServers))
+	server.RegisterUserRoutes(userServerGroup, serverHandler)
+
+	channelHandler := channel.NewHandler(s.store.Channels, s.membership)
+	channelGroup := r.Group("/servers/:id/channels", s.authMiddleware(scopeServers))
+	channel.RegisterRoutes(channelGroup, channelHandler)
+
+	inviteHandler := invite.NewHandler(s.store.Invites, s.store.Servers, s.store.Channels, s.store.Users, s.membership, s.bus)
+	inviteGroup := r.Group("/invites", s.authMiddleware(scopeServers))
+	invite.RegisterRoutes(inviteGroup, inviteHandler)
+	inviteServerGroup := r.Group("/servers/:id/invites", s.authMiddleware(scopeServers))
+	invite.RegisterServerRoutes(inviteServerGroup, inviteHandler)
+
+	webhookHandler := webhook.NewHandler(s.store.Webhooks, s.membership)
+	webhookGroup := r.Group("/servers/:id/webhooks", s.authMiddleware(scopeNone))
+	webhook.RegisterRoutes(webhookGroup, webhookHandler)
+
+	// gli incoming webhook si autenticano con il token nel path
+	incomingHandler := incoming.NewHandler(s.store.Incoming, s.store.Channels, s.store.Messages, s.membership, s.bus)
+	incomingGroup := r.Group("/servers/:id/channels/:channelId/webhooks", s.authMiddleware(scopeNone))
+	incoming.RegisterRoutes(incomingGroup, incomingHandler)
+	incomingExecuteGroup := r.Group("/webhooks")
+	incoming.RegisterRoutesNoAuth(incomingExecuteGroup, incomingHandler)
+
+	// i bot rispondono alle interaction con il token ricevuto
+	commandHandler := command.NewHandler(s.store.Commands, s.store.Channels, s.store.Messages, s.store.Users, s.membership, s.bus)
+	commandGroup := r.Group("/servers/:id/commands", s.authMiddleware(scopeServers))
+	command.RegisterRoutes(commandGroup, commandHandler)
+	interactionGroup := r.Group("/servers/:id/channels/:channelId/interactions", s.authMiddleware(scopeMessages))
+	command.RegisterChannelRoutes(interactionGroup, commandHandler)
+	endpointGroup := r.Group("/users/:id/interactions", s.authMiddleware(scopeNone))
+	command.RegisterEndpointRoutes(endpointGroup, commandHandler)
+	interactionCallbackGroup := r.Group("/interactions")
+	command.RegisterRoutesNoAuth(interactionCallbackGroup, commandHandler)
+
+	messageHandler := message.NewHandler(s.store.Messages, s.store.Channels, s.membership, s.bus)
+	messageGroup := r.Group("/servers/:id/channels/:channelId/messages", s.authMiddleware(scopeMessages))
+	message.RegisterRoutes(messageGroup, messageHandler)
 
-	userHandler := user.NewHandler(&s.db)
+	sessionHandler := session.NewHandler(s.store.Sessions, s.revocations)
+	sessionGroup := r.Group("/auth/sessions", s.authMiddleware(scopeNone))
+	session.RegisterRoutes(sessionGroup, sessionHandler)
+
+	tokenHandler := token.NewHandler(s.store.Tokens, s.store.Users)
+	tokenGroup := r.Group("/users/:id/tokens", s.authMiddleware(scopeNone))
+	token.RegisterRoutes(tokenGroup, tokenHandler)
+
+	userHandler := user.NewHandler(s.store.Users, s.store.Sessions, s.mailer, s.auth.Local)
 	userRegGroup := r.Group("/user/registration")
 	user.RegisterRoutesNoAuth(userRegGroup, userHandler)
-	userGroup := r.Group("/users", s.authMiddleware())
+	userPasswordGroup := r.Group("/user/password")
+	user.RegisterPasswordRoutes(userPasswordGroup, userHandler)
+	userVerifyGroup := r.Group("/user/verify")
+	user.RegisterVerifyRoutes(userVerifyGroup, userHandler)
+	userGroup := r.Group("/users", s.authMiddleware(scopeUsers))
 	user.RegisterRoutes(userGroup, userHandler)
+	userAccountGroup := r.Group("/users/@me", s.authMiddleware(scopeNone))
+	user.RegisterAccountRoutes(userAccountGroup, userHandler)
 
 	return r
 }
@@ -46,18 +122,107 @@ func (s *Server) healthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, s.db.Health())
 }
 
+func (s *Server) jwksHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, s.auth.Keys.JWKS())
+}
+
 func (s *Server) loginHandler(c *gin.Context) {
-	// Genera l'URL per il login
-	url := s.auth.Oauth2Config.AuthCodeURL("state", oauth2.AccessTypeOffline)
+	provider, ok := s.auth.Provider(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+	redirect, ok := redirectTarget(c.Query("redirect"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Redirect not allowed"})
+		return
+	}
+
+	url, err := s.startLogin(c, autentication.NewLoginState(provider.Name, redirect))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
 	c.Redirect(http.StatusFound, url)
 }
 
+// linkHandler starts a login on another provider adding the identity to the user logged in,
+// the frontend has to follow the returned url
+func (s *Server) linkHandler(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+	provider, ok := s.auth.Provider(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+	redirect, ok := redirectTarget(c.Query("redirect"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Redirect not allowed"})
+		return
+	}
+
+	loginState := autentication.NewLoginState(provider.Name, redirect)
+	loginState.LinkUser = sub
+	url, err := s.startLogin(c, loginState)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": url})
+}
+
+// startLogin keeps state, nonce and PKCE verifier in the signed cookie until the callback
+// and returns the provider login url
+func (s *Server) startLogin(c *gin.Context, loginState autentication.LoginState) (string, error) {
+	provider, ok := s.auth.Provider(loginState.Provider)
+	if !ok {
+		return "", errors.New("provider not found")
+	}
+
+	value, err := s.auth.EncodeLoginState(loginState)
+	if err != nil {
+		return "", err
+	}
+	c.SetCookie(loginStateCookie, value, int(autentication.LoginStateTTL.Seconds()), "/callback", s.host, false, true)
+
+	// Genera l'URL per il login
+	return provider.AuthCodeURL(loginState), nil
+}
+
 func (s *Server) callbackHandler(c *gin.Context) {
 	ctx := context.Background()
 
-	// Verifica lo stato (se necessario)
-	state := c.Query("state")
-	if state != "state" {
+	provider, ok := s.auth.Provider(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	// Verifica lo stato
+	value, err := c.Cookie(loginStateCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login state not found"})
+		return
+	}
+	c.SetCookie(loginStateCookie, "", -1, "/callback", s.host, false, true)
+
+	loginState, err := s.auth.DecodeLoginState(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state"})
+		return
+	}
+	if loginState.Provider != provider.Name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider mismatch"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(loginState.State)) != 1 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "State mismatch"})
 		return
 	}
@@ -68,7 +233,7 @@ func (s *Server) callbackHandler(c *gin.Context) {
 		return
 	}
 
-	token, err := s.auth.Oauth2Config.Exchange(ctx, code)
+	token, err := provider.Exchange(ctx, loginState, code)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange token"})
 		return
@@ -80,51 +245,115 @@ func (s *Server) callbackHandler(c *gin.Context) {
 		return
 	}
 
-	idToken, err := s.auth.OidcVerifier.Verify(ctx, rawIDToken)
+	idToken, err := provider.OidcVerifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ID Token"})
 		return
 	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(loginState.Nonce)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nonce mismatch"})
+		return
+	}
 
-	var claims map[string]interface{}
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+	}
 	if err := idToken.Claims(&claims); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse claims"})
 		return
 	}
+	if check, _ := user.IsMailValid(claims.Email); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid email in ID Token"})
+		return
+	}
 
-	email := claims["email"].(string)
+	profile := user.Profile{
+		Provider:          provider.Name,
+		Issuer:            idToken.Issuer,
+		Subject:           idToken.Subject,
+		Mail:              claims.Email,
+		MailVerified:      claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}
+	userRepo := s.store.Users
 
-	userRepo := user.NewRepository(s.db.Mongo)
-	user, err := userRepo.ReadByMail(email)
+	if loginState.LinkUser != "" {
+		s.linkIdentity(c, userRepo, loginState, profile)
+		return
+	}
+
+	// the first login creates the user
+	account, isCreated, err := user.Provision(userRepo, profile)
 	if err != nil {
+		if errors.Is(err, user.ErrMailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Mail already used"})
+			return
+		}
+		if errors.Is(err, user.ErrUniqueNameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Name already taken, try again"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed in get user"})
 		return
 	}
-
-	newClaims := jwt.MapClaims{
-		"sub":   user.UniqueName,
-		"exp":   time.Now().Add(time.Minute * 15).Unix(),
-		"aud":   s.auth.Oauth2Config.ClientID,
-		"nbf":   time.Now().Add(time.Minute * -5).Unix(),
-		"iat":   time.Now().Unix(),
-		"roles": user.Servers,
+	if isCreated && !account.Verified {
+		if err := user.SendVerification(userRepo, s.mailer, account); err != nil {
+			log.Printf("failed to send verification to %s: %v", account.Mail, err)
+		}
 	}
-	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims)
 
-	signedToken, err := newToken.SignedString([]byte(s.auth.Oauth2Config.ClientSecret))
+	sess, refreshToken := session.NewSession(account.UniqueName, c.Request.UserAgent(), c.ClientIP())
+	err = s.sessions.Create(&sess)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	// roles are not part of the token as they change while it is still valid,
+	// handlers resolve them from the members collection
+	if loginState.Redirect == "" {
+		s.issueTokens(c, &sess, refreshToken)
+		return
+	}
+
+	if _, err := s.setTokens(c, &sess, refreshToken); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed in token sign"})
 		return
 	}
+	c.Redirect(http.StatusFound, loginState.Redirect)
+}
 
-	c.SetCookie("token", signedToken, 3600, "/", s.host, false, true)
+// linkIdentity ends a login started by linkHandler, no session is created as the user is already logged in
+func (s *Server) linkIdentity(c *gin.Context, userRepo user.Repository, loginState autentication.LoginState, profile user.Profile) {
+	account, err := userRepo.ReadByUniqueName(loginState.LinkUser)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	err = user.Link(userRepo, account, profile)
+	if err != nil {
+		if errors.Is(err, user.ErrIdentityTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Identity linked to another user"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id_token": signedToken,
-	})
+	if loginState.Redirect != "" {
+		c.Redirect(http.StatusFound, loginState.Redirect)
+		return
+	}
+	c.JSON(http.StatusOK, account.Identities)
 }
 
-func (s *Server) authMiddleware() gin.HandlerFunc {
+// authMiddleware authenticates the request, personal access tokens need the scope of the route
+func (s *Server) authMiddleware(scope routeScope) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -132,29 +361,15 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := authHeader[len("Bearer "):]
-
-		// Parse e verifica il token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Controlla il metodo di firma
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Method)
-			}
-			// Restituisci la chiave usata per verificare
-			return []byte(s.auth.Oauth2Config.ClientSecret), nil
-		})
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		claims, err := s.authenticate(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
-
-		// Recupera i claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid claims"})
-			c.Abort()
+		if !checkScope(c, claims, scope) {
 			return
 		}
 
@@ -163,3 +378,22 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func (s *Server) parseToken(tokenString string) (jwt.MapClaims, error) {
+	// Parse e verifica il token, la chiave è scelta dal kid
+	token, err := jwt.Parse(tokenString, s.auth.Keys.Keyfunc,
+		jwt.WithValidMethods(s.auth.Keys.Methods()),
+		jwt.WithAudience(s.auth.Audience),
+	)
+	if err != nil || !token.Valid {
+		return nil, errors.New("Invalid token")
+	}
+
+	// Recupera i claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("Invalid claims")
+	}
+
+	return claims, nil
+}
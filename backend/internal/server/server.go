@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -11,24 +12,81 @@ import (
 
 	"harmony/internal/autentication"
 	"harmony/internal/database"
+	"harmony/internal/event"
+	"harmony/internal/gateway"
+	"harmony/internal/mail"
+	"harmony/internal/voice"
+	"harmony/modules/command"
+	"harmony/modules/server"
+	"harmony/modules/session"
+	"harmony/modules/token"
+	"harmony/modules/webhook"
 )
 
 type Server struct {
-	port int
-	host string
-	db   database.Service
-	auth autentication.Service
+	port       int
+	host       string
+	db         database.Service
+	store      Storage
+	auth       autentication.Service
+	bus        event.Bus
+	hub        *gateway.Hub
+	voice      *voice.Manager
+	membership *server.Membership
+	sessions   session.Repository
+	// revocations rejects the access tokens of the revoked sessions
+	revocations *session.Revocations
+	tokens      token.Repository
+	mailer      mail.Mailer
+	webhooks    *webhook.Dispatcher
+	reconciler  *server.Reconciler
+	sweeper     *command.Sweeper
 }
 
+// interactionSweepInterval is how often the interactions left pending by a restart are failed
+const interactionSweepInterval = time.Minute
+
 func NewServer() *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+	db, store := newStorage()
+	bus := newEventBus(db)
+	membership := server.NewMembership(store.Servers, membershipCacheTTL())
+	voiceManager := voice.NewManager(voice.NewRepositoryMembership(store.Channels, membership), bus, newVoiceStore(db))
 	NewServer := &Server{
-		port: port,
-		host: os.Getenv("APP_HOST"),
-		db:   database.New(),
-		auth: autentication.New(),
+		port:        port,
+		host:        os.Getenv("APP_HOST"),
+		db:          db,
+		store:       store,
+		auth:        autentication.New(),
+		bus:         bus,
+		hub:         gateway.NewHub(voiceManager),
+		voice:       voiceManager,
+		membership:  membership,
+		sessions:    store.Sessions,
+		revocations: session.NewRevocations(store.Sessions, revocationsTTL),
+		tokens:      store.Tokens,
+		mailer:      mail.New(),
+		webhooks:    webhook.NewDispatcher(store.Webhooks),
+		reconciler:  server.NewReconciler(store.Servers, membershipReconcileInterval()),
+		sweeper:     command.NewSweeper(store.Commands, bus, interactionSweepInterval),
 	}
 
+	// every instance delivers the events to its own websocket clients
+	NewServer.bus.Subscribe(NewServer.membership.HandleEvent)
+	NewServer.bus.Subscribe(NewServer.hub.Deliver)
+	NewServer.bus.Subscribe(NewServer.voice.HandleEvent)
+	NewServer.voice.Start()
+
+	// deliveries are stored, whichever instance enqueues one first owns it
+	NewServer.bus.Subscribe(NewServer.webhooks.HandleEvent)
+	NewServer.webhooks.Start()
+
+	// every instance can repair the drift, the repairs are transactional
+	NewServer.reconciler.Start()
+
+	// the interactions are failed once whichever instance sweeps them
+	NewServer.sweeper.Start()
+
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
@@ -40,3 +98,57 @@ func NewServer() *http.Server {
 
 	return server
 }
+
+// newEventBus picks the event bus from EVENT_BUS, "mongo" is needed when running more than one instance
+func newEventBus(db database.Service) event.Bus {
+	if os.Getenv("EVENT_BUS") != "mongo" {
+		return event.NewLocalBus()
+	}
+
+	bus, err := event.NewMongoBus(db.Mongo)
+	if err != nil {
+		log.Fatalf("Failed to start event bus: %v", err)
+	}
+	return bus
+}
+
+// newVoiceStore shares the voice states through mongo, the memory storage runs a single instance
+func newVoiceStore(db database.Service) voice.Store {
+	if db.Mongo == nil {
+		return voice.NewMemoryStore()
+	}
+
+	store := voice.NewMongoStore(db.Mongo)
+	if err := store.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create voice state indexes: %v", err)
+	}
+	return store
+}
+
+// membershipCacheTTL reads MEMBERSHIP_CACHE_TTL (e.g. "30s"), the cache is disabled when it is not set
+func membershipCacheTTL() time.Duration {
+	value := os.Getenv("MEMBERSHIP_CACHE_TTL")
+	if value == "" {
+		return 0
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Failed to parse MEMBERSHIP_CACHE_TTL: %v", err)
+	}
+	return ttl
+}
+
+// membershipReconcileInterval reads MEMBERSHIP_RECONCILE_INTERVAL (e.g. "1h"), "0" disables the reconciliation
+func membershipReconcileInterval() time.Duration {
+	value := os.Getenv("MEMBERSHIP_RECONCILE_INTERVAL")
+	if value == "" {
+		return time.Hour
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Failed to parse MEMBERSHIP_RECONCILE_INTERVAL: %v", err)
+	}
+	return interval
+}
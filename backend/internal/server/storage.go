@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"harmony/internal/database"
+	"harmony/internal/migrations"
+	"harmony/modules/channel"
+	"harmony/modules/command"
+	"harmony/modules/incoming"
+	"harmony/modules/invite"
+	"harmony/modules/message"
+	"harmony/modules/server"
+	"harmony/modules/session"
+	"harmony/modules/token"
+	"harmony/modules/user"
+	"harmony/modules/webhook"
+)
+
+// migrateTimeout bounds the wait for the other instances and the migrations themselves
+const migrateTimeout = 15 * time.Minute
+
+// Storage groups the repositories of the modules, the handlers only see their interfaces
+type Storage struct {
+	Users    user.Repository
+	Sessions session.Repository
+	Tokens   token.Repository
+	Servers  server.Repository
+	Channels channel.Repository
+	Messages message.Repository
+	Invites  invite.Repository
+	Webhooks webhook.Repository
+	Incoming incoming.Repository
+	Commands command.Repository
+}
+
+// NewMongoStorage uses the harmony database reconciling the indexes the repositories rely on,
+// the startup fails when one can't be created as the handlers count on the unique ones to detect
+// the conflicts: the error names the index and the duplicate key to remove
+func NewMongoStorage(db database.Service) Storage {
+	users := user.NewRepository(db.Mongo)
+	if err := users.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create user indexes: %v", err)
+	}
+	sessions := session.NewRepository(db.Mongo)
+	if err := sessions.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create session indexes: %v", err)
+	}
+	tokens := token.NewRepository(db.Mongo)
+	if err := tokens.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create token indexes: %v", err)
+	}
+	webhooks := webhook.NewRepository(db.Mongo)
+	if err := webhooks.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create webhook indexes: %v", err)
+	}
+	incomings := incoming.NewRepository(db.Mongo)
+	if err := incomings.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create incoming webhook indexes: %v", err)
+	}
+	servers := server.NewRepository(db.Mongo)
+	if err := servers.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create server indexes: %v", err)
+	}
+	messages := message.NewRepository(db.Mongo)
+	if err := messages.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create message indexes: %v", err)
+	}
+	channels := channel.NewRepository(db.Mongo)
+	if err := channels.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create channel indexes: %v", err)
+	}
+	invites := invite.NewRepository(db.Mongo)
+	if err := invites.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create invite indexes: %v", err)
+	}
+	commands := command.NewRepository(db.Mongo)
+	if err := commands.EnsureIndexes(); err != nil {
+		log.Fatalf("Failed to create command indexes: %v", err)
+	}
+
+	return Storage{
+		Users:    users,
+		Sessions: sessions,
+		Tokens:   tokens,
+		Servers:  servers,
+		Channels: channels,
+		Messages: messages,
+		Invites:  invites,
+		Webhooks: webhooks,
+		Incoming: incomings,
+		Commands: commands,
+	}
+}
+
+// NewMemoryStorage keeps everything in the process, nothing survives a restart
+func NewMemoryStorage() Storage {
+	mem := database.NewMemory()
+	return Storage{
+		Users:    user.NewMemoryRepository(mem),
+		Sessions: session.NewMemoryRepository(mem),
+		Tokens:   token.NewMemoryRepository(mem),
+		Servers:  server.NewMemoryRepository(mem),
+		Channels: channel.NewMemoryRepository(mem),
+		Messages: message.NewMemoryRepository(mem),
+		Invites:  invite.NewMemoryRepository(mem),
+		Webhooks: webhook.NewMemoryRepository(mem),
+		Incoming: incoming.NewMemoryRepository(mem),
+		Commands: command.NewMemoryRepository(mem),
+	}
+}
+
+// newStorage picks the storage from STORAGE, "memory" runs without mongo
+func newStorage() (database.Service, Storage) {
+	if os.Getenv("STORAGE") == "memory" {
+		return database.Service{}, NewMemoryStorage()
+	}
+
+	db := database.New()
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		migrate(db)
+	}
+	return db, NewMongoStorage(db)
+}
+
+// migrate applies the pending migrations before serving, MIGRATE_ON_START=false leaves them to cmd/migrate
+func migrate(db database.Service) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	applied, err := migrations.Up(ctx, db.Mongo)
+	for _, migration := range applied {
+		log.Printf("migration %d %s applied", migration.Version, migration.Name)
+	}
+	if err != nil {
+		log.Fatalf("Failed to migrate: %v", err)
+	}
+}
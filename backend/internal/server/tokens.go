@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"harmony/modules/token"
+	"harmony/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// authenticate accepts a session JWT or a personal access token, the claims of a token
+// carry its scopes in "scp"
+func (s *Server) authenticate(tokenString string) (jwt.MapClaims, error) {
+	if !strings.HasPrefix(tokenString, token.Prefix) {
+		claims, err := s.parseToken(tokenString)
+		if err != nil {
+			return nil, err
+		}
+		// logging out revokes the session, its access tokens stop working too
+		if sid, ok := claims["sid"].(string); ok {
+			active, err := s.revocations.Active(sid)
+			if err != nil || !active {
+				return nil, errors.New("Invalid token")
+			}
+		}
+		return claims, nil
+	}
+
+	pat, err := s.tokens.ReadByValue(tokenString)
+	if err != nil || !pat.IsActive() {
+		return nil, errors.New("Invalid token")
+	}
+	// the tokens outlive their owner, they stop working once the user is deleted
+	if _, err := s.store.Users.ReadByUniqueName(pat.UserID); err != nil {
+		return nil, errors.New("Invalid token")
+	}
+	if err := s.tokens.Touch(pat); err != nil {
+		log.Printf("failed to touch token %s: %v", pat.ID.Hex(), err)
+	}
+
+	return jwt.MapClaims{
+		"sub": pat.UserID,
+		"pat": pat.ID.Hex(),
+		"scp": pat.Scopes,
+	}, nil
+}
+
+// tokenScopes returns the scopes of a personal access token, false for session JWTs
+func tokenScopes(claims jwt.MapClaims) ([]string, bool) {
+	scopes, ok := claims["scp"].([]string)
+	return scopes, ok
+}
+
+// routeScope is the scope a personal access token needs on a group of routes, read for the GET
+// requests and write for the others. The zero value denies tokens, so every group declares its own
+type routeScope struct {
+	read  string
+	write string
+}
+
+var (
+	// scopeNone is for the routes managing credentials, like sessions, tokens and webhook secrets
+	scopeNone     = routeScope{}
+	scopeServers  = routeScope{read: token.ScopeServersRead, write: token.ScopeServersWrite}
+	scopeMessages = routeScope{read: token.ScopeMessagesRead, write: token.ScopeMessagesWrite}
+	scopeUsers    = routeScope{read: token.ScopeUsersRead, write: token.ScopeUsersWrite}
+	scopeGateway  = routeScope{read: token.ScopeGateway, write: token.ScopeGateway}
+)
+
+func (r routeScope) required(method string) string {
+	if method == http.MethodGet {
+		return r.read
+	}
+	return r.write
+}
+
+// hasScope tells if the claims allow the request, session JWTs are never restricted
+func hasScope(claims jwt.MapClaims, scope routeScope, method string) bool {
+	scopes, ok := tokenScopes(claims)
+	if !ok {
+		return true
+	}
+
+	required := scope.required(method)
+	return required != "" && utils.Contains(scopes, required)
+}
+
+// checkScope aborts when the request is made with a token missing the scope of the route
+func checkScope(c *gin.Context, claims jwt.MapClaims, scope routeScope) bool {
+	if !hasScope(claims, scope, c.Request.Method) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token scope not sufficient"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"harmony/internal/autentication"
+	"harmony/internal/event"
+	"harmony/internal/mail"
+	"harmony/modules/server"
+	"harmony/modules/session"
+	"harmony/modules/token"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestServer runs the routes on the memory storage, requests authenticate with personal access tokens
+// or with the access tokens signed by an ephemeral key
+func newTestServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	keys, err := autentication.NewEphemeralKeyManager()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	store := NewMemoryStorage()
+	s := &Server{
+		store:       store,
+		auth:        autentication.Service{Audience: "harmony", Keys: keys},
+		bus:         event.NewLocalBus(),
+		membership:  server.NewMembership(store.Servers, 0),
+		sessions:    store.Sessions,
+		revocations: session.NewRevocations(store.Sessions, 0),
+		tokens:      store.Tokens,
+		mailer:      mail.NewMemoryMailer(),
+	}
+	s.bus.Subscribe(s.membership.HandleEvent)
+	return s, s.RegisterRoutes()
+}
+
+// newToken returns a personal access token of the user with the scopes
+func newToken(t *testing.T, s *Server, userId string, scopes ...string) string {
+	t.Helper()
+
+	pat, value := token.NewToken(userId, "test", scopes, 0)
+	if err := s.tokens.Create(&pat); err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	return value
+}
+
+func TestTokenScopes(t *testing.T) {
+	s, handler := newTestServer(t)
+
+	alice := newAccount(t, s, "alice")
+	servers := newToken(t, s, alice.id, token.ScopeServersRead, token.ScopeServersWrite)
+	messages := newToken(t, s, alice.id, token.ScopeMessagesRead)
+	users := newToken(t, s, alice.id, token.ScopeUsersRead, token.ScopeUsersWrite)
+
+	id := "0123456789abcdef01234567"
+	denied := http.StatusForbidden
+
+	tests := []struct {
+		name    string
+		token   string
+		method  string
+		path    string
+		allowed bool
+	}{
+		{"servers read", servers, http.MethodGet, "/users/@me/servers/", true},
+		{"servers without scope", messages, http.MethodGet, "/users/@me/servers/", false},
+		{"messages read", messages, http.MethodGet, "/servers/" + id + "/channels/" + id + "/messages/", true},
+		{"messages write without scope", messages, http.MethodPost, "/servers/" + id + "/channels/" + id + "/messages/", false},
+		{"interactions need messages", servers, http.MethodPost, "/servers/" + id + "/channels/" + id + "/interactions/", false},
+		{"commands", servers, http.MethodGet, "/servers/" + id + "/commands/", true},
+		{"incoming webhooks", servers, http.MethodGet, "/servers/" + id + "/channels/" + id + "/webhooks/", false},
+		{"outgoing webhooks", servers, http.MethodGet, "/servers/" + id + "/webhooks/", false},
+		{"bot endpoint", users, http.MethodGet, "/users/@me/interactions/", false},
+		{"tokens", users, http.MethodGet, "/users/@me/tokens/", false},
+		{"bots", users, http.MethodGet, "/users/@me/bots", false},
+		{"password", users, http.MethodPut, "/users/@me/password", false},
+		{"sessions", users, http.MethodGet, "/auth/sessions/", false},
+		{"user read", users, http.MethodGet, "/users/@me", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if tt.allowed && w.Code == denied {
+				t.Fatalf("expected the scope to be enough, got %d %s", w.Code, w.Body)
+			}
+			if !tt.allowed && w.Code != denied {
+				t.Fatalf("expected %d, got %d %s", denied, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	s, handler := newTestServer(t)
+	alice := newAccount(t, s, "alice")
+	bob := newAccount(t, s, "bob")
+
+	status, me := request(t, handler, alice.token, http.MethodGet, "/users/@me", nil)
+	expect(t, status, me, http.StatusOK)
+	id := me["id"].(string)
+
+	// another user can't delete the account
+	status, response := request(t, handler, bob.token, http.MethodDelete, "/users/"+id, nil)
+	expect(t, status, response, http.StatusUnauthorized)
+
+	status, response = request(t, handler, alice.token, http.MethodDelete, "/users/"+id, nil)
+	expect(t, status, response, http.StatusNoContent)
+
+	// the tokens of the deleted user stop working
+	status, response = request(t, handler, alice.token, http.MethodGet, "/users/@me", nil)
+	expect(t, status, response, http.StatusUnauthorized)
+}
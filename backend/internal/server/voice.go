@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+
+	"harmony/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *Server) voiceStatesHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	server, member, err := s.membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// membership check
+	if member == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	states, err := s.voice.States(server.ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive voice states"})
+		return
+	}
+
+	c.JSON(http.StatusOK, states)
+}
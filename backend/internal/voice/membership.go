@@ -0,0 +1,46 @@
+package voice
+
+import (
+	"harmony/modules/channel"
+	"harmony/modules/server"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RepositoryMembership checks the voice channels and the server members stored in the repositories
+type RepositoryMembership struct {
+	Membership  *server.Membership
+	RepoChannel channel.Repository
+}
+
+func NewRepositoryMembership(repoChannel channel.Repository, membership *server.Membership) *RepositoryMembership {
+	return &RepositoryMembership{
+		Membership:  membership,
+		RepoChannel: repoChannel,
+	}
+}
+
+func (m *RepositoryMembership) CanConnect(userID string, channelID string) (string, error) {
+	channelObjectId, err := primitive.ObjectIDFromHex(channelID)
+	if err != nil {
+		return "", err
+	}
+
+	ch, err := m.RepoChannel.Read(channelObjectId)
+	if err != nil {
+		return "", err
+	}
+	if ch.Type != channel.TypeVoice {
+		return "", ErrNotVoiceChannel
+	}
+
+	srv, member, err := m.Membership.Member(ch.ServerID, userID)
+	if err != nil {
+		return "", err
+	}
+	if !ch.Permissions(srv, member).Has(server.PermissionConnect) {
+		return "", ErrNotAllowed
+	}
+
+	return srv.ID.Hex(), nil
+}
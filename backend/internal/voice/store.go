@@ -0,0 +1,304 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"harmony/internal/database"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	// stateTTL is how long the states of an instance survive it, they are refreshed well before
+	stateTTL        = time.Minute
+	refreshInterval = stateTTL / 3
+)
+
+// Store keeps the voice states where every api instance can see them, one per user.
+// The states are written only by the instance holding the connection of the user
+type Store interface {
+	// Save replaces the state of the user and returns the previous one, nil when the user wasn't connected
+	Save(state State) (*State, error)
+	// Read returns the state of the user, nil when the user isn't connected
+	Read(userID string) (*State, error)
+	ReadByServer(serverID string) ([]State, error)
+	ReadByChannel(channelID string) ([]State, error)
+	// Delete removes the state of the user if it belongs to the instance
+	Delete(userID string, instance string) (*State, error)
+	// DeleteByServer removes the states of the instance in the server, only the one of the user when given
+	DeleteByServer(serverID string, userID string, instance string) ([]State, error)
+	// Refresh keeps the states of the instance alive, the ones of an instance that stopped expire
+	Refresh(instance string) error
+}
+
+// MemoryStore keeps the states in the process, it is enough when a single instance runs
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		states: map[string]State{},
+	}
+}
+
+func (s *MemoryStore) Save(state State) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.states[state.UserID]
+	s.states[state.UserID] = state
+	if !exists {
+		return nil, nil
+	}
+	return &previous, nil
+}
+
+func (s *MemoryStore) Read(userID string) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, exists := s.states[userID]
+	if !exists {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (s *MemoryStore) ReadByServer(serverID string) ([]State, error) {
+	return s.filter(func(state State) bool { return state.ServerID == serverID }), nil
+}
+
+func (s *MemoryStore) ReadByChannel(channelID string) ([]State, error) {
+	return s.filter(func(state State) bool { return state.ChannelID == channelID }), nil
+}
+
+func (s *MemoryStore) Delete(userID string, instance string) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, exists := s.states[userID]
+	if !exists || state.Instance != instance {
+		return nil, nil
+	}
+	delete(s.states, userID)
+	return &state, nil
+}
+
+func (s *MemoryStore) DeleteByServer(serverID string, userID string, instance string) ([]State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := []State{}
+	for id, state := range s.states {
+		if state.ServerID != serverID || state.Instance != instance || (userID != "" && id != userID) {
+			continue
+		}
+		delete(s.states, id)
+		removed = append(removed, state)
+	}
+	return removed, nil
+}
+
+func (s *MemoryStore) Refresh(instance string) error {
+	return nil
+}
+
+func (s *MemoryStore) filter(fn func(state State) bool) []State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := []State{}
+	for _, state := range s.states {
+		if fn(state) {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+type stateDocument struct {
+	UserID    string    `bson:"_id"`
+	ServerID  string    `bson:"server_id"`
+	ChannelID string    `bson:"channel_id"`
+	Muted     bool      `bson:"muted"`
+	Deafened  bool      `bson:"deafened"`
+	Instance  string    `bson:"instance"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func (d *stateDocument) state() State {
+	return State{
+		UserID:    d.UserID,
+		ServerID:  d.ServerID,
+		ChannelID: d.ChannelID,
+		Muted:     d.Muted,
+		Deafened:  d.Deafened,
+		Instance:  d.Instance,
+	}
+}
+
+// MongoStore shares the states between the instances in the voice_states collection
+type MongoStore struct {
+	db *mongo.Database
+}
+
+func NewMongoStore(db *mongo.Client) *MongoStore {
+	return &MongoStore{
+		db: db.Database("harmony"),
+	}
+}
+
+// EnsureIndexes creates the indexes of the states, mongo removes the expired ones on its own
+func (s *MongoStore) EnsureIndexes() error {
+	cStates := s.db.Collection("voice_states")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cStates, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "server_id", Value: 1}}},
+		{Keys: bson.D{{Key: "channel_id", Value: 1}}},
+		{Keys: bson.D{{Key: "instance", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (s *MongoStore) Save(state State) (*State, error) {
+	cStates := s.db.Collection("voice_states")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	document := stateDocument{
+		UserID:    state.UserID,
+		ServerID:  state.ServerID,
+		ChannelID: state.ChannelID,
+		Muted:     state.Muted,
+		Deafened:  state.Deafened,
+		Instance:  state.Instance,
+		ExpiresAt: time.Now().Add(stateTTL),
+	}
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var previous stateDocument
+	err := cStates.FindOneAndReplace(ctx, bson.M{"_id": state.UserID}, document, opts).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// expired but not removed yet
+	if previous.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	state = previous.state()
+	return &state, nil
+}
+
+func (s *MongoStore) Read(userID string) (*State, error) {
+	states, err := s.find(bson.M{"_id": userID})
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	return &states[0], nil
+}
+
+func (s *MongoStore) ReadByServer(serverID string) ([]State, error) {
+	return s.find(bson.M{"server_id": serverID})
+}
+
+func (s *MongoStore) ReadByChannel(channelID string) ([]State, error) {
+	return s.find(bson.M{"channel_id": channelID})
+}
+
+func (s *MongoStore) Delete(userID string, instance string) (*State, error) {
+	cStates := s.db.Collection("voice_states")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var removed stateDocument
+	err := cStates.FindOneAndDelete(ctx, bson.M{"_id": userID, "instance": instance}).Decode(&removed)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := removed.state()
+	return &state, nil
+}
+
+func (s *MongoStore) DeleteByServer(serverID string, userID string, instance string) ([]State, error) {
+	cStates := s.db.Collection("voice_states")
+
+	filter := bson.M{"server_id": serverID, "instance": instance}
+	if userID != "" {
+		filter["_id"] = userID
+	}
+	states, err := s.find(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err = cStates.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+func (s *MongoStore) Refresh(instance string) error {
+	cStates := s.db.Collection("voice_states")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cStates.UpdateMany(ctx, bson.M{"instance": instance}, bson.M{
+		"$set": bson.M{"expires_at": time.Now().Add(stateTTL)},
+	})
+	return err
+}
+
+// find returns the states matching the filter that are not expired
+func (s *MongoStore) find(filter bson.M) ([]State, error) {
+	cStates := s.db.Collection("voice_states")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	query := bson.M{"expires_at": bson.M{"$gt": time.Now()}}
+	for key, value := range filter {
+		query[key] = value
+	}
+	cursor, err := cStates.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := []stateDocument{}
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	states := make([]State, 0, len(documents))
+	for _, document := range documents {
+		states = append(states, document.state())
+	}
+	return states, nil
+}
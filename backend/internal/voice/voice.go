@@ -0,0 +1,295 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"harmony/internal/event"
+	"harmony/utils"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// kinds of the WebRTC signals relayed between the participants
+const (
+	SignalOffer     = "offer"
+	SignalAnswer    = "answer"
+	SignalCandidate = "candidate"
+)
+
+var (
+	ErrNotVoiceChannel = errors.New("Channel is not a voice channel")
+	ErrNotAllowed      = errors.New("User is not allowed to connect to the channel")
+	ErrNotConnected    = errors.New("User is not connected to a voice channel")
+	ErrTargetNotFound  = errors.New("Target is not connected to the same voice channel")
+	ErrInvalidSignal   = errors.New("Signal type must be offer, answer or candidate")
+)
+
+// State is the voice state of a user, an empty channel means the user disconnected
+type State struct {
+	UserID    string `json:"user_id"`
+	ServerID  string `json:"server_id"`
+	ChannelID string `json:"channel_id"`
+	Muted     bool   `json:"muted"`
+	Deafened  bool   `json:"deafened"`
+	// Instance is the api instance holding the connection of the user
+	Instance string `json:"-"`
+}
+
+// Peer is the real-time connection of a user, the signals addressed to the user are sent through it
+type Peer interface {
+	UserID() string
+	Send(eventType string, data any)
+}
+
+// Membership checks that the user can connect to the voice channel and returns the server of the channel
+type Membership interface {
+	CanConnect(userID string, channelID string) (string, error)
+}
+
+// Manager tracks who is connected to each voice channel and relays the signaling between them,
+// a user is connected to at most one voice channel at a time. The states are kept in the store so
+// every instance sees them, the signals go through the bus to the instance holding the target peer
+type Manager struct {
+	mu         sync.Mutex
+	membership Membership
+	bus        event.Bus
+	store      Store
+	instance   string
+	peers      map[string]Peer
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewManager(membership Membership, bus event.Bus, store Store) *Manager {
+	return &Manager{
+		membership: membership,
+		bus:        bus,
+		store:      store,
+		instance:   utils.GetRandomToken(8),
+		peers:      map[string]Peer{},
+	}
+}
+
+// Start keeps the states of the peers of this instance alive until Close
+func (m *Manager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.store.Refresh(m.instance); err != nil {
+					log.Printf("failed to refresh voice states: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (m *Manager) Close() error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+	return nil
+}
+
+// Join connects the peer to the voice channel, moving it from any other channel, and returns who is already there
+func (m *Manager) Join(peer Peer, channelID string, muted bool, deafened bool) ([]State, error) {
+	serverID, err := m.membership.CanConnect(peer.UserID(), channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := State{
+		UserID:    peer.UserID(),
+		ServerID:  serverID,
+		ChannelID: channelID,
+		Muted:     muted || deafened,
+		Deafened:  deafened,
+		Instance:  m.instance,
+	}
+
+	m.mu.Lock()
+	m.peers[state.UserID] = peer
+	m.mu.Unlock()
+
+	previous, err := m.store.Save(state)
+	if err != nil {
+		return nil, err
+	}
+	states, err := m.store.ReadByChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	others := slices.DeleteFunc(states, func(other State) bool { return other.UserID == state.UserID })
+
+	// the other server only needs to know the user went away
+	if previous != nil && previous.ServerID != serverID {
+		m.publish(State{UserID: state.UserID, ServerID: previous.ServerID})
+	}
+	m.publish(state)
+
+	return others, nil
+}
+
+// Leave disconnects the peer from its voice channel
+func (m *Manager) Leave(peer Peer) error {
+	m.mu.Lock()
+	if m.peers[peer.UserID()] != peer {
+		m.mu.Unlock()
+		return ErrNotConnected
+	}
+	delete(m.peers, peer.UserID())
+	m.mu.Unlock()
+
+	// the user may have connected again through another instance meanwhile
+	removed, err := m.store.Delete(peer.UserID(), m.instance)
+	if err != nil {
+		return err
+	}
+	if removed == nil {
+		return ErrNotConnected
+	}
+
+	m.publish(State{UserID: removed.UserID, ServerID: removed.ServerID})
+	return nil
+}
+
+// UpdateState changes the mute and deafen flags of the peer, deafened users are always muted
+func (m *Manager) UpdateState(peer Peer, muted bool, deafened bool) error {
+	state, err := m.current(peer)
+	if err != nil {
+		return err
+	}
+
+	state.Muted = muted || deafened
+	state.Deafened = deafened
+	if _, err := m.store.Save(*state); err != nil {
+		return err
+	}
+
+	m.publish(*state)
+	return nil
+}
+
+// Signal relays an SDP offer/answer or an ICE candidate to another user of the same voice channel,
+// whatever instance the user is connected to
+func (m *Manager) Signal(peer Peer, to string, signalType string, payload json.RawMessage) error {
+	if signalType != SignalOffer && signalType != SignalAnswer && signalType != SignalCandidate {
+		return ErrInvalidSignal
+	}
+
+	from, err := m.current(peer)
+	if err != nil {
+		return err
+	}
+	target, err := m.store.Read(to)
+	if err != nil {
+		return err
+	}
+	if target == nil || target.ChannelID != from.ChannelID || to == from.UserID {
+		return ErrTargetNotFound
+	}
+
+	return m.bus.Publish(event.Event{
+		Type:     event.VoiceSignal,
+		ServerID: from.ServerID,
+		UserID:   to,
+		Audience: []string{to},
+		Data: map[string]any{
+			"from":       from.UserID,
+			"channel_id": from.ChannelID,
+			"type":       signalType,
+			"payload":    payload,
+		},
+	})
+}
+
+// States returns the voice states of the users connected in the server
+func (m *Manager) States(serverID string) ([]State, error) {
+	return m.store.ReadByServer(serverID)
+}
+
+// HandleEvent hands the signals to the peers of this instance and disconnects the users
+// that are no longer allowed in the server
+func (m *Manager) HandleEvent(evt event.Event) {
+	switch evt.Type {
+	case event.VoiceSignal:
+		m.mu.Lock()
+		peer, exists := m.peers[evt.UserID]
+		m.mu.Unlock()
+		if exists {
+			peer.Send(evt.Type, evt.Data)
+		}
+		return
+	case event.MemberLeft, event.ServerDeleted:
+	default:
+		return
+	}
+
+	userID := ""
+	if evt.Type == event.MemberLeft {
+		userID = evt.UserID
+	}
+	// every instance removes the states of its own peers
+	removed, err := m.store.DeleteByServer(evt.ServerID, userID, m.instance)
+	if err != nil {
+		log.Printf("failed to remove voice states of %s: %v", evt.ServerID, err)
+		return
+	}
+
+	m.mu.Lock()
+	for _, state := range removed {
+		delete(m.peers, state.UserID)
+	}
+	m.mu.Unlock()
+
+	if evt.Type == event.MemberLeft {
+		for _, state := range removed {
+			m.publish(State{UserID: state.UserID, ServerID: state.ServerID})
+		}
+	}
+}
+
+// current returns the state of the peer, it must be connected through this instance
+func (m *Manager) current(peer Peer) (*State, error) {
+	m.mu.Lock()
+	connected := m.peers[peer.UserID()] == peer
+	m.mu.Unlock()
+	if !connected {
+		return nil, ErrNotConnected
+	}
+
+	state, err := m.store.Read(peer.UserID())
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.Instance != m.instance {
+		return nil, ErrNotConnected
+	}
+	return state, nil
+}
+
+func (m *Manager) publish(state State) {
+	err := m.bus.Publish(event.Event{
+		Type:     event.VoiceState,
+		ServerID: state.ServerID,
+		UserID:   state.UserID,
+		Data:     state,
+	})
+	if err != nil {
+		log.Printf("failed to publish %s: %v", event.VoiceState, err)
+	}
+}
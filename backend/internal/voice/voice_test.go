@@ -0,0 +1,303 @@
+package voice
+
+import (
+	"encoding/json"
+	"errors"
+	"harmony/internal/event"
+	"sync"
+	"testing"
+)
+
+type fakePeer struct {
+	mu     sync.Mutex
+	user   string
+	events []event.Event
+}
+
+func (p *fakePeer) UserID() string {
+	return p.user
+}
+
+func (p *fakePeer) Send(eventType string, data any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, event.Event{Type: eventType, Data: data})
+}
+
+// decode reads the data of the event, the bus delivers it as json
+func decode[T any](t *testing.T, evt event.Event) T {
+	t.Helper()
+
+	var data T
+	raw, ok := evt.Data.(json.RawMessage)
+	if !ok {
+		t.Fatalf("expected the data as json, got %T", evt.Data)
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatalf("decode %s: %v", evt.Type, err)
+	}
+	return data
+}
+
+func (p *fakePeer) received(eventType string) []event.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := []event.Event{}
+	for _, evt := range p.events {
+		if evt.Type == eventType {
+			events = append(events, evt)
+		}
+	}
+	return events
+}
+
+// fakeMembership maps the voice channels to their server, the users in denied can't connect
+type fakeMembership struct {
+	channels map[string]string
+	denied   map[string]bool
+}
+
+func (f *fakeMembership) CanConnect(userID string, channelID string) (string, error) {
+	serverID, exists := f.channels[channelID]
+	if !exists {
+		return "", ErrNotVoiceChannel
+	}
+	if f.denied[userID] {
+		return "", ErrNotAllowed
+	}
+	return serverID, nil
+}
+
+// newReplicas returns two managers that share the bus and the store like two api instances
+func newReplicas(t *testing.T) (*Manager, *Manager, *[]event.Event) {
+	t.Helper()
+
+	membership := &fakeMembership{
+		channels: map[string]string{"voice-a": "server-1", "voice-b": "server-1", "voice-c": "server-2"},
+		denied:   map[string]bool{"mallory": true},
+	}
+	bus := event.NewLocalBus()
+	store := NewMemoryStore()
+
+	first := NewManager(membership, bus, store)
+	second := NewManager(membership, bus, store)
+	bus.Subscribe(first.HandleEvent)
+	bus.Subscribe(second.HandleEvent)
+
+	published := []event.Event{}
+	bus.Subscribe(func(evt event.Event) {
+		if evt.Type == event.VoiceState {
+			published = append(published, evt)
+		}
+	})
+
+	return first, second, &published
+}
+
+func TestJoin(t *testing.T) {
+	first, second, published := newReplicas(t)
+
+	alice := &fakePeer{user: "alice"}
+	bob := &fakePeer{user: "bob"}
+
+	others, err := first.Join(alice, "voice-a", false, false)
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if len(others) != 0 {
+		t.Fatalf("expected an empty channel, got %v", others)
+	}
+
+	others, err = second.Join(bob, "voice-a", false, true)
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if len(others) != 1 || others[0].UserID != "alice" {
+		t.Fatalf("expected alice in the channel, got %v", others)
+	}
+
+	states, err := first.States("server-1")
+	if err != nil {
+		t.Fatalf("states: %v", err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("expected the states of both instances, got %v", states)
+	}
+
+	last := decode[State](t, (*published)[len(*published)-1])
+	if last.UserID != "bob" || !last.Muted || !last.Deafened {
+		t.Fatalf("a deafened user must be muted, got %+v", last)
+	}
+}
+
+func TestJoinNotAllowed(t *testing.T) {
+	first, _, _ := newReplicas(t)
+
+	tests := []struct {
+		name    string
+		user    string
+		channel string
+		err     error
+	}{
+		{"not a voice channel", "alice", "text", ErrNotVoiceChannel},
+		{"denied", "mallory", "voice-a", ErrNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := first.Join(&fakePeer{user: tt.user}, tt.channel, false, false)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestJoinMovesServer(t *testing.T) {
+	first, _, published := newReplicas(t)
+
+	alice := &fakePeer{user: "alice"}
+	if _, err := first.Join(alice, "voice-a", false, false); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	*published = nil
+	if _, err := first.Join(alice, "voice-c", false, false); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	if len(*published) != 2 {
+		t.Fatalf("expected a leave and a join, got %v", *published)
+	}
+	left := decode[State](t, (*published)[0])
+	if left.ServerID != "server-1" || left.ChannelID != "" {
+		t.Fatalf("expected the leave of server-1, got %+v", left)
+	}
+}
+
+func TestSignalAcrossInstances(t *testing.T) {
+	first, second, _ := newReplicas(t)
+
+	alice := &fakePeer{user: "alice"}
+	bob := &fakePeer{user: "bob"}
+	carol := &fakePeer{user: "carol"}
+	first.Join(alice, "voice-a", false, false)
+	second.Join(bob, "voice-a", false, false)
+	second.Join(carol, "voice-b", false, false)
+
+	offer := json.RawMessage(`{"sdp":"v=0"}`)
+	if err := first.Signal(alice, "bob", SignalOffer, offer); err != nil {
+		t.Fatalf("signal: %v", err)
+	}
+
+	signals := bob.received(event.VoiceSignal)
+	if len(signals) != 1 {
+		t.Fatalf("expected one signal for bob, got %d", len(signals))
+	}
+	data := decode[map[string]any](t, signals[0])
+	if data["from"] != "alice" || data["type"] != SignalOffer {
+		t.Fatalf("unexpected signal %v", data)
+	}
+	if len(alice.received(event.VoiceSignal)) != 0 || len(carol.received(event.VoiceSignal)) != 0 {
+		t.Fatal("the signal must reach only its target")
+	}
+
+	tests := []struct {
+		name       string
+		to         string
+		signalType string
+		err        error
+	}{
+		{"invalid type", "bob", "hello", ErrInvalidSignal},
+		{"other channel", "carol", SignalOffer, ErrTargetNotFound},
+		{"not connected", "dave", SignalOffer, ErrTargetNotFound},
+		{"self", "alice", SignalOffer, ErrTargetNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := first.Signal(alice, tt.to, tt.signalType, offer)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSignalFromOtherInstance(t *testing.T) {
+	first, second, _ := newReplicas(t)
+
+	alice := &fakePeer{user: "alice"}
+	first.Join(alice, "voice-a", false, false)
+	second.Join(&fakePeer{user: "bob"}, "voice-a", false, false)
+
+	// the peer is connected to first, second can't speak for it
+	err := second.Signal(alice, "bob", SignalOffer, json.RawMessage(`{}`))
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected %v, got %v", ErrNotConnected, err)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	first, second, _ := newReplicas(t)
+
+	alice := &fakePeer{user: "alice"}
+	first.Join(alice, "voice-a", false, false)
+
+	if err := second.Leave(alice); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected %v, got %v", ErrNotConnected, err)
+	}
+	if err := first.Leave(alice); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if err := first.Leave(alice); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected %v, got %v", ErrNotConnected, err)
+	}
+
+	states, _ := second.States("server-1")
+	if len(states) != 0 {
+		t.Fatalf("expected no state left, got %v", states)
+	}
+}
+
+func TestUpdateState(t *testing.T) {
+	first, second, _ := newReplicas(t)
+
+	alice := &fakePeer{user: "alice"}
+	first.Join(alice, "voice-a", false, false)
+
+	if err := first.UpdateState(alice, false, true); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	states, _ := second.States("server-1")
+	if len(states) != 1 || !states[0].Muted || !states[0].Deafened {
+		t.Fatalf("expected alice muted and deafened, got %v", states)
+	}
+}
+
+func TestMemberLeft(t *testing.T) {
+	first, second, published := newReplicas(t)
+
+	first.Join(&fakePeer{user: "alice"}, "voice-a", false, false)
+	second.Join(&fakePeer{user: "bob"}, "voice-a", false, false)
+	*published = nil
+
+	first.bus.Publish(event.Event{Type: event.MemberLeft, ServerID: "server-1", UserID: "bob"})
+
+	states, _ := first.States("server-1")
+	if len(states) != 1 || states[0].UserID != "alice" {
+		t.Fatalf("expected only alice left, got %v", states)
+	}
+	if len(*published) != 1 || (*published)[0].UserID != "bob" {
+		t.Fatalf("expected the leave of bob, got %v", *published)
+	}
+
+	first.bus.Publish(event.Event{Type: event.ServerDeleted, ServerID: "server-1"})
+
+	states, _ = second.States("server-1")
+	if len(states) != 0 {
+		t.Fatalf("expected no state left, got %v", states)
+	}
+}
@@ -0,0 +1,474 @@
+package channel
+
+import (
+	"harmony/modules/server"
+	"harmony/utils"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Handler struct {
+	Repo       Repository
+	Membership *server.Membership
+}
+
+func NewHandler(repo Repository, membership *server.Membership) *Handler {
+	return &Handler{
+		Repo:       repo,
+		Membership: membership,
+	}
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	id := c.Param("id")
+	type RequestBody struct {
+		Name       string      `json:"name"`
+		Topic      string      `json:"topic"`
+		Type       string      `json:"type"`
+		Overwrites []Overwrite `json:"overwrites"`
+	}
+	var rb RequestBody
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rb.Type == "" {
+		rb.Type = TypeText
+	}
+	if check, errMsg := IsNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsTopicValid(rb.Topic); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsTypeValid(rb.Type); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search server
+	sub, ok := utils.GetSub(c)
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// permission check
+	if !ok || !srv.Permissions(member).Has(server.PermissionManageChannels) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// the overwrites allow to create a private channel right away, like SetOverwrite
+	// nobody can allow or deny what they do not have
+	permissions := srv.Permissions(member)
+	for _, overwrite := range rb.Overwrites {
+		if check, errMsg := h.isOverwriteValid(srv, overwrite); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+		if !permissions.Has(server.PermissionManageRoles) || !permissions.Has(overwrite.Allow|overwrite.Deny) {
+			c.Status(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// create
+	channel := NewChannel(srv.ID, rb.Name, rb.Topic, rb.Type)
+	channel.Overwrites = rb.Overwrites
+
+	err = h.Repo.Create(&channel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create channel"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, channel.Print())
+}
+
+func (h *Handler) List(c *gin.Context) {
+	id := c.Param("id")
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	sub, ok := utils.GetSub(c)
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// membership check
+	if !ok || member == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// search channels
+	channels, err := h.Repo.ReadByServer(srv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive channels"})
+		return
+	}
+
+	// hide the channels the user cannot read
+	result := make([]map[string]any, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Permissions(srv, member).Has(server.PermissionReadMessages) {
+			result = append(result, channel.Print())
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) Read(c *gin.Context) {
+	id := c.Param("id")
+	channelId := c.Param("channelId")
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	sub, ok := utils.GetSub(c)
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// membership check
+	if !ok || member == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// search channel
+	channel, err := h.Repo.Read(channelObjectId)
+	if err != nil || channel.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	// permission check
+	if !channel.Permissions(srv, member).Has(server.PermissionReadMessages) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	c.JSON(http.StatusOK, channel.Print())
+}
+
+func (h *Handler) Update(c *gin.Context) {
+	id := c.Param("id")
+	channelId := c.Param("channelId")
+	type RequestBody struct {
+		Name     *string `json:"name"`
+		Topic    *string `json:"topic"`
+		Position *int    `json:"position"`
+	}
+	var rb RequestBody
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rb.Name != nil {
+		if check, errMsg := IsNameValid(*rb.Name); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+	if rb.Topic != nil {
+		if check, errMsg := IsTopicValid(*rb.Topic); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+
+	// search server
+	sub, ok := utils.GetSub(c)
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// search channel
+	channel, err := h.Repo.Read(channelObjectId)
+	if err != nil || channel.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	// permission check
+	if !ok || !channel.Permissions(srv, member).Has(server.PermissionManageChannels) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// update data
+	if rb.Name != nil {
+		channel.Name = *rb.Name
+	}
+	if rb.Topic != nil {
+		channel.Topic = *rb.Topic
+	}
+
+	err = h.Repo.Update(channel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+		return
+	}
+
+	if rb.Position != nil {
+		err = h.Repo.Move(channel, *rb.Position)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move channel"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, channel.Print())
+}
+
+func (h *Handler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	channelId := c.Param("channelId")
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	sub, ok := utils.GetSub(c)
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// search channel
+	channel, err := h.Repo.Read(channelObjectId)
+	if err != nil || channel.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	// permission check
+	if !ok || !channel.Permissions(srv, member).Has(server.PermissionManageChannels) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// try deleting
+	isDeleted, err := h.Repo.Delete(channel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete channel"})
+		return
+	}
+	if !isDeleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) SetOverwrite(c *gin.Context) {
+	id := c.Param("id")
+	channelId := c.Param("channelId")
+	targetId := c.Param("targetId")
+	type RequestBody struct {
+		Type  string            `json:"type"`
+		Allow server.Permission `json:"allow"`
+		Deny  server.Permission `json:"deny"`
+	}
+	var rb RequestBody
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	sub, ok := utils.GetSub(c)
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	overwrite := Overwrite{
+		ID:    targetId,
+		Type:  rb.Type,
+		Allow: rb.Allow,
+		Deny:  rb.Deny,
+	}
+	if check, errMsg := h.isOverwriteValid(srv, overwrite); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search channel
+	channel, err := h.Repo.Read(channelObjectId)
+	if err != nil || channel.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	// permission check, nobody can allow or deny what they do not have
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	permissions := channel.Permissions(srv, member)
+	if !permissions.Has(server.PermissionManageRoles) || !permissions.Has(overwrite.Allow|overwrite.Deny) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// update data
+	if existing, exists := channel.Overwrite(overwrite.Type, overwrite.ID); exists {
+		*existing = overwrite
+	} else {
+		channel.Overwrites = append(channel.Overwrites, overwrite)
+	}
+
+	err = h.Repo.Update(channel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, channel.Print())
+}
+
+func (h *Handler) DeleteOverwrite(c *gin.Context) {
+	id := c.Param("id")
+	channelId := c.Param("channelId")
+	targetId := c.Param("targetId")
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	sub, ok := utils.GetSub(c)
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// search channel
+	channel, err := h.Repo.Read(channelObjectId)
+	if err != nil || channel.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	// permission check
+	if !ok || !channel.Permissions(srv, member).Has(server.PermissionManageRoles) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// update data
+	count := len(channel.Overwrites)
+	channel.Overwrites = slices.DeleteFunc(channel.Overwrites, func(o Overwrite) bool {
+		return o.ID == targetId
+	})
+	if len(channel.Overwrites) == count {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive overwrite"})
+		return
+	}
+
+	err = h.Repo.Update(channel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// isOverwriteValid validates the overwrite, the member it targets must belong to the server
+func (h *Handler) isOverwriteValid(srv *server.Server, overwrite Overwrite) (bool, string) {
+	if check, errMsg := IsOverwriteValid(srv, overwrite); !check {
+		return false, errMsg
+	}
+	if overwrite.Type == OverwriteMember {
+		_, member, err := h.Membership.Member(srv.ID, overwrite.ID)
+		if err != nil || member == nil {
+			return false, "Failed to retreive member"
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,164 @@
+package channel
+
+import (
+	"harmony/internal/database"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRepository keeps the channels in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(channel *Channel) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var channels []Channel
+		if err := tx.Find("channels", bson.M{"server_id": channel.ServerID}, &channels); err != nil {
+			return err
+		}
+		channel.Position = len(channels)
+
+		if channel.Overwrites == nil {
+			channel.Overwrites = []Overwrite{}
+		}
+		id, err := tx.Insert("channels", channel)
+		if err != nil {
+			return err
+		}
+		channel.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Channel, error) {
+	var channel Channel
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("channels", bson.M{"_id": id}, &channel)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &channel, nil
+}
+
+func (r *MemoryRepository) ReadByServer(serverId primitive.ObjectID) ([]Channel, error) {
+	channels := []Channel{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("channels", bson.M{"server_id": serverId}, &channels)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].Position < channels[j].Position
+	})
+
+	return channels, nil
+}
+
+func (r *MemoryRepository) Update(channel *Channel) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Channel
+		_, err := tx.Modify("channels", channel.ID, &stored, func() {
+			stored.Name = channel.Name
+			stored.Topic = channel.Topic
+			stored.Overwrites = channel.Overwrites
+		})
+		return err
+	})
+}
+
+func (r *MemoryRepository) Move(channel *Channel, position int) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var channels []Channel
+		if err := tx.Find("channels", bson.M{"server_id": channel.ServerID}, &channels); err != nil {
+			return err
+		}
+		if position < 0 {
+			position = 0
+		}
+		if position > len(channels)-1 {
+			position = len(channels) - 1
+		}
+
+		// the position read with the channel may have changed since
+		current := -1
+		for _, other := range channels {
+			if other.ID == channel.ID {
+				current = other.Position
+			}
+		}
+		if current == -1 {
+			return mongo.ErrNoDocuments
+		}
+		if position == current {
+			channel.Position = position
+			return nil
+		}
+
+		for _, other := range channels {
+			switch {
+			case other.ID == channel.ID:
+				other.Position = position
+			case position < current && other.Position >= position && other.Position < current:
+				other.Position++
+			case position > current && other.Position > current && other.Position <= position:
+				other.Position--
+			default:
+				continue
+			}
+			if _, err := tx.Replace("channels", other.ID, other); err != nil {
+				return err
+			}
+		}
+		channel.Position = position
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Delete(channel *Channel) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		if tx.Delete("channels", bson.M{"_id": channel.ID}) == 0 {
+			return nil
+		}
+		deleted = true
+
+		// close the gap left in the ordering
+		var channels []Channel
+		if err := tx.Find("channels", bson.M{"server_id": channel.ServerID}, &channels); err != nil {
+			return err
+		}
+		for _, other := range channels {
+			if other.Position <= channel.Position {
+				continue
+			}
+			other.Position--
+			if _, err := tx.Replace("channels", other.ID, other); err != nil {
+				return err
+			}
+		}
+
+		// the messages, incoming webhooks and interactions posting into the channel go with it
+		for _, collection := range []string{"messages", "incoming_webhooks", "interactions"} {
+			tx.Delete(collection, bson.M{"channel_id": channel.ID})
+		}
+		return nil
+	})
+
+	return deleted, err
+}
@@ -0,0 +1,201 @@
+package channel
+
+import (
+	"harmony/modules/server"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TypeText  = "text"
+	TypeVoice = "voice"
+)
+
+// targets of a permission overwrite
+const (
+	OverwriteRole   = "role"
+	OverwriteMember = "member"
+)
+
+// Overwrite allows or denies permissions in the channel to a role or to a single member,
+// the role every member has is targeted with the server id
+type Overwrite struct {
+	ID    string            `bson:"id" json:"id"`
+	Type  string            `bson:"type" json:"type"`
+	Allow server.Permission `bson:"allow" json:"allow"`
+	Deny  server.Permission `bson:"deny" json:"deny"`
+}
+
+type Channel struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID   primitive.ObjectID `bson:"server_id"`
+	Name       string             `bson:"name"`
+	Topic      string             `bson:"topic"`
+	Type       string             `bson:"type"`
+	Position   int                `bson:"position"`
+	Overwrites []Overwrite        `bson:"overwrites"`
+}
+
+func NewChannel(serverId primitive.ObjectID, name string, topic string, channelType string) Channel {
+	return Channel{
+		ServerID: serverId,
+		Name:     name,
+		Topic:    topic,
+		Type:     channelType,
+	}
+}
+
+func IsNameValid(name string) (bool, string) {
+	if len(name) == 0 {
+		return false, "Name is empty"
+	}
+	if len(name) > 100 {
+		return false, "Name is too long"
+	}
+	var pattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	if !pattern.MatchString(name) {
+		return false, "Name contains not allowed caracters"
+	}
+	return true, ""
+}
+
+func IsTopicValid(topic string) (bool, string) {
+	if len(topic) > 1024 {
+		return false, "Topic is too long"
+	}
+	return true, ""
+}
+
+func IsTypeValid(channelType string) (bool, string) {
+	if channelType != TypeText && channelType != TypeVoice {
+		return false, "Type must be text or voice"
+	}
+	return true, ""
+}
+
+func IsOverwriteValid(srv *server.Server, overwrite Overwrite) (bool, string) {
+	switch overwrite.Type {
+	case OverwriteRole:
+		roleId, err := primitive.ObjectIDFromHex(overwrite.ID)
+		if err != nil {
+			return false, "Failed to retreive role"
+		}
+		if _, exists := srv.Role(roleId); !exists {
+			return false, "Failed to retreive role"
+		}
+	case OverwriteMember:
+		// the membership is checked by the caller
+		if overwrite.ID == "" {
+			return false, "Failed to retreive member"
+		}
+	default:
+		return false, "Type must be role or member"
+	}
+	if check, errMsg := server.IsPermissionValid(overwrite.Allow | overwrite.Deny); !check {
+		return false, errMsg
+	}
+	if overwrite.Allow&overwrite.Deny != 0 {
+		return false, "A permission cannot be both allowed and denied"
+	}
+	return true, ""
+}
+
+// Overwrite returns the overwrite of the given target
+func (c *Channel) Overwrite(overwriteType string, id string) (*Overwrite, bool) {
+	for i := range c.Overwrites {
+		if c.Overwrites[i].Type == overwriteType && c.Overwrites[i].ID == id {
+			return &c.Overwrites[i], true
+		}
+	}
+	return nil, false
+}
+
+// Permissions resolves what the member is allowed to do in the channel: the server permissions are
+// adjusted by the overwrite of the role every member has, then by the ones of the member roles
+// combined, so their order doesn't matter, and last by the one of the member.
+// Administrators are never restricted, users that are not members (nil) can do nothing
+func (c *Channel) Permissions(srv *server.Server, member *server.Member) server.Permission {
+	if member == nil {
+		return 0
+	}
+	permissions := srv.Permissions(member)
+	if permissions.Has(server.PermissionAdministrator) {
+		return server.PermissionAll
+	}
+
+	if overwrite, exists := c.Overwrite(OverwriteRole, srv.ID.Hex()); exists {
+		permissions &^= overwrite.Deny
+		permissions |= overwrite.Allow
+	}
+
+	var deny, allow server.Permission
+	for _, assigned := range member.Roles {
+		if assigned == server.RoleMember {
+			continue
+		}
+		if overwrite, exists := c.Overwrite(OverwriteRole, assigned); exists {
+			deny |= overwrite.Deny
+			allow |= overwrite.Allow
+		}
+	}
+	permissions &^= deny
+	permissions |= allow
+
+	if overwrite, exists := c.Overwrite(OverwriteMember, member.UserID); exists {
+		permissions &^= overwrite.Deny
+		permissions |= overwrite.Allow
+	}
+
+	return permissions
+}
+
+// ReaderQuery tells how to find who can read the channel without going through every member.
+// When public the plain members can read it and only the members with one of the roles or unique names
+// returned may not, otherwise only the members with one of them may
+func (c *Channel) ReaderQuery(srv *server.Server) (bool, []string, []string) {
+	plain := &server.Member{Roles: []string{server.RoleMember}}
+	public := c.Permissions(srv, plain).Has(server.PermissionReadMessages)
+
+	roles := []string{}
+	users := []string{}
+	if !public {
+		roles = srv.RolesWith(server.PermissionReadMessages)
+		users = append(users, srv.OwnerID)
+	}
+	for _, overwrite := range c.Overwrites {
+		changes := overwrite.Allow
+		if public {
+			changes = overwrite.Deny
+		}
+		if !changes.Has(server.PermissionReadMessages) {
+			continue
+		}
+		switch overwrite.Type {
+		case OverwriteRole:
+			if overwrite.ID != srv.ID.Hex() {
+				roles = append(roles, overwrite.ID)
+			}
+		case OverwriteMember:
+			users = append(users, overwrite.ID)
+		}
+	}
+
+	return public, roles, users
+}
+
+func (c *Channel) Print() map[string]any {
+	overwrites := c.Overwrites
+	if overwrites == nil {
+		overwrites = []Overwrite{}
+	}
+	return map[string]any{
+		"id":         c.ID,
+		"server_id":  c.ServerID,
+		"name":       c.Name,
+		"topic":      c.Topic,
+		"type":       c.Type,
+		"position":   c.Position,
+		"overwrites": overwrites,
+	}
+}
@@ -0,0 +1,239 @@
+package channel
+
+import (
+	"harmony/modules/server"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPermissions(t *testing.T) {
+	srv := server.Server{
+		ID:      primitive.NewObjectID(),
+		OwnerID: "owner",
+	}
+	everyone := srv.ID.Hex()
+	moderator := server.NewRole("moderator", 0, server.PermissionManageMessages)
+	muted := server.NewRole("muted", 0, 0)
+	srv.Roles = []server.Role{{ID: srv.ID, Name: "everyone", Permissions: server.PermissionDefault}, moderator, muted}
+	members := map[string]*server.Member{
+		"owner":     {UserID: "owner", Roles: []string{server.RoleOwner}},
+		"admin":     {UserID: "admin", Roles: []string{server.RoleMember, server.RoleAdmin}},
+		"member":    {UserID: "member", Roles: []string{server.RoleMember}},
+		"moderator": {UserID: "moderator", Roles: []string{server.RoleMember, moderator.ID.Hex()}},
+		"both":      {UserID: "both", Roles: []string{server.RoleMember, moderator.ID.Hex(), muted.ID.Hex()}},
+		"reversed":  {UserID: "reversed", Roles: []string{server.RoleMember, muted.ID.Hex(), moderator.ID.Hex()}},
+	}
+
+	read := server.PermissionReadMessages
+	send := server.PermissionSendMessages
+
+	tests := []struct {
+		name       string
+		overwrites []Overwrite
+		user       string
+		allowed    server.Permission
+		denied     server.Permission
+	}{
+		{
+			name:    "no overwrites",
+			user:    "member",
+			allowed: server.PermissionDefault,
+			denied:  server.PermissionManageMessages,
+		},
+		{
+			name:   "not a member",
+			user:   "stranger",
+			denied: read,
+		},
+		{
+			name:       "everyone denied",
+			overwrites: []Overwrite{{ID: everyone, Type: OverwriteRole, Deny: read}},
+			user:       "member",
+			denied:     read,
+		},
+		{
+			name: "role allows over everyone",
+			overwrites: []Overwrite{
+				{ID: everyone, Type: OverwriteRole, Deny: read},
+				{ID: moderator.ID.Hex(), Type: OverwriteRole, Allow: read},
+			},
+			user:    "moderator",
+			allowed: read | server.PermissionManageMessages,
+		},
+		{
+			name: "role allow wins over role deny",
+			overwrites: []Overwrite{
+				{ID: moderator.ID.Hex(), Type: OverwriteRole, Allow: send},
+				{ID: muted.ID.Hex(), Type: OverwriteRole, Deny: send},
+			},
+			user:    "both",
+			allowed: send,
+		},
+		{
+			name: "role allow wins whatever the order of the roles",
+			overwrites: []Overwrite{
+				{ID: moderator.ID.Hex(), Type: OverwriteRole, Allow: send},
+				{ID: muted.ID.Hex(), Type: OverwriteRole, Deny: send},
+			},
+			user:    "reversed",
+			allowed: send,
+		},
+		{
+			name: "role denies combine",
+			overwrites: []Overwrite{
+				{ID: moderator.ID.Hex(), Type: OverwriteRole, Deny: read},
+				{ID: muted.ID.Hex(), Type: OverwriteRole, Deny: send},
+			},
+			user:   "reversed",
+			denied: read | send,
+		},
+		{
+			name: "member allow wins over roles",
+			overwrites: []Overwrite{
+				{ID: everyone, Type: OverwriteRole, Deny: read},
+				{ID: muted.ID.Hex(), Type: OverwriteRole, Deny: read},
+				{ID: "both", Type: OverwriteMember, Allow: read},
+			},
+			user:    "both",
+			allowed: read,
+		},
+		{
+			name: "member deny wins over roles",
+			overwrites: []Overwrite{
+				{ID: moderator.ID.Hex(), Type: OverwriteRole, Allow: send},
+				{ID: "moderator", Type: OverwriteMember, Deny: send},
+			},
+			user:    "moderator",
+			allowed: read,
+			denied:  send,
+		},
+		{
+			name: "member overwrite of another member",
+			overwrites: []Overwrite{
+				{ID: "moderator", Type: OverwriteMember, Deny: read},
+			},
+			user:    "member",
+			allowed: read,
+		},
+		{
+			name: "administrator bypass",
+			overwrites: []Overwrite{
+				{ID: everyone, Type: OverwriteRole, Deny: read | send},
+				{ID: "admin", Type: OverwriteMember, Deny: read | send},
+			},
+			user:    "admin",
+			allowed: server.PermissionAll,
+		},
+		{
+			name: "owner bypass",
+			overwrites: []Overwrite{
+				{ID: everyone, Type: OverwriteRole, Deny: read},
+			},
+			user:    "owner",
+			allowed: server.PermissionAll,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := Channel{ServerID: srv.ID, Overwrites: tt.overwrites}
+
+			permissions := ch.Permissions(&srv, members[tt.user])
+			if !permissions.Has(tt.allowed) {
+				t.Errorf("expected %b to be allowed, got %b", tt.allowed, permissions)
+			}
+			if tt.denied != 0 && permissions&tt.denied != 0 {
+				t.Errorf("expected %b to be denied, got %b", tt.denied, permissions)
+			}
+		})
+	}
+}
+
+func TestIsOverwriteValid(t *testing.T) {
+	srv := server.Server{ID: primitive.NewObjectID()}
+	role := server.NewRole("role", 0, 0)
+	srv.Roles = []server.Role{{ID: srv.ID, Name: "everyone", Permissions: server.PermissionDefault}, role}
+
+	tests := []struct {
+		name      string
+		overwrite Overwrite
+		valid     bool
+	}{
+		{"role", Overwrite{ID: role.ID.Hex(), Type: OverwriteRole, Deny: server.PermissionReadMessages}, true},
+		{"everyone", Overwrite{ID: srv.ID.Hex(), Type: OverwriteRole, Allow: server.PermissionSendMessages}, true},
+		{"member", Overwrite{ID: "member", Type: OverwriteMember, Allow: server.PermissionReadMessages}, true},
+		{"unknown role", Overwrite{ID: primitive.NewObjectID().Hex(), Type: OverwriteRole}, false},
+		{"no member", Overwrite{Type: OverwriteMember}, false},
+		{"unknown type", Overwrite{ID: "member", Type: "user"}, false},
+		{"allowed and denied", Overwrite{ID: "member", Type: OverwriteMember, Allow: server.PermissionReadMessages, Deny: server.PermissionReadMessages}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid, errMsg := IsOverwriteValid(&srv, tt.overwrite); valid != tt.valid {
+				t.Errorf("expected %v, got %v (%s)", tt.valid, valid, errMsg)
+			}
+		})
+	}
+}
+
+func TestReaderQuery(t *testing.T) {
+	srv := server.Server{ID: primitive.NewObjectID(), OwnerID: "owner"}
+	everyone := srv.ID.Hex()
+	reader := server.NewRole("reader", 0, server.PermissionReadMessages)
+	other := server.NewRole("other", 0, server.PermissionSpeak)
+	srv.Roles = []server.Role{{ID: srv.ID, Name: "everyone", Permissions: server.PermissionDefault}, reader, other}
+
+	tests := []struct {
+		name       string
+		overwrites []Overwrite
+		public     bool
+		roles      []string
+		users      []string
+	}{
+		{
+			name:   "no overwrites",
+			public: true,
+		},
+		{
+			name: "denied to a role and a member",
+			overwrites: []Overwrite{
+				{ID: other.ID.Hex(), Type: OverwriteRole, Deny: server.PermissionReadMessages},
+				{ID: "member", Type: OverwriteMember, Deny: server.PermissionReadMessages},
+				{ID: reader.ID.Hex(), Type: OverwriteRole, Deny: server.PermissionSendMessages},
+			},
+			public: true,
+			roles:  []string{other.ID.Hex()},
+			users:  []string{"member"},
+		},
+		{
+			name: "private",
+			overwrites: []Overwrite{
+				{ID: everyone, Type: OverwriteRole, Deny: server.PermissionReadMessages},
+				{ID: other.ID.Hex(), Type: OverwriteRole, Allow: server.PermissionReadMessages},
+				{ID: "member", Type: OverwriteMember, Allow: server.PermissionReadMessages},
+			},
+			roles: []string{server.RoleOwner, server.RoleAdmin, reader.ID.Hex(), other.ID.Hex()},
+			users: []string{"owner", "member"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := Channel{ServerID: srv.ID, Overwrites: tt.overwrites}
+
+			public, roles, users := ch.ReaderQuery(&srv)
+			if public != tt.public {
+				t.Errorf("expected public %v, got %v", tt.public, public)
+			}
+			if !slices.Equal(roles, append([]string{}, tt.roles...)) {
+				t.Errorf("expected roles %v, got %v", tt.roles, roles)
+			}
+			if !slices.Equal(users, append([]string{}, tt.users...)) {
+				t.Errorf("expected users %v, got %v", tt.users, users)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"harmony/internal/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Repository stores the channels, positions are kept contiguous inside each server
+type Repository interface {
+	Create(channel *Channel) error
+	Read(id primitive.ObjectID) (*Channel, error)
+	ReadByServer(serverId primitive.ObjectID) ([]Channel, error)
+	Update(channel *Channel) error
+	Move(channel *Channel, position int) error
+	Delete(channel *Channel) (bool, error)
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+// EnsureIndexes creates the index the channels of a server are listed and moved by
+func (r *MongoRepository) EnsureIndexes() error {
+	cChannels := r.db.Collection("channels")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cChannels, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "server_id", Value: 1}, {Key: "position", Value: 1}}},
+	})
+	return err
+}
+
+// lockOrder writes the server document in the transaction, so two transactions changing the positions
+// of the same server conflict and the driver runs the second one again on the committed positions
+func lockOrder(ctx mongo.SessionContext, db *mongo.Database, serverId primitive.ObjectID) error {
+	cServers := db.Collection("servers")
+
+	_, err := cServers.UpdateByID(ctx, serverId, bson.M{"$inc": bson.M{"channels_version": 1}})
+	return err
+}
+
+func (r *MongoRepository) Create(channel *Channel) error {
+	cChannels := r.db.Collection("channels")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.Transaction(ctx, r.db.Client(), func(ctx mongo.SessionContext) error {
+		if err := lockOrder(ctx, r.db, channel.ServerID); err != nil {
+			return err
+		}
+
+		// new channels are appended at the bottom of the list
+		count, err := cChannels.CountDocuments(ctx, bson.M{"server_id": channel.ServerID})
+		if err != nil {
+			return err
+		}
+		channel.Position = int(count)
+
+		result, err := cChannels.InsertOne(ctx, bson.M{
+			"server_id":  channel.ServerID,
+			"name":       channel.Name,
+			"topic":      channel.Topic,
+			"type":       channel.Type,
+			"position":   channel.Position,
+			"overwrites": channel.Overwrites,
+		})
+		if err != nil {
+			return err
+		}
+
+		channel.ID = result.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	return err
+}
+
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Channel, error) {
+	cChannels := r.db.Collection("channels")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var channel Channel
+	err := cChannels.FindOne(ctx, bson.M{"_id": id}).Decode(&channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return &channel, nil
+}
+
+func (r *MongoRepository) ReadByServer(serverId primitive.ObjectID) ([]Channel, error) {
+	cChannels := r.db.Collection("channels")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := cChannels.Find(ctx, bson.M{"server_id": serverId}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := []Channel{}
+	if err := cursor.All(ctx, &channels); err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+func (r *MongoRepository) Update(channel *Channel) error {
+	cChannels := r.db.Collection("channels")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// cannot update:
+	// - server
+	// - type
+	// - position (see Move)
+	update := bson.M{
+		"$set": bson.M{
+			"name":       channel.Name,
+			"topic":      channel.Topic,
+			"overwrites": channel.Overwrites,
+		},
+	}
+	_, err := cChannels.UpdateByID(ctx, channel.ID, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Move places the channel at the given position shifting the channels in between
+func (r *MongoRepository) Move(channel *Channel, position int) error {
+	cChannels := r.db.Collection("channels")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.Transaction(ctx, r.db.Client(), func(ctx mongo.SessionContext) error {
+		if err := lockOrder(ctx, r.db, channel.ServerID); err != nil {
+			return err
+		}
+
+		// the position read with the channel may have changed since
+		var current Channel
+		err := cChannels.FindOne(ctx, bson.M{"_id": channel.ID}).Decode(&current)
+		if err != nil {
+			return err
+		}
+		count, err := cChannels.CountDocuments(ctx, bson.M{"server_id": channel.ServerID})
+		if err != nil {
+			return err
+		}
+		target := min(max(position, 0), int(count)-1)
+		if target == current.Position {
+			channel.Position = target
+			return nil
+		}
+
+		filter := bson.M{
+			"server_id": channel.ServerID,
+			"_id":       bson.M{"$ne": channel.ID},
+		}
+		shift := 1
+		if target < current.Position {
+			filter["position"] = bson.M{"$gte": target, "$lt": current.Position}
+		} else {
+			filter["position"] = bson.M{"$gt": current.Position, "$lte": target}
+			shift = -1
+		}
+		_, err = cChannels.UpdateMany(ctx, filter, bson.M{"$inc": bson.M{"position": shift}})
+		if err != nil {
+			return err
+		}
+
+		_, err = cChannels.UpdateByID(ctx, channel.ID, bson.M{"$set": bson.M{"position": target}})
+		if err != nil {
+			return err
+		}
+		channel.Position = target
+		return nil
+	})
+	return err
+}
+
+func (r *MongoRepository) Delete(channel *Channel) (bool, error) {
+	cChannels := r.db.Collection("channels")
+	cMessages := r.db.Collection("messages")
+	cIncoming := r.db.Collection("incoming_webhooks")
+	cInteractions := r.db.Collection("interactions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	deleted := false
+	err := database.Transaction(ctx, r.db.Client(), func(ctx mongo.SessionContext) error {
+		if err := lockOrder(ctx, r.db, channel.ServerID); err != nil {
+			return err
+		}
+
+		var current Channel
+		err := cChannels.FindOneAndDelete(ctx, bson.M{"_id": channel.ID}).Decode(&current)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			deleted = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		deleted = true
+
+		// close the gap left in the ordering
+		filter := bson.M{
+			"server_id": channel.ServerID,
+			"position":  bson.M{"$gt": current.Position},
+		}
+		_, err = cChannels.UpdateMany(ctx, filter, bson.M{"$inc": bson.M{"position": -1}})
+		if err != nil {
+			return err
+		}
+
+		// the messages, incoming webhooks and interactions posting into the channel go with it
+		for _, c := range []*mongo.Collection{cMessages, cIncoming, cInteractions} {
+			if _, err := c.DeleteMany(ctx, bson.M{"channel_id": channel.ID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return deleted, nil
+}
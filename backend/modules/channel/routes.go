@@ -0,0 +1,15 @@
+package channel
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/", h.Create)
+	r.GET("/", h.List)
+	r.GET("/:channelId", h.Read)
+	r.PATCH("/:channelId", h.Update)
+	r.DELETE("/:channelId", h.Delete)
+	r.PUT("/:channelId/overwrites/:targetId", h.SetOverwrite)
+	r.DELETE("/:channelId/overwrites/:targetId", h.DeleteOverwrite)
+}
@@ -0,0 +1,401 @@
+package command
+
+import (
+	"errors"
+	"harmony/internal/event"
+	"harmony/modules/channel"
+	"harmony/modules/message"
+	"harmony/modules/server"
+	"harmony/modules/user"
+	"harmony/modules/webhook"
+	"harmony/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Handler struct {
+	Repo        Repository
+	Membership  *server.Membership
+	RepoChannel channel.Repository
+	RepoMessage message.Repository
+	RepoUser    user.Repository
+	Bus         event.Bus
+	client      *http.Client
+}
+
+func NewHandler(repo Repository, repoChannel channel.Repository, repoMessage message.Repository, repoUser user.Repository, membership *server.Membership, bus event.Bus) *Handler {
+	return &Handler{
+		Repo:        repo,
+		Membership:  membership,
+		RepoChannel: repoChannel,
+		RepoMessage: repoMessage,
+		RepoUser:    repoUser,
+		Bus:         bus,
+		client:      webhook.NewClient(ResponseWindow),
+	}
+}
+
+// member resolves the server of the path checking the caller is one of its members
+func (h *Handler) member(c *gin.Context) (*server.Member, *server.Server, bool) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return nil, nil, false
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	// search server
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return nil, nil, false
+	}
+
+	// permission check
+	if member == nil {
+		c.Status(http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	return member, srv, true
+}
+
+// command resolves the :commandId of the path inside the server
+func (h *Handler) command(c *gin.Context, srv *server.Server) (*Command, bool) {
+	commandObjectId, err := primitive.ObjectIDFromHex(c.Param("commandId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	cmd, err := h.Repo.Read(commandObjectId)
+	if err != nil || cmd.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive command"})
+		return nil, false
+	}
+
+	return cmd, true
+}
+
+func (h *Handler) List(c *gin.Context) {
+	_, srv, ok := h.member(c)
+	if !ok {
+		return
+	}
+
+	commands, err := h.Repo.ReadByServer(srv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive commands"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(commands))
+	for _, cmd := range commands {
+		result = append(result, cmd.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Create registers a command of the calling bot in the server
+func (h *Handler) Create(c *gin.Context) {
+	type RequestBody struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Options     []Option `json:"options"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsDescriptionValid(rb.Description); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsOptionsValid(rb.Options); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	member, srv, ok := h.member(c)
+	if !ok {
+		return
+	}
+
+	// only bots register commands
+	bot, err := h.RepoUser.ReadByUniqueName(member.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+	if !bot.Bot {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	count, err := h.Repo.CountByBot(srv.ID, member.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive commands"})
+		return
+	}
+	if count >= MaxCommands {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many commands"})
+		return
+	}
+
+	// create
+	cmd := NewCommand(srv.ID, member.UserID, rb.Name, rb.Description, rb.Options)
+
+	err = h.Repo.Create(&cmd)
+	if errors.Is(err, ErrNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Command name already taken"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create command"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, cmd.Print())
+}
+
+func (h *Handler) Update(c *gin.Context) {
+	type RequestBody struct {
+		Name        *string   `json:"name"`
+		Description *string   `json:"description"`
+		Options     *[]Option `json:"options"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rb.Name != nil {
+		if check, errMsg := IsNameValid(*rb.Name); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+	if rb.Description != nil {
+		if check, errMsg := IsDescriptionValid(*rb.Description); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+	if rb.Options != nil {
+		if check, errMsg := IsOptionsValid(*rb.Options); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+
+	member, srv, ok := h.member(c)
+	if !ok {
+		return
+	}
+	cmd, ok := h.command(c, srv)
+	if !ok {
+		return
+	}
+
+	// only the bot that registered the command can change it
+	if cmd.BotID != member.UserID {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// update data
+	if rb.Name != nil {
+		cmd.Name = *rb.Name
+	}
+	if rb.Description != nil {
+		cmd.Description = *rb.Description
+	}
+	if rb.Options != nil {
+		cmd.Options = *rb.Options
+		if cmd.Options == nil {
+			cmd.Options = []Option{}
+		}
+	}
+
+	err := h.Repo.Update(cmd)
+	if errors.Is(err, ErrNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Command name already taken"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update command"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cmd.Print())
+}
+
+// Delete removes a command, the bot can do it as well as who manages the server
+func (h *Handler) Delete(c *gin.Context) {
+	member, srv, ok := h.member(c)
+	if !ok {
+		return
+	}
+	cmd, ok := h.command(c, srv)
+	if !ok {
+		return
+	}
+
+	// permission check
+	if cmd.BotID != member.UserID && !srv.Permissions(member).Has(server.PermissionManageServer) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	_, err := h.Repo.Delete(cmd.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete command"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// bot resolves the :id of the path, "@me" or the id of a bot, to a bot the caller can manage
+func (h *Handler) bot(c *gin.Context) (*user.User, bool) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return nil, false
+	}
+
+	// search caller
+	caller, err := h.RepoUser.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return nil, false
+	}
+
+	target := caller
+	if id != "@me" {
+		// validate input
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+
+		// search user
+		target, err = h.RepoUser.Read(objectId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+			return nil, false
+		}
+	}
+
+	// permission check
+	if !caller.CanManage(target) {
+		c.Status(http.StatusUnauthorized)
+		return nil, false
+	}
+	if !target.Bot {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User is not a bot"})
+		return nil, false
+	}
+
+	return target, true
+}
+
+func (h *Handler) ReadEndpoint(c *gin.Context) {
+	bot, ok := h.bot(c)
+	if !ok {
+		return
+	}
+
+	endpoint, err := h.Repo.ReadEndpoint(bot.UniqueName)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bot receives the interactions on the gateway"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive endpoint"})
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint.Print())
+}
+
+// UpdateEndpoint makes the bot receive its interactions on the URL, a new secret is issued every time
+func (h *Handler) UpdateEndpoint(c *gin.Context) {
+	type RequestBody struct {
+		URL string `json:"url"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := webhook.IsURLValid(rb.URL); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	bot, ok := h.bot(c)
+	if !ok {
+		return
+	}
+
+	endpoint := NewEndpoint(bot.UniqueName, rb.URL)
+
+	err := h.Repo.SaveEndpoint(&endpoint)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update endpoint"})
+		return
+	}
+
+	// the secret is shown only now, the bot needs it to check the signatures
+	result := endpoint.Print()
+	result["secret"] = endpoint.Secret
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DeleteEndpoint moves the interactions of the bot back to the gateway
+func (h *Handler) DeleteEndpoint(c *gin.Context) {
+	bot, ok := h.bot(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := h.Repo.DeleteEndpoint(bot.UniqueName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete endpoint"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive endpoint"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
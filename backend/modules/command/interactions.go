@@ -0,0 +1,419 @@
+package command
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harmony/internal/event"
+	"harmony/modules/channel"
+	"harmony/modules/message"
+	"harmony/modules/server"
+	"harmony/utils"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Response is how the bot answers an interaction, on the callback or in the body of the HTTP dispatch
+type Response struct {
+	Type    string          `json:"type"`
+	Content string          `json:"content"`
+	Embeds  []message.Embed `json:"embeds"`
+}
+
+// Invoke runs a command in the channel of the path, the bot answers asynchronously
+func (h *Handler) Invoke(c *gin.Context) {
+	channelId := c.Param("channelId")
+	type RequestBody struct {
+		Name    string         `json:"name"`
+		Options map[string]any `json:"options"`
+	}
+	var rb RequestBody
+
+	// validate input
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	member, srv, ok := h.member(c)
+	if !ok {
+		return
+	}
+
+	// search channel
+	ch, err := h.RepoChannel.Read(channelObjectId)
+	if err != nil || ch.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	// permission check
+	permissions := ch.Permissions(srv, member)
+	if !permissions.Has(server.PermissionReadMessages) || !permissions.Has(server.PermissionSendMessages) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	if ch.Type != channel.TypeText {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Channel is not a text channel"})
+		return
+	}
+
+	// search command
+	cmd, err := h.Repo.ReadByName(srv.ID, rb.Name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive command"})
+		return
+	}
+	_, bot, err := h.Membership.Member(srv.ID, cmd.BotID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive member"})
+		return
+	}
+	if bot == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bot is no longer a member of the server"})
+		return
+	}
+
+	// validate the arguments against the options of the command
+	values, references, err := cmd.Parse(rb.Options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for name, optionType := range references {
+		if !h.resolve(srv, optionType, values[name].(string)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Option %s is not a %s of the server", name, optionType)})
+			return
+		}
+	}
+
+	// create
+	interaction, token := NewInteraction(cmd, ch.ID, member.UserID, values)
+
+	err = h.Repo.CreateInteraction(&interaction)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create interaction"})
+		return
+	}
+
+	// the background work runs on copies of the interaction
+	h.dispatch(interaction, token)
+	pending := interaction
+	time.AfterFunc(ResponseWindow, func() {
+		h.fail(&pending)
+	})
+
+	c.JSON(http.StatusAccepted, interaction.Print())
+}
+
+// resolve checks the user, channel or role given to an option belongs to the server
+func (h *Handler) resolve(srv *server.Server, optionType string, value string) bool {
+	switch optionType {
+	case TypeUser:
+		_, member, err := h.Membership.Member(srv.ID, value)
+		return err == nil && member != nil
+	case TypeChannel:
+		objectId, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return false
+		}
+		ch, err := h.RepoChannel.Read(objectId)
+		return err == nil && ch.ServerID == srv.ID
+	case TypeRole:
+		objectId, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return false
+		}
+		_, exists := srv.Role(objectId)
+		return exists
+	}
+	return false
+}
+
+// dispatch sends the interaction to the endpoint of the bot if it has one, to its gateway sessions otherwise
+func (h *Handler) dispatch(interaction Interaction, token string) {
+	payload := interaction.Print()
+	payload["token"] = token
+
+	endpoint, err := h.Repo.ReadEndpoint(interaction.BotID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("command: failed to read endpoint of %s: %v", interaction.BotID, err)
+	}
+	if endpoint != nil {
+		go h.post(endpoint, &interaction, payload)
+		return
+	}
+
+	h.publish(event.Event{
+		Type:     event.InteractionCreate,
+		ServerID: interaction.ServerID.Hex(),
+		Audience: []string{interaction.BotID},
+		Data:     payload,
+	})
+}
+
+// post sends the interaction to the endpoint, the bot can answer right away in the response body
+func (h *Handler) post(endpoint *Endpoint, interaction *Interaction, payload map[string]any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("command: failed to encode interaction %s: %v", interaction.ID.Hex(), err)
+		return
+	}
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("command: failed to post interaction %s: %v", interaction.ID.Hex(), err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Harmony-Interactions/1.0")
+	req.Header.Set("X-Harmony-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Harmony-Signature", "sha256="+endpoint.Sign(timestamp, body))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		log.Printf("command: failed to post interaction %s: %v", interaction.ID.Hex(), err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("command: endpoint of %s answered %d", interaction.BotID, resp.StatusCode)
+		return
+	}
+
+	// an empty body means the bot uses the callback
+	var response Response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response); err != nil || response.Type == "" {
+		return
+	}
+	if status, errMsg := h.respond(interaction, response); status >= 300 {
+		log.Printf("command: rejected answer to interaction %s: %s", interaction.ID.Hex(), errMsg)
+	}
+}
+
+// fail tells the invoker the bot did not answer in time
+func (h *Handler) fail(interaction *Interaction) {
+	fail(h.Repo, h.Bus, interaction)
+}
+
+// fail tells the invoker the bot didn't answer in time, unless it answered meanwhile
+func fail(repo Repository, bus event.Bus, interaction *Interaction) {
+	failed, err := repo.Transition(interaction, []string{StatusPending}, StatusFailed)
+	if err != nil {
+		log.Printf("command: failed to expire interaction %s: %v", interaction.ID.Hex(), err)
+		return
+	}
+	if !failed {
+		return
+	}
+
+	evt := event.Event{
+		Type:     event.InteractionFailed,
+		ServerID: interaction.ServerID.Hex(),
+		Audience: []string{interaction.UserID},
+		Data:     interaction.Print(),
+	}
+	if err := bus.Publish(evt); err != nil {
+		log.Printf("failed to publish %s: %v", evt.Type, err)
+	}
+}
+
+// interaction resolves the :interactionId and :token of the path, a wrong token looks like a missing interaction
+func (h *Handler) interaction(c *gin.Context) (*Interaction, bool) {
+	interactionObjectId, err := primitive.ObjectIDFromHex(c.Param("interactionId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive interaction"})
+		return nil, false
+	}
+
+	interaction, err := h.Repo.ReadInteraction(interactionObjectId)
+	if err != nil || subtle.ConstantTimeCompare([]byte(interaction.TokenHash), []byte(utils.HashToken(c.Param("token")))) != 1 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive interaction"})
+		return nil, false
+	}
+	// the TTL index removes them later
+	if time.Now().After(interaction.ExpiresAt) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive interaction"})
+		return nil, false
+	}
+
+	return interaction, true
+}
+
+// Callback is the first answer of the bot, a reply or the promise of one
+func (h *Handler) Callback(c *gin.Context) {
+	var rb Response
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	interaction, ok := h.interaction(c)
+	if !ok {
+		return
+	}
+
+	status, errMsg := h.respond(interaction, rb)
+	if status >= 300 {
+		c.JSON(status, gin.H{"error": errMsg})
+		return
+	}
+
+	c.Status(status)
+}
+
+// respond applies the first answer of the bot, it returns the status and error for the response
+func (h *Handler) respond(interaction *Interaction, response Response) (int, string) {
+	if check, errMsg := IsResponseTypeValid(response.Type); !check {
+		return http.StatusBadRequest, errMsg
+	}
+	if response.Type == ResponseReply {
+		if check, errMsg := message.IsBodyValid(response.Content, response.Embeds); !check {
+			return http.StatusBadRequest, errMsg
+		}
+	}
+
+	// the invoker was already told the bot did not answer
+	if interaction.Status == StatusPending && time.Since(interaction.CreatedAt) > ResponseWindow {
+		return http.StatusConflict, "Interaction was not answered in time"
+	}
+
+	switch response.Type {
+	case ResponseDefer:
+		deferred, err := h.Repo.Transition(interaction, []string{StatusPending}, StatusDeferred)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to update interaction"
+		}
+		if !deferred {
+			return http.StatusConflict, "Interaction was already answered"
+		}
+
+		h.publish(event.Event{
+			Type:     event.InteractionDeferred,
+			ServerID: interaction.ServerID.Hex(),
+			Audience: []string{interaction.UserID},
+			Data:     interaction.Print(),
+		})
+		return http.StatusNoContent, ""
+	default:
+		responded, err := h.Repo.Transition(interaction, []string{StatusPending, StatusDeferred}, StatusResponded)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to update interaction"
+		}
+		if !responded {
+			return http.StatusConflict, "Interaction was already answered"
+		}
+		return h.reply(interaction, response)
+	}
+}
+
+// Followup adds another message to an interaction, deferred ones are answered by their first follow-up
+func (h *Handler) Followup(c *gin.Context) {
+	type RequestBody struct {
+		Content string          `json:"content"`
+		Embeds  []message.Embed `json:"embeds"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := message.IsBodyValid(rb.Content, rb.Embeds); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	interaction, ok := h.interaction(c)
+	if !ok {
+		return
+	}
+
+	if interaction.Status == StatusDeferred {
+		if _, err := h.Repo.Transition(interaction, []string{StatusDeferred}, StatusResponded); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update interaction"})
+			return
+		}
+		interaction.Status = StatusResponded
+	}
+	if interaction.Status != StatusResponded {
+		c.JSON(http.StatusConflict, gin.H{"error": "Interaction was not answered"})
+		return
+	}
+
+	status, errMsg := h.reply(interaction, Response{Type: ResponseReply, Content: rb.Content, Embeds: rb.Embeds})
+	if status >= 300 {
+		c.JSON(status, gin.H{"error": errMsg})
+		return
+	}
+
+	c.Status(status)
+}
+
+// reply posts a message of the bot in the channel of the interaction
+func (h *Handler) reply(interaction *Interaction, response Response) (int, string) {
+	// search server and channel, they may have been deleted in the meantime
+	srv, bot, err := h.Membership.Member(interaction.ServerID, interaction.BotID)
+	if err != nil {
+		return http.StatusNotFound, "Failed to retreive server"
+	}
+	ch, err := h.RepoChannel.Read(interaction.ChannelID)
+	if err != nil || ch.ServerID != srv.ID {
+		return http.StatusNotFound, "Failed to retreive channel"
+	}
+
+	// permission check, the bot may have been removed or denied the channel since the invocation
+	if bot == nil {
+		return http.StatusForbidden, "Bot is no longer a member of the server"
+	}
+	permissions := ch.Permissions(srv, bot)
+	if !permissions.Has(server.PermissionReadMessages) || !permissions.Has(server.PermissionSendMessages) {
+		return http.StatusForbidden, "Bot can't send messages in the channel"
+	}
+
+	// create
+	msg := message.NewMessage(srv.ID, ch.ID, interaction.BotID, response.Content)
+	msg.Embeds = response.Embeds
+	msg.InteractionID = &interaction.ID
+
+	err = h.RepoMessage.Create(&msg)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create message"
+	}
+
+	message.Publish(h.Bus, h.Membership.Repo, srv, ch, event.Event{
+		Type:     event.MessageCreated,
+		ServerID: srv.ID.Hex(),
+		Data:     msg.Print(),
+	})
+	return http.StatusNoContent, ""
+}
+
+func (h *Handler) publish(evt event.Event) {
+	if err := h.Bus.Publish(evt); err != nil {
+		log.Printf("failed to publish %s: %v", evt.Type, err)
+	}
+}
@@ -0,0 +1,213 @@
+package command
+
+import (
+	"harmony/internal/database"
+	"slices"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRepository keeps the commands, the interactions and the endpoints in memory,
+// it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	mem.Unique("interaction_endpoints", "bot_id")
+	mem.Unique("commands", "server_id", "name")
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(command *Command) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		id, err := tx.Insert("commands", command)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrNameTaken
+		}
+		if err != nil {
+			return err
+		}
+		command.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Command, error) {
+	return r.findOne(bson.M{"_id": id})
+}
+
+func (r *MemoryRepository) ReadByName(serverId primitive.ObjectID, name string) (*Command, error) {
+	return r.findOne(bson.M{"server_id": serverId, "name": name})
+}
+
+func (r *MemoryRepository) findOne(filter bson.M) (*Command, error) {
+	var command Command
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("commands", filter, &command)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &command, nil
+}
+
+func (r *MemoryRepository) ReadByServer(serverId primitive.ObjectID) ([]Command, error) {
+	commands := []Command{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("commands", bson.M{"server_id": serverId}, &commands)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+
+	return commands, nil
+}
+
+func (r *MemoryRepository) CountByBot(serverId primitive.ObjectID, botId string) (int64, error) {
+	var commands []Command
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("commands", bson.M{"server_id": serverId, "bot_id": botId}, &commands)
+	})
+
+	return int64(len(commands)), err
+}
+
+func (r *MemoryRepository) Update(command *Command) error {
+	command.UpdatedAt = time.Now()
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Command
+		_, err := tx.Modify("commands", command.ID, &stored, func() {
+			stored.Name = command.Name
+			stored.Description = command.Description
+			stored.Options = command.Options
+			stored.UpdatedAt = command.UpdatedAt
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrNameTaken
+		}
+		return err
+	})
+}
+
+func (r *MemoryRepository) Delete(id primitive.ObjectID) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		deleted = tx.Delete("commands", bson.M{"_id": id}) > 0
+		return nil
+	})
+
+	return deleted, err
+}
+
+func (r *MemoryRepository) CreateInteraction(interaction *Interaction) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		id, err := tx.Insert("interactions", interaction)
+		if err != nil {
+			return err
+		}
+		interaction.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) ReadInteraction(id primitive.ObjectID) (*Interaction, error) {
+	var interaction Interaction
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("interactions", bson.M{"_id": id}, &interaction)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &interaction, nil
+}
+
+func (r *MemoryRepository) ReadPending(createdBefore time.Time, limit int) ([]Interaction, error) {
+	var all []Interaction
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("interactions", bson.M{"status": StatusPending}, &all)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	interactions := []Interaction{}
+	for _, interaction := range all {
+		if interaction.CreatedAt.Before(createdBefore) && interaction.ExpiresAt.After(now) {
+			interactions = append(interactions, interaction)
+		}
+	}
+	sort.SliceStable(interactions, func(i, j int) bool {
+		return interactions[i].CreatedAt.Before(interactions[j].CreatedAt)
+	})
+	if len(interactions) > limit {
+		interactions = interactions[:limit]
+	}
+
+	return interactions, nil
+}
+
+func (r *MemoryRepository) Transition(interaction *Interaction, from []string, to string) (bool, error) {
+	moved := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Interaction
+		_, err := tx.Modify("interactions", interaction.ID, &stored, func() {
+			if !slices.Contains(from, stored.Status) || !stored.ExpiresAt.After(time.Now()) {
+				return
+			}
+			stored.Status = to
+			moved = true
+		})
+		return err
+	})
+	if err != nil || !moved {
+		return false, err
+	}
+
+	interaction.Status = to
+	return true, nil
+}
+
+func (r *MemoryRepository) ReadEndpoint(botId string) (*Endpoint, error) {
+	var endpoint Endpoint
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("interaction_endpoints", bson.M{"bot_id": botId}, &endpoint)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &endpoint, nil
+}
+
+func (r *MemoryRepository) SaveEndpoint(endpoint *Endpoint) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		tx.Delete("interaction_endpoints", bson.M{"bot_id": endpoint.BotID})
+		_, err := tx.Insert("interaction_endpoints", endpoint)
+		return err
+	})
+}
+
+func (r *MemoryRepository) DeleteEndpoint(botId string) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		deleted = tx.Delete("interaction_endpoints", bson.M{"bot_id": botId}) > 0
+		return nil
+	})
+
+	return deleted, err
+}
@@ -0,0 +1,112 @@
+package command
+
+import (
+	"encoding/json"
+	"harmony/internal/database"
+	"harmony/internal/event"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestInteraction stores a pending interaction created at the given time
+func newTestInteraction(t *testing.T, r Repository, createdAt time.Time) *Interaction {
+	t.Helper()
+
+	cmd := NewCommand(primitive.NewObjectID(), "bot#0001", "deploy", "Deploys", nil)
+	interaction, _ := NewInteraction(&cmd, primitive.NewObjectID(), "alice#0001", map[string]any{})
+	interaction.CreatedAt = createdAt
+	interaction.ExpiresAt = createdAt.Add(InteractionTTL)
+	if err := r.CreateInteraction(&interaction); err != nil {
+		t.Fatalf("create interaction: %v", err)
+	}
+	return &interaction
+}
+
+func TestTransition(t *testing.T) {
+	r := NewMemoryRepository(database.NewMemory())
+
+	tests := []struct {
+		name     string
+		from     []string
+		to       string
+		expected bool
+		status   string
+	}{
+		{"defer", []string{StatusPending}, StatusDeferred, true, StatusDeferred},
+		{"defer again", []string{StatusPending}, StatusDeferred, false, StatusDeferred},
+		{"reply after defer", []string{StatusPending, StatusDeferred}, StatusResponded, true, StatusResponded},
+		{"fail after reply", []string{StatusPending}, StatusFailed, false, StatusResponded},
+	}
+
+	interaction := newTestInteraction(t, r, time.Now())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved, err := r.Transition(interaction, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("transition: %v", err)
+			}
+			if moved != tt.expected {
+				t.Fatalf("expected moved %v, got %v", tt.expected, moved)
+			}
+			stored, err := r.ReadInteraction(interaction.ID)
+			if err != nil {
+				t.Fatalf("read interaction: %v", err)
+			}
+			if stored.Status != tt.status || interaction.Status != tt.status {
+				t.Fatalf("expected %s, got %s stored and %s", tt.status, stored.Status, interaction.Status)
+			}
+		})
+	}
+
+	// the token expired, nothing can change anymore
+	expired := newTestInteraction(t, r, time.Now().Add(-InteractionTTL-time.Minute))
+	if moved, err := r.Transition(expired, []string{StatusPending}, StatusFailed); err != nil || moved {
+		t.Fatalf("expected the expired interaction to stay, got %v %v", moved, err)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	r := NewMemoryRepository(database.NewMemory())
+	bus := event.NewLocalBus()
+	failed := []string{}
+	bus.Subscribe(func(evt event.Event) {
+		if evt.Type != event.InteractionFailed {
+			return
+		}
+		var data struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(evt.Data.(json.RawMessage), &data); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		failed = append(failed, data.ID)
+	})
+
+	// left pending by an instance that restarted
+	stale := newTestInteraction(t, r, time.Now().Add(-time.Minute))
+	answered := newTestInteraction(t, r, time.Now().Add(-time.Minute))
+	if _, err := r.Transition(answered, []string{StatusPending}, StatusDeferred); err != nil {
+		t.Fatalf("transition: %v", err)
+	}
+	// still within the response window
+	recent := newTestInteraction(t, r, time.Now())
+
+	sweeper := NewSweeper(r, bus, time.Hour)
+	sweeper.run()
+	sweeper.run()
+
+	if len(failed) != 1 || failed[0] != stale.ID.Hex() {
+		t.Fatalf("expected only %s to fail once, got %v", stale.ID.Hex(), failed)
+	}
+	for _, interaction := range []*Interaction{answered, recent} {
+		stored, err := r.ReadInteraction(interaction.ID)
+		if err != nil {
+			t.Fatalf("read interaction: %v", err)
+		}
+		if stored.Status == StatusFailed {
+			t.Fatalf("expected %s to be left alone", stored.ID.Hex())
+		}
+	}
+}
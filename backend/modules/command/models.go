@@ -0,0 +1,380 @@
+package command
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	"harmony/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxCommands is how many commands a bot can register in a server
+	MaxCommands = 50
+	MaxOptions  = 25
+	MaxChoices  = 25
+	secretSize  = 32
+)
+
+// option types
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeUser    = "user"
+	TypeChannel = "channel"
+	TypeRole    = "role"
+)
+
+var Types = []string{TypeString, TypeInteger, TypeNumber, TypeBoolean, TypeUser, TypeChannel, TypeRole}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Command is a slash command a bot registered in a server, names are unique inside the server
+type Command struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID    primitive.ObjectID `bson:"server_id"`
+	BotID       string             `bson:"bot_id"`
+	Name        string             `bson:"name"`
+	Description string             `bson:"description"`
+	Options     []Option           `bson:"options"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at"`
+}
+
+type Option struct {
+	Name        string   `bson:"name" json:"name"`
+	Description string   `bson:"description" json:"description"`
+	Type        string   `bson:"type" json:"type"`
+	Required    bool     `bson:"required" json:"required"`
+	Choices     []Choice `bson:"choices,omitempty" json:"choices,omitempty"`
+	// MinValue and MaxValue bound numbers, or the length of strings
+	MinValue *float64 `bson:"min_value,omitempty" json:"min_value,omitempty"`
+	MaxValue *float64 `bson:"max_value,omitempty" json:"max_value,omitempty"`
+}
+
+// Choice restricts the values of an option to a fixed list
+type Choice struct {
+	Name  string `bson:"name" json:"name"`
+	Value any    `bson:"value" json:"value"`
+}
+
+func NewCommand(serverId primitive.ObjectID, botId string, name string, description string, options []Option) Command {
+	if options == nil {
+		options = []Option{}
+	}
+	return Command{
+		ServerID:    serverId,
+		BotID:       botId,
+		Name:        name,
+		Description: description,
+		Options:     options,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+func IsNameValid(name string) (bool, string) {
+	if !namePattern.MatchString(name) {
+		return false, "Name must be 1 to 32 lowercase letters, digits, - or _"
+	}
+	return true, ""
+}
+
+func IsDescriptionValid(description string) (bool, string) {
+	if len(description) == 0 {
+		return false, "Description is empty"
+	}
+	if len(description) > 100 {
+		return false, "Description is too long"
+	}
+	return true, ""
+}
+
+func IsOptionsValid(options []Option) (bool, string) {
+	if len(options) > MaxOptions {
+		return false, fmt.Sprintf("A command can have at most %d options", MaxOptions)
+	}
+	names := map[string]bool{}
+	optional := false
+	for _, option := range options {
+		if check, errMsg := IsNameValid(option.Name); !check {
+			return false, fmt.Sprintf("Option %s: %s", option.Name, errMsg)
+		}
+		if names[option.Name] {
+			return false, fmt.Sprintf("Option %s is repeated", option.Name)
+		}
+		names[option.Name] = true
+		if check, errMsg := IsDescriptionValid(option.Description); !check {
+			return false, fmt.Sprintf("Option %s: %s", option.Name, errMsg)
+		}
+		if !utils.Contains(Types, option.Type) {
+			return false, fmt.Sprintf("Option %s has an unknown type", option.Name)
+		}
+		// the required options come first so they can be given by position
+		if option.Required && optional {
+			return false, fmt.Sprintf("Option %s is required after an optional one", option.Name)
+		}
+		optional = optional || !option.Required
+
+		bounded := option.Type == TypeString || option.Type == TypeInteger || option.Type == TypeNumber
+		if !bounded && (option.MinValue != nil || option.MaxValue != nil || len(option.Choices) > 0) {
+			return false, fmt.Sprintf("Option %s of type %s cannot have choices nor bounds", option.Name, option.Type)
+		}
+		if option.MinValue != nil && option.MaxValue != nil && *option.MinValue > *option.MaxValue {
+			return false, fmt.Sprintf("Option %s has min_value greater than max_value", option.Name)
+		}
+		if len(option.Choices) > MaxChoices {
+			return false, fmt.Sprintf("Option %s can have at most %d choices", option.Name, MaxChoices)
+		}
+		for _, choice := range option.Choices {
+			if len(choice.Name) == 0 || len(choice.Name) > 100 {
+				return false, fmt.Sprintf("Option %s has a choice with a wrong name", option.Name)
+			}
+			if _, ok := option.convert(choice.Value); !ok {
+				return false, fmt.Sprintf("Option %s has a choice of the wrong type", option.Name)
+			}
+		}
+	}
+	return true, ""
+}
+
+// convert checks the JSON value has the type of the option, numbers are returned as float64
+// while users, channels and roles are plain ids checked against the server by the caller
+func (o *Option) convert(value any) (any, bool) {
+	switch o.Type {
+	case TypeString, TypeUser, TypeChannel, TypeRole:
+		s, ok := value.(string)
+		return s, ok && s != ""
+	case TypeBoolean:
+		b, ok := value.(bool)
+		return b, ok
+	case TypeInteger, TypeNumber:
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case int32:
+			n = float64(v)
+		case int64:
+			n = float64(v)
+		case int:
+			n = float64(v)
+		default:
+			return nil, false
+		}
+		if o.Type == TypeInteger && n != math.Trunc(n) {
+			return nil, false
+		}
+		return n, true
+	}
+	return nil, false
+}
+
+// Parse checks the arguments of an invocation against the options of the command,
+// the references to users, channels and roles are returned to be resolved by the caller
+func (c *Command) Parse(arguments map[string]any) (map[string]any, map[string]string, error) {
+	values := map[string]any{}
+	references := map[string]string{}
+	for name := range arguments {
+		if _, ok := c.Option(name); !ok {
+			return nil, nil, fmt.Errorf("Option %s does not exist", name)
+		}
+	}
+	for _, option := range c.Options {
+		raw, given := arguments[option.Name]
+		if !given || raw == nil {
+			if option.Required {
+				return nil, nil, fmt.Errorf("Option %s is required", option.Name)
+			}
+			continue
+		}
+		value, ok := option.convert(raw)
+		if !ok {
+			return nil, nil, fmt.Errorf("Option %s must be a %s", option.Name, option.Type)
+		}
+		if err := option.check(value); err != nil {
+			return nil, nil, err
+		}
+		switch option.Type {
+		case TypeUser, TypeChannel, TypeRole:
+			references[option.Name] = option.Type
+		}
+		values[option.Name] = value
+	}
+	return values, references, nil
+}
+
+// check applies the choices and the bounds of the option to a converted value
+func (o *Option) check(value any) error {
+	if len(o.Choices) > 0 {
+		for _, choice := range o.Choices {
+			if converted, _ := o.convert(choice.Value); converted == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("Option %s must be one of the choices", o.Name)
+	}
+
+	var size float64
+	switch v := value.(type) {
+	case float64:
+		size = v
+	case string:
+		if o.Type != TypeString {
+			return nil
+		}
+		size = float64(len(v))
+	default:
+		return nil
+	}
+	if o.MinValue != nil && size < *o.MinValue {
+		return fmt.Errorf("Option %s is below %v", o.Name, *o.MinValue)
+	}
+	if o.MaxValue != nil && size > *o.MaxValue {
+		return fmt.Errorf("Option %s is above %v", o.Name, *o.MaxValue)
+	}
+	return nil
+}
+
+func (c *Command) Option(name string) (*Option, bool) {
+	for i := range c.Options {
+		if c.Options[i].Name == name {
+			return &c.Options[i], true
+		}
+	}
+	return nil, false
+}
+
+func (c *Command) Print() map[string]any {
+	return map[string]any{
+		"id":          c.ID,
+		"server_id":   c.ServerID,
+		"bot_id":      c.BotID,
+		"name":        c.Name,
+		"description": c.Description,
+		"options":     c.Options,
+		"created_at":  c.CreatedAt,
+		"updated_at":  c.UpdatedAt,
+	}
+}
+
+// Endpoint is the URL a bot receives its interactions on, bots without one get them on the gateway
+type Endpoint struct {
+	BotID     string    `bson:"bot_id"`
+	URL       string    `bson:"url"`
+	Secret    string    `bson:"secret"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+func NewEndpoint(botId string, url string) Endpoint {
+	return Endpoint{
+		BotID:     botId,
+		URL:       url,
+		Secret:    utils.GetRandomToken(secretSize),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// Sign is the hex HMAC-SHA256 of "<timestamp>.<body>", the same scheme of the outgoing webhooks
+func (e *Endpoint) Sign(timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(e.Secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *Endpoint) Print() map[string]any {
+	return map[string]any{
+		"bot_id":     e.BotID,
+		"url":        e.URL,
+		"updated_at": e.UpdatedAt,
+	}
+}
+
+const (
+	// ResponseWindow is how long the bot has to reply or defer before the interaction fails
+	ResponseWindow = 3 * time.Second
+	// InteractionTTL is how long the token accepts follow-ups
+	InteractionTTL = 15 * time.Minute
+	tokenSize      = 32
+)
+
+// interaction statuses
+const (
+	StatusPending   = "pending"
+	StatusDeferred  = "deferred"
+	StatusResponded = "responded"
+	StatusFailed    = "failed"
+)
+
+// response types of the callback
+const (
+	ResponseReply = "reply"
+	ResponseDefer = "defer"
+)
+
+// Interaction is an invocation of a command, the bot answers it with the token it receives
+type Interaction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID  primitive.ObjectID `bson:"server_id"`
+	ChannelID primitive.ObjectID `bson:"channel_id"`
+	CommandID primitive.ObjectID `bson:"command_id"`
+	BotID     string             `bson:"bot_id"`
+	UserID    string             `bson:"user_id"`
+	Name      string             `bson:"name"`
+	Options   map[string]any     `bson:"options"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Status    string             `bson:"status"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// NewInteraction returns the interaction and its token, only the hash of the token is kept
+func NewInteraction(cmd *Command, channelId primitive.ObjectID, userId string, options map[string]any) (Interaction, string) {
+	token := utils.GetRandomToken(tokenSize)
+	now := time.Now()
+	return Interaction{
+		ServerID:  cmd.ServerID,
+		ChannelID: channelId,
+		CommandID: cmd.ID,
+		BotID:     cmd.BotID,
+		UserID:    userId,
+		Name:      cmd.Name,
+		Options:   options,
+		TokenHash: utils.HashToken(token),
+		Status:    StatusPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(InteractionTTL),
+	}, token
+}
+
+func IsResponseTypeValid(responseType string) (bool, string) {
+	if responseType != ResponseReply && responseType != ResponseDefer {
+		return false, "Type must be reply or defer"
+	}
+	return true, ""
+}
+
+func (i *Interaction) Print() map[string]any {
+	return map[string]any{
+		"id":         i.ID,
+		"server_id":  i.ServerID,
+		"channel_id": i.ChannelID,
+		"command_id": i.CommandID,
+		"bot_id":     i.BotID,
+		"user_id":    i.UserID,
+		"name":       i.Name,
+		"options":    i.Options,
+		"status":     i.Status,
+		"created_at": i.CreatedAt,
+		"expires_at": i.ExpiresAt,
+	}
+}
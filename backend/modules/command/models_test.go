@@ -0,0 +1,81 @@
+package command
+
+import (
+	"testing"
+)
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+func TestParse(t *testing.T) {
+	cmd := Command{Options: []Option{
+		{Name: "target", Type: TypeUser, Required: true},
+		{Name: "count", Type: TypeInteger, MinValue: bound(1), MaxValue: bound(10)},
+		{Name: "reason", Type: TypeString, MaxValue: bound(5)},
+		{Name: "mode", Type: TypeString, Choices: []Choice{{Name: "Fast", Value: "fast"}, {Name: "Slow", Value: "slow"}}},
+		{Name: "silent", Type: TypeBoolean},
+	}}
+
+	tests := []struct {
+		name       string
+		arguments  map[string]any
+		values     map[string]any
+		references map[string]string
+		err        string
+	}{
+		{
+			name:       "required only",
+			arguments:  map[string]any{"target": "alice#0001"},
+			values:     map[string]any{"target": "alice#0001"},
+			references: map[string]string{"target": TypeUser},
+		},
+		{
+			name:       "every option",
+			arguments:  map[string]any{"target": "alice#0001", "count": float64(3), "reason": "spam", "mode": "fast", "silent": true},
+			values:     map[string]any{"target": "alice#0001", "count": float64(3), "reason": "spam", "mode": "fast", "silent": true},
+			references: map[string]string{"target": TypeUser},
+		},
+		{name: "missing required", arguments: map[string]any{"count": float64(3)}, err: "Option target is required"},
+		{name: "null required", arguments: map[string]any{"target": nil}, err: "Option target is required"},
+		{name: "unknown option", arguments: map[string]any{"target": "alice#0001", "other": 1}, err: "Option other does not exist"},
+		{name: "wrong type", arguments: map[string]any{"target": "alice#0001", "silent": "yes"}, err: "Option silent must be a boolean"},
+		{name: "not an integer", arguments: map[string]any{"target": "alice#0001", "count": 2.5}, err: "Option count must be a integer"},
+		{name: "below the minimum", arguments: map[string]any{"target": "alice#0001", "count": float64(0)}, err: "Option count is below 1"},
+		{name: "above the maximum", arguments: map[string]any{"target": "alice#0001", "count": float64(11)}, err: "Option count is above 10"},
+		{name: "string too long", arguments: map[string]any{"target": "alice#0001", "reason": "flooding"}, err: "Option reason is above 5"},
+		{name: "not a choice", arguments: map[string]any{"target": "alice#0001", "mode": "medium"}, err: "Option mode must be one of the choices"},
+		{name: "empty reference", arguments: map[string]any{"target": ""}, err: "Option target must be a user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, references, err := cmd.Parse(tt.arguments)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(values) != len(tt.values) {
+				t.Fatalf("expected %v, got %v", tt.values, values)
+			}
+			for name, value := range tt.values {
+				if values[name] != value {
+					t.Fatalf("expected %v, got %v", tt.values, values)
+				}
+			}
+			if len(references) != len(tt.references) {
+				t.Fatalf("expected %v, got %v", tt.references, references)
+			}
+			for name, optionType := range tt.references {
+				if references[name] != optionType {
+					t.Fatalf("expected %v, got %v", tt.references, references)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"harmony/internal/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// ErrNameTaken is returned when the server already has a command with the name
+var ErrNameTaken = errors.New("command name already taken")
+
+// Repository stores the commands, the interactions and the endpoints of the bots
+type Repository interface {
+	Create(command *Command) error
+	Read(id primitive.ObjectID) (*Command, error)
+	ReadByName(serverId primitive.ObjectID, name string) (*Command, error)
+	ReadByServer(serverId primitive.ObjectID) ([]Command, error)
+	CountByBot(serverId primitive.ObjectID, botId string) (int64, error)
+	Update(command *Command) error
+	Delete(id primitive.ObjectID) (bool, error)
+	CreateInteraction(interaction *Interaction) error
+	ReadInteraction(id primitive.ObjectID) (*Interaction, error)
+	ReadPending(createdBefore time.Time, limit int) ([]Interaction, error)
+	Transition(interaction *Interaction, from []string, to string) (bool, error)
+	ReadEndpoint(botId string) (*Endpoint, error)
+	SaveEndpoint(endpoint *Endpoint) error
+	DeleteEndpoint(botId string) (bool, error)
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+// EnsureIndexes creates the indexes of the commands, of the interactions and of the bot endpoints
+func (r *MongoRepository) EnsureIndexes() error {
+	cCommands := r.db.Collection("commands")
+	cInteractions := r.db.Collection("interactions")
+	cEndpoints := r.db.Collection("interaction_endpoints")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cCommands, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "server_id", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "server_id", Value: 1}, {Key: "bot_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// interactions are gone once their token expires, the pending ones are swept after the response window
+	err = database.EnsureIndexes(ctx, cInteractions, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return database.EnsureIndexes(ctx, cEndpoints, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "bot_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+}
+
+func (r *MongoRepository) Create(command *Command) error {
+	cCommands := r.db.Collection("commands")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cCommands.InsertOne(ctx, bson.M{
+		"server_id":   command.ServerID,
+		"bot_id":      command.BotID,
+		"name":        command.Name,
+		"description": command.Description,
+		"options":     command.Options,
+		"created_at":  command.CreatedAt,
+		"updated_at":  command.UpdatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrNameTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	command.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Command, error) {
+	cCommands := r.db.Collection("commands")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var command Command
+	err := cCommands.FindOne(ctx, bson.M{"_id": id}).Decode(&command)
+	if err != nil {
+		return nil, err
+	}
+
+	return &command, nil
+}
+
+func (r *MongoRepository) ReadByName(serverId primitive.ObjectID, name string) (*Command, error) {
+	cCommands := r.db.Collection("commands")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var command Command
+	err := cCommands.FindOne(ctx, bson.M{"server_id": serverId, "name": name}).Decode(&command)
+	if err != nil {
+		return nil, err
+	}
+
+	return &command, nil
+}
+
+func (r *MongoRepository) ReadByServer(serverId primitive.ObjectID) ([]Command, error) {
+	cCommands := r.db.Collection("commands")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := cCommands.Find(ctx, bson.M{"server_id": serverId}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := []Command{}
+	if err := cursor.All(ctx, &commands); err != nil {
+		return nil, err
+	}
+
+	return commands, nil
+}
+
+func (r *MongoRepository) CountByBot(serverId primitive.ObjectID, botId string) (int64, error) {
+	cCommands := r.db.Collection("commands")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	return cCommands.CountDocuments(ctx, bson.M{"server_id": serverId, "bot_id": botId})
+}
+
+func (r *MongoRepository) Update(command *Command) error {
+	cCommands := r.db.Collection("commands")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// cannot update:
+	// - server
+	// - bot
+	command.UpdatedAt = time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"name":        command.Name,
+			"description": command.Description,
+			"options":     command.Options,
+			"updated_at":  command.UpdatedAt,
+		},
+	}
+	_, err := cCommands.UpdateByID(ctx, command.ID, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrNameTaken
+	}
+	return err
+}
+
+func (r *MongoRepository) Delete(id primitive.ObjectID) (bool, error) {
+	cCommands := r.db.Collection("commands")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cCommands.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (r *MongoRepository) CreateInteraction(interaction *Interaction) error {
+	cInteractions := r.db.Collection("interactions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cInteractions.InsertOne(ctx, bson.M{
+		"server_id":  interaction.ServerID,
+		"channel_id": interaction.ChannelID,
+		"command_id": interaction.CommandID,
+		"bot_id":     interaction.BotID,
+		"user_id":    interaction.UserID,
+		"name":       interaction.Name,
+		"options":    interaction.Options,
+		"token_hash": interaction.TokenHash,
+		"status":     interaction.Status,
+		"created_at": interaction.CreatedAt,
+		"expires_at": interaction.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	interaction.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (r *MongoRepository) ReadInteraction(id primitive.ObjectID) (*Interaction, error) {
+	cInteractions := r.db.Collection("interactions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var interaction Interaction
+	err := cInteractions.FindOne(ctx, bson.M{"_id": id}).Decode(&interaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return &interaction, nil
+}
+
+// ReadPending returns the oldest interactions created before the time that are still pending and not expired
+func (r *MongoRepository) ReadPending(createdBefore time.Time, limit int) ([]Interaction, error) {
+	cInteractions := r.db.Collection("interactions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"status":     StatusPending,
+		"created_at": bson.M{"$lt": createdBefore},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := cInteractions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	interactions := []Interaction{}
+	if err := cursor.All(ctx, &interactions); err != nil {
+		return nil, err
+	}
+
+	return interactions, nil
+}
+
+// Transition moves the interaction to the status only if it is still in one of the given ones,
+// concurrent answers of the bot are applied once
+func (r *MongoRepository) Transition(interaction *Interaction, from []string, to string) (bool, error) {
+	cInteractions := r.db.Collection("interactions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":        interaction.ID,
+		"status":     bson.M{"$in": from},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	result, err := cInteractions.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": to}})
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	interaction.Status = to
+	return true, nil
+}
+
+func (r *MongoRepository) ReadEndpoint(botId string) (*Endpoint, error) {
+	cEndpoints := r.db.Collection("interaction_endpoints")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var endpoint Endpoint
+	err := cEndpoints.FindOne(ctx, bson.M{"bot_id": botId}).Decode(&endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &endpoint, nil
+}
+
+// SaveEndpoint sets the endpoint of the bot replacing the previous one
+func (r *MongoRepository) SaveEndpoint(endpoint *Endpoint) error {
+	cEndpoints := r.db.Collection("interaction_endpoints")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := cEndpoints.ReplaceOne(ctx, bson.M{"bot_id": endpoint.BotID}, bson.M{
+		"bot_id":     endpoint.BotID,
+		"url":        endpoint.URL,
+		"secret":     endpoint.Secret,
+		"updated_at": endpoint.UpdatedAt,
+	}, opts)
+	return err
+}
+
+func (r *MongoRepository) DeleteEndpoint(botId string) (bool, error) {
+	cEndpoints := r.db.Collection("interaction_endpoints")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cEndpoints.DeleteOne(ctx, bson.M{"bot_id": botId})
+	if err != nil {
+		return false, err
+	}
+
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
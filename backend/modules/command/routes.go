@@ -0,0 +1,28 @@
+package command
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/", h.List)
+	r.POST("/", h.Create)
+	r.PATCH("/:commandId", h.Update)
+	r.DELETE("/:commandId", h.Delete)
+}
+
+func RegisterChannelRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/", h.Invoke)
+}
+
+func RegisterEndpointRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/", h.ReadEndpoint)
+	r.PUT("/", h.UpdateEndpoint)
+	r.DELETE("/", h.DeleteEndpoint)
+}
+
+// RegisterRoutesNoAuth registers the URLs the bots answer on, the interaction token is the credential
+func RegisterRoutesNoAuth(r *gin.RouterGroup, h *Handler) {
+	r.POST("/:interactionId/:token/callback", h.Callback)
+	r.POST("/:interactionId/:token/followups", h.Followup)
+}
@@ -0,0 +1,76 @@
+package command
+
+import (
+	"context"
+	"harmony/internal/event"
+	"log"
+	"sync"
+	"time"
+)
+
+// sweepBatch is how many interactions a sweep fails at most, the next one goes on with the rest
+const sweepBatch = 100
+
+// Sweeper fails in the background the interactions still pending after the response window, the timer
+// of the instance that created them is lost when it restarts. Every instance can run it, an interaction
+// is failed once
+type Sweeper struct {
+	Repo     Repository
+	Bus      event.Bus
+	interval time.Duration
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewSweeper creates the job, a zero interval disables it
+func NewSweeper(repo Repository, bus event.Bus, interval time.Duration) *Sweeper {
+	return &Sweeper{
+		Repo:     repo,
+		Bus:      bus,
+		interval: interval,
+	}
+}
+
+// Start sweeps right away, for the interactions left by the previous run, and then at every interval
+func (s *Sweeper) Start() {
+	if s.interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.run()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Sweeper) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Sweeper) run() {
+	interactions, err := s.Repo.ReadPending(time.Now().Add(-ResponseWindow), sweepBatch)
+	if err != nil {
+		log.Printf("command: failed to read pending interactions: %v", err)
+		return
+	}
+	for i := range interactions {
+		fail(s.Repo, s.Bus, &interactions[i])
+	}
+}
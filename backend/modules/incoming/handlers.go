@@ -0,0 +1,364 @@
+package incoming
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"harmony/internal/event"
+	"harmony/modules/channel"
+	"harmony/modules/message"
+	"harmony/modules/server"
+	"harmony/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Handler struct {
+	Repo        Repository
+	Membership  *server.Membership
+	RepoChannel channel.Repository
+	RepoMessage message.Repository
+	Bus         event.Bus
+	Limiter     *Limiter
+}
+
+func NewHandler(repo Repository, repoChannel channel.Repository, repoMessage message.Repository, membership *server.Membership, bus event.Bus) *Handler {
+	return &Handler{
+		Repo:        repo,
+		Membership:  membership,
+		RepoChannel: repoChannel,
+		RepoMessage: repoMessage,
+		Bus:         bus,
+		Limiter:     NewLimiter(),
+	}
+}
+
+// manageable resolves the server and text channel of the path checking the user can manage their webhooks
+func (h *Handler) manageable(c *gin.Context) (string, *server.Server, *channel.Channel, bool) {
+	id := c.Param("id")
+	channelId := c.Param("channelId")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return "", nil, nil, false
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", nil, nil, false
+	}
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", nil, nil, false
+	}
+
+	// search server
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return "", nil, nil, false
+	}
+
+	// search channel
+	ch, err := h.RepoChannel.Read(channelObjectId)
+	if err != nil || ch.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return "", nil, nil, false
+	}
+
+	// permission check
+	if !ch.Permissions(srv, member).Has(server.PermissionManageWebhooks) {
+		c.Status(http.StatusUnauthorized)
+		return "", nil, nil, false
+	}
+	if ch.Type != channel.TypeText {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Channel is not a text channel"})
+		return "", nil, nil, false
+	}
+
+	return sub, srv, ch, true
+}
+
+// webhook resolves the :webhookId of the path inside the channel
+func (h *Handler) webhook(c *gin.Context, ch *channel.Channel) (*Webhook, bool) {
+	webhookObjectId, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	webhook, err := h.Repo.Read(webhookObjectId)
+	if err != nil || webhook.ChannelID != ch.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive webhook"})
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+// withToken adds the token and the URL to post to, they are shown only when the token is created
+func withToken(c *gin.Context, webhook *Webhook, token string) map[string]any {
+	result := webhook.Print()
+	result["token"] = token
+	result["url"] = fmt.Sprintf("%s/webhooks/%s/%s", utils.GetFullHost(c), webhook.ID.Hex(), token)
+	return result
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	type RequestBody struct {
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := message.IsLinkValid(rb.AvatarURL); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	sub, srv, ch, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.Repo.ReadByChannel(ch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive webhooks"})
+		return
+	}
+	if len(webhooks) >= MaxWebhooks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many webhooks"})
+		return
+	}
+
+	// create
+	webhook, token := NewWebhook(srv.ID, ch.ID, strings.TrimSpace(rb.Name), rb.AvatarURL, sub)
+
+	err = h.Repo.Create(&webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, withToken(c, &webhook, token))
+}
+
+func (h *Handler) List(c *gin.Context) {
+	_, _, ch, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.Repo.ReadByChannel(ch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive webhooks"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		result = append(result, webhook.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) Update(c *gin.Context) {
+	type RequestBody struct {
+		Name      *string `json:"name"`
+		AvatarURL *string `json:"avatar_url"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rb.Name != nil {
+		if check, errMsg := IsNameValid(*rb.Name); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+	if rb.AvatarURL != nil {
+		if check, errMsg := message.IsLinkValid(*rb.AvatarURL); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+
+	_, _, ch, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+	webhook, ok := h.webhook(c, ch)
+	if !ok {
+		return
+	}
+
+	// update data
+	if rb.Name != nil {
+		webhook.Name = strings.TrimSpace(*rb.Name)
+	}
+	if rb.AvatarURL != nil {
+		webhook.AvatarURL = *rb.AvatarURL
+	}
+
+	err := h.Repo.Update(webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook.Print())
+}
+
+// RotateToken issues a new token, whoever had the old URL can no longer post
+func (h *Handler) RotateToken(c *gin.Context) {
+	_, _, ch, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+	webhook, ok := h.webhook(c, ch)
+	if !ok {
+		return
+	}
+
+	token := webhook.RotateToken()
+
+	err := h.Repo.Update(webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, withToken(c, webhook, token))
+}
+
+func (h *Handler) Delete(c *gin.Context) {
+	_, _, ch, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+	webhook, ok := h.webhook(c, ch)
+	if !ok {
+		return
+	}
+
+	_, err := h.Repo.Delete(webhook.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Execute posts a message into the channel of the webhook, the token in the path is the only credential.
+// The rate limit is kept by each api instance: behind a load balancer spreading the requests over n
+// instances a webhook can post up to n times RateBurst messages at once
+func (h *Handler) Execute(c *gin.Context) {
+	type RequestBody struct {
+		Content   string          `json:"content"`
+		Username  string          `json:"username"`
+		AvatarURL string          `json:"avatar_url"`
+		Embeds    []message.Embed `json:"embeds"`
+	}
+	var rb RequestBody
+
+	// search webhook, a wrong token looks like a missing webhook
+	webhookObjectId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive webhook"})
+		return
+	}
+	webhook, err := h.Repo.Read(webhookObjectId)
+	if err != nil || subtle.ConstantTimeCompare([]byte(webhook.TokenHash), []byte(utils.HashToken(c.Param("token")))) != 1 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive webhook"})
+		return
+	}
+
+	// rate limit
+	if allowed, wait := h.Limiter.Allow(webhook.ID); !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many messages"})
+		return
+	}
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := message.IsBodyValid(rb.Content, rb.Embeds); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if rb.Username != "" {
+		if check, errMsg := IsNameValid(rb.Username); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+	if check, errMsg := message.IsLinkValid(rb.AvatarURL); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search server and channel, they may have been deleted after the webhook was created
+	srv, err := h.Membership.Server(webhook.ServerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+	ch, err := h.RepoChannel.Read(webhook.ChannelID)
+	if err != nil || ch.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return
+	}
+
+	// overrides apply to this message only
+	username := webhook.Name
+	if rb.Username != "" {
+		username = strings.TrimSpace(rb.Username)
+	}
+	avatarUrl := webhook.AvatarURL
+	if rb.AvatarURL != "" {
+		avatarUrl = rb.AvatarURL
+	}
+
+	// create
+	msg := message.NewWebhookMessage(srv.ID, ch.ID, webhook.ID, username, avatarUrl, rb.Content, rb.Embeds)
+
+	err = h.RepoMessage.Create(&msg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create message"})
+		return
+	}
+
+	message.Publish(h.Bus, h.Membership.Repo, srv, ch, event.Event{
+		Type:     event.MessageCreated,
+		ServerID: srv.ID.Hex(),
+		Data:     msg.Print(),
+	})
+
+	c.JSON(http.StatusCreated, msg.Print())
+}
@@ -0,0 +1,68 @@
+package incoming
+
+import (
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// RateBurst is how many messages a webhook can post at once
+	RateBurst = 5
+	// RateInterval is how often a webhook gets to post one more message
+	RateInterval = 2 * time.Second
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket per webhook, kept in memory so every instance limits on its own:
+// the limit of a webhook is multiplied by the number of instances its requests are spread over
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[primitive.ObjectID]*bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: make(map[primitive.ObjectID]*bucket),
+	}
+}
+
+// Allow takes a token of the webhook, when none is left it returns how long to wait for the next one
+func (l *Limiter) Allow(id primitive.ObjectID) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[id]
+	if !ok {
+		l.prune(now)
+		b = &bucket{tokens: RateBurst, last: now}
+		l.buckets[id] = b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(RateInterval)
+	if b.tokens > RateBurst {
+		b.tokens = RateBurst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(RateInterval))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that are full again, they behave like new ones
+func (l *Limiter) prune(now time.Time) {
+	for id, b := range l.buckets {
+		if now.Sub(b.last) >= RateBurst*RateInterval {
+			delete(l.buckets, id)
+		}
+	}
+}
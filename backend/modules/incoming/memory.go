@@ -0,0 +1,81 @@
+package incoming
+
+import (
+	"harmony/internal/database"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository keeps the incoming webhooks in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(webhook *Webhook) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		id, err := tx.Insert("incoming_webhooks", webhook)
+		if err != nil {
+			return err
+		}
+		webhook.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Webhook, error) {
+	var webhook Webhook
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("incoming_webhooks", bson.M{"_id": id}, &webhook)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (r *MemoryRepository) ReadByChannel(channelId primitive.ObjectID) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("incoming_webhooks", bson.M{"channel_id": channelId}, &webhooks)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+
+	return webhooks, nil
+}
+
+func (r *MemoryRepository) Update(webhook *Webhook) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Webhook
+		_, err := tx.Modify("incoming_webhooks", webhook.ID, &stored, func() {
+			stored.Name = webhook.Name
+			stored.AvatarURL = webhook.AvatarURL
+			stored.TokenHash = webhook.TokenHash
+		})
+		return err
+	})
+}
+
+func (r *MemoryRepository) Delete(id primitive.ObjectID) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		deleted = tx.Delete("incoming_webhooks", bson.M{"_id": id}) > 0
+		return nil
+	})
+
+	return deleted, err
+}
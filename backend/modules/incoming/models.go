@@ -0,0 +1,70 @@
+package incoming
+
+import (
+	"harmony/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxWebhooks is how many incoming webhooks a channel can have
+	MaxWebhooks = 15
+	tokenSize   = 32
+)
+
+// Webhook posts the messages it receives on its URL into a channel
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID  primitive.ObjectID `bson:"server_id"`
+	ChannelID primitive.ObjectID `bson:"channel_id"`
+	Name      string             `bson:"name"`
+	AvatarURL string             `bson:"avatar_url"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	CreatorID string             `bson:"creator_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// NewWebhook returns the webhook and its token, only the hash of the token is kept
+func NewWebhook(serverId primitive.ObjectID, channelId primitive.ObjectID, name string, avatarUrl string, creatorId string) (Webhook, string) {
+	token := utils.GetRandomToken(tokenSize)
+	return Webhook{
+		ServerID:  serverId,
+		ChannelID: channelId,
+		Name:      name,
+		AvatarURL: avatarUrl,
+		TokenHash: utils.HashToken(token),
+		CreatorID: creatorId,
+		CreatedAt: time.Now(),
+	}, token
+}
+
+// RotateToken replaces the token of the webhook, the old URL stops working
+func (w *Webhook) RotateToken() string {
+	token := utils.GetRandomToken(tokenSize)
+	w.TokenHash = utils.HashToken(token)
+	return token
+}
+
+func IsNameValid(name string) (bool, string) {
+	if len(strings.TrimSpace(name)) == 0 {
+		return false, "Name is empty"
+	}
+	if len(name) > 80 {
+		return false, "Name is too long"
+	}
+	return true, ""
+}
+
+func (w *Webhook) Print() map[string]any {
+	return map[string]any{
+		"id":         w.ID,
+		"server_id":  w.ServerID,
+		"channel_id": w.ChannelID,
+		"name":       w.Name,
+		"avatar_url": w.AvatarURL,
+		"creator_id": w.CreatorID,
+		"created_at": w.CreatedAt,
+	}
+}
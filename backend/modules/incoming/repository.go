@@ -0,0 +1,144 @@
+package incoming
+
+import (
+	"context"
+	"harmony/internal/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Repository stores the incoming webhooks of the channels
+type Repository interface {
+	Create(webhook *Webhook) error
+	Read(id primitive.ObjectID) (*Webhook, error)
+	ReadByChannel(channelId primitive.ObjectID) ([]Webhook, error)
+	Update(webhook *Webhook) error
+	Delete(id primitive.ObjectID) (bool, error)
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+func (r *MongoRepository) EnsureIndexes() error {
+	cWebhooks := r.db.Collection("incoming_webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cWebhooks, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "channel_id", Value: 1}}},
+		{Keys: bson.D{{Key: "server_id", Value: 1}}},
+	})
+	return err
+}
+
+func (r *MongoRepository) Create(webhook *Webhook) error {
+	cWebhooks := r.db.Collection("incoming_webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cWebhooks.InsertOne(ctx, bson.M{
+		"server_id":  webhook.ServerID,
+		"channel_id": webhook.ChannelID,
+		"name":       webhook.Name,
+		"avatar_url": webhook.AvatarURL,
+		"token_hash": webhook.TokenHash,
+		"creator_id": webhook.CreatorID,
+		"created_at": webhook.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	webhook.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Webhook, error) {
+	cWebhooks := r.db.Collection("incoming_webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var webhook Webhook
+	err := cWebhooks.FindOne(ctx, bson.M{"_id": id}).Decode(&webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (r *MongoRepository) ReadByChannel(channelId primitive.ObjectID) ([]Webhook, error) {
+	cWebhooks := r.db.Collection("incoming_webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := cWebhooks.Find(ctx, bson.M{"channel_id": channelId}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := []Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *MongoRepository) Update(webhook *Webhook) error {
+	cWebhooks := r.db.Collection("incoming_webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// cannot update:
+	// - server
+	// - channel
+	// - creator
+	update := bson.M{
+		"$set": bson.M{
+			"name":       webhook.Name,
+			"avatar_url": webhook.AvatarURL,
+			"token_hash": webhook.TokenHash,
+		},
+	}
+	_, err := cWebhooks.UpdateByID(ctx, webhook.ID, update)
+	return err
+}
+
+func (r *MongoRepository) Delete(id primitive.ObjectID) (bool, error) {
+	cWebhooks := r.db.Collection("incoming_webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cWebhooks.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
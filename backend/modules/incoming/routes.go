@@ -0,0 +1,18 @@
+package incoming
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/", h.Create)
+	r.GET("/", h.List)
+	r.PATCH("/:webhookId", h.Update)
+	r.DELETE("/:webhookId", h.Delete)
+	r.POST("/:webhookId/token", h.RotateToken)
+}
+
+// RegisterRoutesNoAuth registers the URL the webhooks post to
+func RegisterRoutesNoAuth(r *gin.RouterGroup, h *Handler) {
+	r.POST("/:id/:token", h.Execute)
+}
@@ -0,0 +1,328 @@
+package invite
+
+import (
+	"errors"
+	"fmt"
+	"harmony/internal/event"
+	"harmony/modules/channel"
+	"harmony/modules/server"
+	"harmony/modules/user"
+	"harmony/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Handler struct {
+	Repo        Repository
+	RepoServer  server.Repository
+	RepoChannel channel.Repository
+	RepoUser    user.Repository
+	Membership  *server.Membership
+	Bus         event.Bus
+}
+
+func NewHandler(repo Repository, repoServer server.Repository, repoChannel channel.Repository, repoUser user.Repository, membership *server.Membership, bus event.Bus) *Handler {
+	return &Handler{
+		Repo:        repo,
+		RepoServer:  repoServer,
+		RepoChannel: repoChannel,
+		RepoUser:    repoUser,
+		Membership:  membership,
+		Bus:         bus,
+	}
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	id := c.Param("id")
+	type RequestBody struct {
+		MaxUses   int    `json:"max_uses"`
+		MaxAge    *int   `json:"max_age"`
+		ChannelID string `json:"channel_id"`
+	}
+	var rb RequestBody
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxAge := DefaultAge
+	if rb.MaxAge != nil {
+		maxAge = time.Duration(*rb.MaxAge) * time.Second
+	}
+	if check, errMsg := IsMaxUsesValid(rb.MaxUses); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsMaxAgeValid(maxAge); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search server
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// permission check, on the channel when the invite points to one
+	permissions := srv.Permissions(member)
+	var channelId *primitive.ObjectID
+	if rb.ChannelID != "" {
+		channelObjectId, err := primitive.ObjectIDFromHex(rb.ChannelID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ch, err := h.RepoChannel.Read(channelObjectId)
+		if err != nil || ch.ServerID != srv.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+			return
+		}
+		permissions = ch.Permissions(srv, member)
+		channelId = &ch.ID
+	}
+	if !permissions.Has(server.PermissionCreateInvite) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// create
+	invite := NewInvite(srv.ID, channelId, sub, rb.MaxUses, maxAge)
+
+	err = h.Repo.Create(&invite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	result := invite.Print()
+	result["link"] = fmt.Sprintf("%s/invites/%s", utils.GetFullHost(c), invite.Code)
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func (h *Handler) List(c *gin.Context) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// permission check
+	if !srv.Permissions(member).Has(server.PermissionManageServer) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// search invites
+	invites, err := h.Repo.ReadByServer(srv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive invites"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(invites))
+	for _, invite := range invites {
+		result = append(result, invite.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) Revoke(c *gin.Context) {
+	id := c.Param("id")
+	code := c.Param("code")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// search invite
+	invite, err := h.Repo.ReadByCode(code)
+	if err != nil || invite.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive invite"})
+		return
+	}
+
+	// the creator or whoever manages the server can revoke
+	if invite.CreatorID != sub && !srv.Permissions(member).Has(server.PermissionManageServer) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	err = h.Repo.Revoke(invite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) Read(c *gin.Context) {
+	code := c.Param("code")
+
+	// search invite
+	invite, err := h.Repo.ReadByCode(code)
+	if err != nil || !invite.IsUsable() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive invite"})
+		return
+	}
+
+	// search server
+	srv, err := h.Membership.Server(invite.ServerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+	members, err := h.RepoServer.CountMembers(srv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":       invite.Code,
+		"channel_id": invite.ChannelID,
+		"expires_at": invite.ExpiresAt,
+		"server": gin.H{
+			"id":      srv.ID,
+			"name":    srv.Name,
+			"image":   srv.Image,
+			"members": members,
+		},
+	})
+}
+
+func (h *Handler) Join(c *gin.Context) {
+	code := c.Param("code")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// only users who verified their mail can join
+	account, err := h.RepoUser.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+	if !account.Verified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Mail not verified"})
+		return
+	}
+
+	// search invite
+	invite, err := h.Repo.ReadByCode(code)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive invite"})
+		return
+	}
+
+	// search server
+	srv, err := h.RepoServer.Read(invite.ServerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// check already in the list
+	_, err = h.RepoServer.ReadMember(srv.ID, sub)
+	if err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User already joined server"})
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive member"})
+		return
+	}
+
+	// take a use, this fails if the invite expired, was revoked or used up in the meantime
+	invite, err = h.Repo.Use(code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invite is no longer valid"})
+		return
+	}
+
+	err = h.RepoServer.AddMember(srv, sub, server.RoleMember)
+	if err != nil {
+		if err := h.Repo.Unuse(invite); err != nil {
+			log.Printf("failed to give back invite use %s: %v", invite.Code, err)
+		}
+		// joined meanwhile through another invite
+		if errors.Is(err, server.ErrAlreadyMember) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User already joined server"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
+		return
+	}
+	h.Membership.Invalidate(srv.ID)
+
+	err = h.Bus.Publish(event.Event{
+		Type:     event.MemberJoined,
+		ServerID: srv.ID.Hex(),
+		UserID:   sub,
+		Data:     gin.H{"server_id": srv.ID, "user_id": sub},
+	})
+	if err != nil {
+		log.Printf("failed to publish %s: %v", event.MemberJoined, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"server_id":  srv.ID,
+		"channel_id": invite.ChannelID,
+	})
+}
@@ -0,0 +1,180 @@
+package invite
+
+import (
+	"bytes"
+	"encoding/json"
+	"harmony/internal/database"
+	"harmony/internal/event"
+	"harmony/modules/channel"
+	"harmony/modules/server"
+	"harmony/modules/user"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type testEnv struct {
+	handler *Handler
+	router  *gin.Engine
+	users   *user.MemoryRepository
+	server  *server.Server
+}
+
+// newTestEnv runs the invite routes on the memory storage, the X-User header is the caller
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	mem := database.NewMemory()
+	servers := server.NewMemoryRepository(mem)
+	env := &testEnv{
+		users: user.NewMemoryRepository(mem),
+	}
+	env.handler = NewHandler(NewMemoryRepository(mem), servers, channel.NewMemoryRepository(mem), env.users, server.NewMembership(servers, 0), event.NewLocalBus())
+
+	env.router = gin.New()
+	env.router.Use(func(c *gin.Context) {
+		c.Set("claims", jwt.MapClaims{"sub": c.GetHeader("X-User")})
+	})
+	RegisterRoutes(env.router.Group("/invites"), env.handler)
+	RegisterServerRoutes(env.router.Group("/servers/:id/invites"), env.handler)
+
+	owner := env.newUser(t, "owner", true)
+	env.server = &server.Server{Name: "guild", OwnerID: owner}
+	if err := servers.Create(env.server); err != nil {
+		t.Fatalf("create server: %v", err)
+	}
+	return env
+}
+
+// newUser returns the unique name of a new user
+func (env *testEnv) newUser(t *testing.T, name string, verified bool) string {
+	t.Helper()
+
+	u := user.NewUser(name, name+"@example.com")
+	if err := env.users.Create(&u); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if verified {
+		if err := env.users.MarkVerified(&u); err != nil {
+			t.Fatalf("verify user: %v", err)
+		}
+	}
+	return u.UniqueName
+}
+
+func (env *testEnv) request(t *testing.T, sub string, method string, path string, body any) (int, map[string]any) {
+	t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", sub)
+	w := httptest.NewRecorder()
+	env.router.ServeHTTP(w, req)
+
+	response := map[string]any{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+// newInvite stores an invite of the server, expired ones can't be created through the api
+func (env *testEnv) newInvite(t *testing.T, maxUses int, expiresAt *time.Time) *Invite {
+	t.Helper()
+
+	invite := NewInvite(env.server.ID, nil, env.server.OwnerID, maxUses, 0)
+	invite.ExpiresAt = expiresAt
+	if err := env.handler.Repo.Create(&invite); err != nil {
+		t.Fatalf("create invite: %v", err)
+	}
+	return &invite
+}
+
+func TestInviteMaxUses(t *testing.T) {
+	env := newTestEnv(t)
+
+	status, created := env.request(t, env.server.OwnerID, http.MethodPost, "/servers/"+env.server.ID.Hex()+"/invites/", map[string]any{"max_uses": 2})
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %v", status, created)
+	}
+	code := created["code"].(string)
+
+	// a member joining again doesn't take a use
+	if status, _ := env.request(t, env.server.OwnerID, http.MethodPost, "/invites/"+code, nil); status != http.StatusBadRequest {
+		t.Fatalf("expected the owner to be refused, got %d", status)
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		if status, response := env.request(t, env.newUser(t, name, true), http.MethodPost, "/invites/"+code, nil); status != http.StatusOK {
+			t.Fatalf("expected %s to join, got %d %v", name, status, response)
+		}
+	}
+
+	carol := env.newUser(t, "carol", true)
+	if status, _ := env.request(t, carol, http.MethodPost, "/invites/"+code, nil); status != http.StatusBadRequest {
+		t.Fatalf("expected the used up invite to be refused, got %d", status)
+	}
+	if status, _ := env.request(t, carol, http.MethodGet, "/invites/"+code, nil); status != http.StatusNotFound {
+		t.Fatalf("expected the used up invite to be hidden, got %d", status)
+	}
+
+	invite, err := env.handler.Repo.ReadByCode(code)
+	if err != nil {
+		t.Fatalf("read invite: %v", err)
+	}
+	if invite.Uses != 2 {
+		t.Fatalf("expected 2 uses, got %d", invite.Uses)
+	}
+}
+
+func TestInviteNotUsable(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		revoked   bool
+		verified  bool
+		read      int
+		join      int
+	}{
+		{"usable", &future, false, true, http.StatusOK, http.StatusOK},
+		{"never expires", nil, false, true, http.StatusOK, http.StatusOK},
+		{"expired", &past, false, true, http.StatusNotFound, http.StatusBadRequest},
+		{"revoked", &future, true, true, http.StatusNotFound, http.StatusBadRequest},
+		{"mail not verified", &future, false, false, http.StatusOK, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			invite := env.newInvite(t, 0, tt.expiresAt)
+			if tt.revoked {
+				status, _ := env.request(t, env.server.OwnerID, http.MethodDelete, "/servers/"+env.server.ID.Hex()+"/invites/"+invite.Code, nil)
+				if status != http.StatusNoContent {
+					t.Fatalf("expected the invite to be revoked, got %d", status)
+				}
+			}
+			alice := env.newUser(t, "alice", tt.verified)
+
+			if status, _ := env.request(t, alice, http.MethodGet, "/invites/"+invite.Code, nil); status != tt.read {
+				t.Fatalf("expected read %d, got %d", tt.read, status)
+			}
+			if status, _ := env.request(t, alice, http.MethodPost, "/invites/"+invite.Code, nil); status != tt.join {
+				t.Fatalf("expected join %d, got %d", tt.join, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package invite
+
+import (
+	"errors"
+	"harmony/internal/database"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRepository keeps the invites in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	mem.Unique("invites", "code")
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(invite *Invite) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		for attempt := 1; ; attempt++ {
+			err := tx.FindOne("invites", bson.M{"code": invite.Code}, &Invite{})
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if attempt == codeAttempts {
+				return errors.New("invite code is already taken")
+			}
+			invite.Code = NewCode()
+		}
+
+		id, err := tx.Insert("invites", invite)
+		if err != nil {
+			return err
+		}
+		invite.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) ReadByCode(code string) (*Invite, error) {
+	var invite Invite
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("invites", bson.M{"code": code}, &invite)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (r *MemoryRepository) ReadByServer(serverId primitive.ObjectID) ([]Invite, error) {
+	invites := []Invite{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("invites", bson.M{"server_id": serverId}, &invites)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(invites, func(i, j int) bool {
+		return invites[i].CreatedAt.After(invites[j].CreatedAt)
+	})
+
+	return invites, nil
+}
+
+func (r *MemoryRepository) Use(code string) (*Invite, error) {
+	var invite Invite
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		if err := tx.FindOne("invites", bson.M{"code": code}, &invite); err != nil {
+			return err
+		}
+		if !invite.IsUsable() {
+			return mongo.ErrNoDocuments
+		}
+
+		invite.Uses++
+		_, err := tx.Replace("invites", invite.ID, invite)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (r *MemoryRepository) Unuse(invite *Invite) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Invite
+		_, err := tx.Modify("invites", invite.ID, &stored, func() {
+			stored.Uses--
+		})
+		return err
+	})
+}
+
+func (r *MemoryRepository) Revoke(invite *Invite) error {
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Invite
+		_, err := tx.Modify("invites", invite.ID, &stored, func() {
+			stored.Revoked = true
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	invite.Revoked = true
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package invite
+
+import (
+	"harmony/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	codeLength = 8
+	// codeAttempts is how many codes are tried when the generated one is already taken
+	codeAttempts = 5
+	// MaxAge is the longest an expiring invite can last, a max age of 0 never expires
+	MaxAge = 7 * 24 * time.Hour
+	// DefaultAge is used when the max age is not given
+	DefaultAge = 24 * time.Hour
+)
+
+type Invite struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty"`
+	Code      string              `bson:"code"`
+	ServerID  primitive.ObjectID  `bson:"server_id"`
+	ChannelID *primitive.ObjectID `bson:"channel_id,omitempty"`
+	CreatorID string              `bson:"creator_id"`
+	// MaxUses 0 means unlimited
+	MaxUses   int        `bson:"max_uses"`
+	Uses      int        `bson:"uses"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty"`
+	Revoked   bool       `bson:"revoked"`
+	CreatedAt time.Time  `bson:"created_at"`
+}
+
+func NewInvite(serverId primitive.ObjectID, channelId *primitive.ObjectID, creatorId string, maxUses int, maxAge time.Duration) Invite {
+	invite := Invite{
+		Code:      NewCode(),
+		ServerID:  serverId,
+		ChannelID: channelId,
+		CreatorID: creatorId,
+		MaxUses:   maxUses,
+		CreatedAt: time.Now(),
+	}
+	if maxAge > 0 {
+		expiresAt := invite.CreatedAt.Add(maxAge)
+		invite.ExpiresAt = &expiresAt
+	}
+	return invite
+}
+
+// NewCode generates the random code an invite is shared with
+func NewCode() string {
+	return utils.GetRandomString(codeLength)
+}
+
+func IsMaxUsesValid(maxUses int) (bool, string) {
+	if maxUses < 0 || maxUses > 100 {
+		return false, "Max uses must be between 0 and 100"
+	}
+	return true, ""
+}
+
+func IsMaxAgeValid(maxAge time.Duration) (bool, string) {
+	if maxAge < 0 || maxAge > MaxAge {
+		return false, "Max age must be between 0 and 7 days"
+	}
+	return true, ""
+}
+
+// IsUsable tells if the invite can still be used to join
+func (i *Invite) IsUsable() bool {
+	if i.Revoked {
+		return false
+	}
+	if i.ExpiresAt != nil && time.Now().After(*i.ExpiresAt) {
+		return false
+	}
+	if i.MaxUses > 0 && i.Uses >= i.MaxUses {
+		return false
+	}
+	return true
+}
+
+func (i *Invite) Print() map[string]any {
+	return map[string]any{
+		"code":       i.Code,
+		"server_id":  i.ServerID,
+		"channel_id": i.ChannelID,
+		"creator_id": i.CreatorID,
+		"max_uses":   i.MaxUses,
+		"uses":       i.Uses,
+		"expires_at": i.ExpiresAt,
+		"revoked":    i.Revoked,
+		"created_at": i.CreatedAt,
+	}
+}
@@ -0,0 +1,177 @@
+package invite
+
+import (
+	"context"
+	"harmony/internal/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Repository stores the invites, Use and Unuse keep the use counter consistent with concurrent joins
+type Repository interface {
+	Create(invite *Invite) error
+	ReadByCode(code string) (*Invite, error)
+	ReadByServer(serverId primitive.ObjectID) ([]Invite, error)
+	Use(code string) (*Invite, error)
+	Unuse(invite *Invite) error
+	Revoke(invite *Invite) error
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+// EnsureIndexes creates the unique index of the codes, every join looks the invite up by code
+func (r *MongoRepository) EnsureIndexes() error {
+	cInvites := r.db.Collection("invites")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cInvites, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "server_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Create stores the invite, a new code is generated when the one of the invite is already taken
+func (r *MongoRepository) Create(invite *Invite) error {
+	cInvites := r.db.Collection("invites")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		result, err := cInvites.InsertOne(ctx, bson.M{
+			"code":       invite.Code,
+			"server_id":  invite.ServerID,
+			"channel_id": invite.ChannelID,
+			"creator_id": invite.CreatorID,
+			"max_uses":   invite.MaxUses,
+			"uses":       invite.Uses,
+			"expires_at": invite.ExpiresAt,
+			"revoked":    invite.Revoked,
+			"created_at": invite.CreatedAt,
+		})
+		if mongo.IsDuplicateKeyError(err) && attempt < codeAttempts {
+			invite.Code = NewCode()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		invite.ID = result.InsertedID.(primitive.ObjectID)
+		return nil
+	}
+}
+
+func (r *MongoRepository) ReadByCode(code string) (*Invite, error) {
+	cInvites := r.db.Collection("invites")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var invite Invite
+	err := cInvites.FindOne(ctx, bson.M{"code": code}).Decode(&invite)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (r *MongoRepository) ReadByServer(serverId primitive.ObjectID) ([]Invite, error) {
+	cInvites := r.db.Collection("invites")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := cInvites.Find(ctx, bson.M{"server_id": serverId}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	invites := []Invite{}
+	if err := cursor.All(ctx, &invites); err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// Use increments the use counter only if the invite is still usable, otherwise mongo.ErrNoDocuments is returned
+func (r *MongoRepository) Use(code string) (*Invite, error) {
+	cInvites := r.db.Collection("invites")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"code":    code,
+		"revoked": false,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"expires_at": nil},
+				bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"max_uses": 0},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+			}},
+		},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var invite Invite
+	err := cInvites.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}}, opts).Decode(&invite)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+// Unuse gives back a use taken by a join that failed
+func (r *MongoRepository) Unuse(invite *Invite) error {
+	cInvites := r.db.Collection("invites")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cInvites.UpdateByID(ctx, invite.ID, bson.M{"$inc": bson.M{"uses": -1}})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *MongoRepository) Revoke(invite *Invite) error {
+	cInvites := r.db.Collection("invites")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cInvites.UpdateByID(ctx, invite.ID, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return err
+	}
+	invite.Revoked = true
+
+	return nil
+}
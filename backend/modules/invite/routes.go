@@ -0,0 +1,16 @@
+package invite
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/:code", h.Read)
+	r.POST("/:code", h.Join)
+}
+
+func RegisterServerRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/", h.Create)
+	r.GET("/", h.List)
+	r.DELETE("/:code", h.Revoke)
+}
@@ -0,0 +1,105 @@
+package message
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const (
+	MaxEmbeds      = 10
+	MaxEmbedFields = 25
+	// MaxEmbedSize is how many characters all the embeds of a message can hold together
+	MaxEmbedSize = 6000
+)
+
+type EmbedField struct {
+	Name   string `bson:"name" json:"name"`
+	Value  string `bson:"value" json:"value"`
+	Inline bool   `bson:"inline" json:"inline"`
+}
+
+// Embed is a rich block attached to a message
+type Embed struct {
+	Title       string       `bson:"title,omitempty" json:"title,omitempty"`
+	Description string       `bson:"description,omitempty" json:"description,omitempty"`
+	URL         string       `bson:"url,omitempty" json:"url,omitempty"`
+	Color       int          `bson:"color,omitempty" json:"color,omitempty"`
+	ImageURL    string       `bson:"image_url,omitempty" json:"image_url,omitempty"`
+	Footer      string       `bson:"footer,omitempty" json:"footer,omitempty"`
+	Fields      []EmbedField `bson:"fields,omitempty" json:"fields,omitempty"`
+}
+
+func IsEmbedsValid(embeds []Embed) (bool, string) {
+	if len(embeds) > MaxEmbeds {
+		return false, fmt.Sprintf("A message can have at most %d embeds", MaxEmbeds)
+	}
+	size := 0
+	for i, embed := range embeds {
+		size += len(embed.Title) + len(embed.Description) + len(embed.Footer)
+		if embed.Title == "" && embed.Description == "" && len(embed.Fields) == 0 && embed.ImageURL == "" {
+			return false, fmt.Sprintf("Embed %d is empty", i)
+		}
+		if len(embed.Title) > 256 {
+			return false, fmt.Sprintf("Title of embed %d is too long", i)
+		}
+		if len(embed.Description) > 4096 {
+			return false, fmt.Sprintf("Description of embed %d is too long", i)
+		}
+		if len(embed.Footer) > 2048 {
+			return false, fmt.Sprintf("Footer of embed %d is too long", i)
+		}
+		if embed.Color < 0 || embed.Color > 0xFFFFFF {
+			return false, fmt.Sprintf("Color of embed %d is not valid", i)
+		}
+		if check, _ := IsLinkValid(embed.URL); !check {
+			return false, fmt.Sprintf("URL of embed %d is not valid", i)
+		}
+		if check, _ := IsLinkValid(embed.ImageURL); !check {
+			return false, fmt.Sprintf("Image URL of embed %d is not valid", i)
+		}
+		if len(embed.Fields) > MaxEmbedFields {
+			return false, fmt.Sprintf("Embed %d can have at most %d fields", i, MaxEmbedFields)
+		}
+		for _, field := range embed.Fields {
+			size += len(field.Name) + len(field.Value)
+			if field.Name == "" || len(field.Name) > 256 {
+				return false, fmt.Sprintf("Field name of embed %d is not valid", i)
+			}
+			if field.Value == "" || len(field.Value) > 1024 {
+				return false, fmt.Sprintf("Field value of embed %d is not valid", i)
+			}
+		}
+	}
+	if size > MaxEmbedSize {
+		return false, "Embeds are too long"
+	}
+	return true, ""
+}
+
+// IsBodyValid checks the messages posted by webhooks and bots, they can be made of embeds only
+func IsBodyValid(content string, embeds []Embed) (bool, string) {
+	if content == "" && len(embeds) == 0 {
+		return false, "Content and embeds are empty"
+	}
+	if content != "" {
+		if check, errMsg := IsContentValid(content); !check {
+			return false, errMsg
+		}
+	}
+	return IsEmbedsValid(embeds)
+}
+
+// IsLinkValid accepts empty links or absolute http(s) ones
+func IsLinkValid(raw string) (bool, string) {
+	if raw == "" {
+		return true, ""
+	}
+	if len(raw) > 2048 {
+		return false, "URL is too long"
+	}
+	link, err := url.Parse(raw)
+	if err != nil || link.Host == "" || (link.Scheme != "http" && link.Scheme != "https") {
+		return false, "URL is not valid"
+	}
+	return true, ""
+}
@@ -0,0 +1,339 @@
+package message
+
+import (
+	"harmony/internal/event"
+	"harmony/modules/channel"
+	"harmony/modules/server"
+	"harmony/utils"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Handler struct {
+	Repo        Repository
+	Membership  *server.Membership
+	RepoChannel channel.Repository
+	Bus         event.Bus
+}
+
+func NewHandler(repo Repository, repoChannel channel.Repository, membership *server.Membership, bus event.Bus) *Handler {
+	return &Handler{
+		Repo:        repo,
+		Membership:  membership,
+		RepoChannel: repoChannel,
+		Bus:         bus,
+	}
+}
+
+// readChannel resolves the server and text channel in the path and checks the caller can read it,
+// on failure the response is already written
+func (h *Handler) readChannel(c *gin.Context) (*server.Member, *server.Server, *channel.Channel, bool) {
+	id := c.Param("id")
+	channelId := c.Param("channelId")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return nil, nil, nil, false
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, false
+	}
+	channelObjectId, err := primitive.ObjectIDFromHex(channelId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, false
+	}
+
+	// search server
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return nil, nil, nil, false
+	}
+
+	// search channel
+	ch, err := h.RepoChannel.Read(channelObjectId)
+	if err != nil || ch.ServerID != srv.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive channel"})
+		return nil, nil, nil, false
+	}
+
+	// permission check
+	if !ch.Permissions(srv, member).Has(server.PermissionReadMessages) {
+		c.Status(http.StatusUnauthorized)
+		return nil, nil, nil, false
+	}
+	if ch.Type != channel.TypeText {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Channel is not a text channel"})
+		return nil, nil, nil, false
+	}
+
+	return member, srv, ch, true
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	type RequestBody struct {
+		Content string `json:"content"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsContentValid(rb.Content); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	member, srv, ch, ok := h.readChannel(c)
+	if !ok {
+		return
+	}
+
+	// permission check
+	if !ch.Permissions(srv, member).Has(server.PermissionSendMessages) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// create
+	message := NewMessage(srv.ID, ch.ID, member.UserID, rb.Content)
+
+	err := h.Repo.Create(&message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create message"})
+		return
+	}
+
+	Publish(h.Bus, h.Membership.Repo, srv, ch, event.Event{
+		Type:     event.MessageCreated,
+		ServerID: srv.ID.Hex(),
+		Data:     message.Print(),
+	})
+
+	c.JSON(http.StatusCreated, message.Print())
+}
+
+func (h *Handler) List(c *gin.Context) {
+	var page Page
+
+	// validate input
+	cursors := map[string]*primitive.ObjectID{
+		"before": &page.Before,
+		"after":  &page.After,
+		"around": &page.Around,
+	}
+	set := 0
+	for name, cursor := range cursors {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		objectId, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to resolve " + name})
+			return
+		}
+		*cursor = objectId
+		set++
+	}
+	if set > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only one of before, after and around is allowed"})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > MaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to resolve limit"})
+			return
+		}
+		page.Limit = l
+	}
+
+	_, _, ch, ok := h.readChannel(c)
+	if !ok {
+		return
+	}
+
+	// search messages
+	messages, err := h.Repo.ReadByChannel(ch.ID, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive messages"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) Update(c *gin.Context) {
+	messageId := c.Param("messageId")
+	type RequestBody struct {
+		Content string `json:"content"`
+	}
+	var rb RequestBody
+
+	// validate input
+	messageObjectId, err := primitive.ObjectIDFromHex(messageId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsContentValid(rb.Content); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	member, srv, ch, ok := h.readChannel(c)
+	if !ok {
+		return
+	}
+
+	// search message
+	message, err := h.Repo.Read(messageObjectId)
+	if err != nil || message.ChannelID != ch.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive message"})
+		return
+	}
+
+	// only the author can edit
+	if message.AuthorID != member.UserID {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// update data
+	message.Content = rb.Content
+
+	err = h.Repo.Update(message)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message"})
+		return
+	}
+
+	Publish(h.Bus, h.Membership.Repo, srv, ch, event.Event{
+		Type:     event.MessageUpdated,
+		ServerID: srv.ID.Hex(),
+		Data:     message.Print(),
+	})
+
+	c.JSON(http.StatusOK, message.Print())
+}
+
+func (h *Handler) Delete(c *gin.Context) {
+	messageId := c.Param("messageId")
+
+	// validate input
+	messageObjectId, err := primitive.ObjectIDFromHex(messageId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, srv, ch, ok := h.readChannel(c)
+	if !ok {
+		return
+	}
+
+	// search message
+	message, err := h.Repo.Read(messageObjectId)
+	if err != nil || message.ChannelID != ch.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive message"})
+		return
+	}
+
+	// the author or whoever manages messages can delete
+	if message.AuthorID != member.UserID && !ch.Permissions(srv, member).Has(server.PermissionManageMessages) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// try deleting
+	isDeleted, err := h.Repo.Delete(message.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		return
+	}
+	if !isDeleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive message"})
+		return
+	}
+
+	Publish(h.Bus, h.Membership.Repo, srv, ch, event.Event{
+		Type:     event.MessageDeleted,
+		ServerID: srv.ID.Hex(),
+		Data:     gin.H{"id": message.ID, "channel_id": message.ChannelID, "server_id": message.ServerID},
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+// Audience tells who receives the events of the channel: nobody is excluded and the audience is nil when
+// every member can read it, otherwise either the members that can read it or the ones that can't.
+// Only the members the roles and the overwrites point to are read, never all the members of the server
+func Audience(repoServer server.Repository, srv *server.Server, ch *channel.Channel) ([]string, []string, error) {
+	public, roles, users := ch.ReaderQuery(srv)
+	if public && len(roles) == 0 && len(users) == 0 {
+		return nil, nil, nil
+	}
+
+	candidates, err := repoServer.ReadMembersByRoles(srv.ID, roles, users)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	audience := []string{}
+	excluded := []string{}
+	for i := range candidates {
+		canRead := ch.Permissions(srv, &candidates[i]).Has(server.PermissionReadMessages)
+		if public && !canRead {
+			excluded = append(excluded, candidates[i].UserID)
+		}
+		if !public && canRead {
+			audience = append(audience, candidates[i].UserID)
+		}
+	}
+
+	if !public {
+		return audience, nil, nil
+	}
+	if len(excluded) == 0 {
+		return nil, nil, nil
+	}
+	return nil, excluded, nil
+}
+
+// Publish publishes the event of the channel to the members that can read it,
+// the event is dropped when they cannot be resolved
+func Publish(bus event.Bus, repoServer server.Repository, srv *server.Server, ch *channel.Channel, evt event.Event) {
+	audience, excluded, err := Audience(repoServer, srv, ch)
+	if err != nil {
+		log.Printf("failed to resolve the audience of %s: %v", evt.Type, err)
+		return
+	}
+	evt.Audience = audience
+	evt.Excluded = excluded
+
+	if err := bus.Publish(evt); err != nil {
+		log.Printf("failed to publish %s: %v", evt.Type, err)
+	}
+}
@@ -0,0 +1,88 @@
+package message
+
+import (
+	"harmony/internal/database"
+	"harmony/modules/channel"
+	"harmony/modules/server"
+	"slices"
+	"testing"
+)
+
+func TestAudience(t *testing.T) {
+	repo := server.NewMemoryRepository(database.NewMemory())
+
+	srv := server.NewServer("audience", "", "owner")
+	if err := repo.Create(&srv); err != nil {
+		t.Fatalf("create server: %v", err)
+	}
+	staff := server.NewRole("staff", 0, 0)
+	srv.Roles = append(srv.Roles, staff)
+	if err := repo.Update(&srv); err != nil {
+		t.Fatalf("update server: %v", err)
+	}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := repo.AddMember(&srv, user, server.RoleMember); err != nil {
+			t.Fatalf("add member: %v", err)
+		}
+	}
+	if err := repo.SetRoles(&srv, "bob", []string{staff.ID.Hex()}); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+
+	everyone := srv.ID.Hex()
+	read := server.PermissionReadMessages
+
+	tests := []struct {
+		name       string
+		overwrites []channel.Overwrite
+		audience   []string
+		excluded   []string
+	}{
+		{
+			name: "public",
+		},
+		{
+			name: "denied to a role",
+			overwrites: []channel.Overwrite{
+				{ID: staff.ID.Hex(), Type: channel.OverwriteRole, Deny: read},
+			},
+			excluded: []string{"bob"},
+		},
+		{
+			name: "denied to a member allowed back by role",
+			overwrites: []channel.Overwrite{
+				{ID: staff.ID.Hex(), Type: channel.OverwriteRole, Allow: read},
+				{ID: "carol", Type: channel.OverwriteMember, Deny: read},
+			},
+			excluded: []string{"carol"},
+		},
+		{
+			name: "private",
+			overwrites: []channel.Overwrite{
+				{ID: everyone, Type: channel.OverwriteRole, Deny: read},
+				{ID: staff.ID.Hex(), Type: channel.OverwriteRole, Allow: read},
+				{ID: "carol", Type: channel.OverwriteMember, Allow: read},
+			},
+			audience: []string{"owner", "bob", "carol"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := channel.Channel{ServerID: srv.ID, Overwrites: tt.overwrites}
+
+			audience, excluded, err := Audience(repo, &srv, &ch)
+			if err != nil {
+				t.Fatalf("audience: %v", err)
+			}
+			slices.Sort(audience)
+			slices.Sort(tt.audience)
+			if !slices.Equal(audience, tt.audience) || (audience == nil) != (tt.audience == nil) {
+				t.Errorf("expected audience %v, got %v", tt.audience, audience)
+			}
+			if !slices.Equal(excluded, tt.excluded) || (excluded == nil) != (tt.excluded == nil) {
+				t.Errorf("expected excluded %v, got %v", tt.excluded, excluded)
+			}
+		})
+	}
+}
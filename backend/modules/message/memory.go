@@ -0,0 +1,122 @@
+package message
+
+import (
+	"bytes"
+	"harmony/internal/database"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository keeps the messages in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(message *Message) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		message.CreatedAt = time.Now()
+		id, err := tx.Insert("messages", message)
+		if err != nil {
+			return err
+		}
+		message.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Message, error) {
+	var message Message
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("messages", bson.M{"_id": id}, &message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+func (r *MemoryRepository) ReadByChannel(channelId primitive.ObjectID, page Page) ([]Message, error) {
+	if page.Limit <= 0 || page.Limit > MaxLimit {
+		page.Limit = DefaultLimit
+	}
+
+	// oldest first
+	var messages []Message
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("messages", bson.M{"channel_id": channelId}, &messages)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	older := func(cursor primitive.ObjectID, inclusive bool, limit int) []Message {
+		result := []Message{}
+		for i := len(messages) - 1; i >= 0 && len(result) < limit; i-- {
+			cmp := bytes.Compare(messages[i].ID[:], cursor[:])
+			if cmp < 0 || (inclusive && cmp == 0) || cursor.IsZero() {
+				result = append(result, messages[i])
+			}
+		}
+		return result
+	}
+	newer := func(cursor primitive.ObjectID, limit int) []Message {
+		result := []Message{}
+		for i := 0; i < len(messages) && len(result) < limit; i++ {
+			if bytes.Compare(messages[i].ID[:], cursor[:]) > 0 {
+				result = append(result, messages[i])
+			}
+		}
+		slices.Reverse(result)
+		return result
+	}
+
+	switch {
+	case !page.Around.IsZero():
+		return append(newer(page.Around, page.Limit/2), older(page.Around, true, (page.Limit+1)/2)...), nil
+	case !page.After.IsZero():
+		return newer(page.After, page.Limit), nil
+	case !page.Before.IsZero():
+		return older(page.Before, false, page.Limit), nil
+	default:
+		return older(primitive.NilObjectID, false, page.Limit), nil
+	}
+}
+
+func (r *MemoryRepository) Update(message *Message) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		editedAt := time.Now()
+
+		var stored Message
+		_, err := tx.Modify("messages", message.ID, &stored, func() {
+			stored.Content = message.Content
+			stored.EditedAt = &editedAt
+		})
+		if err != nil {
+			return err
+		}
+		message.EditedAt = &editedAt
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Delete(id primitive.ObjectID) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		deleted = tx.Delete("messages", bson.M{"_id": id}) > 0
+		return nil
+	})
+
+	return deleted, err
+}
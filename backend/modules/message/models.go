@@ -0,0 +1,93 @@
+package message
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 100
+)
+
+type Message struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID  primitive.ObjectID `bson:"server_id"`
+	ChannelID primitive.ObjectID `bson:"channel_id"`
+	AuthorID  string             `bson:"author_id"`
+	Content   string             `bson:"content"`
+	CreatedAt time.Time          `bson:"created_at"`
+	EditedAt  *time.Time         `bson:"edited_at,omitempty"`
+	// messages posted through an incoming webhook have no author, they carry the webhook and how it presents itself
+	WebhookID *primitive.ObjectID `bson:"webhook_id,omitempty"`
+	Username  string              `bson:"username,omitempty"`
+	AvatarURL string              `bson:"avatar_url,omitempty"`
+	Embeds    []Embed             `bson:"embeds,omitempty"`
+	// InteractionID is set on the answers of a bot to a command
+	InteractionID *primitive.ObjectID `bson:"interaction_id,omitempty"`
+}
+
+func NewMessage(serverId primitive.ObjectID, channelId primitive.ObjectID, authorId string, content string) Message {
+	return Message{
+		ServerID:  serverId,
+		ChannelID: channelId,
+		AuthorID:  authorId,
+		Content:   content,
+	}
+}
+
+// NewWebhookMessage creates a message posted by an incoming webhook
+func NewWebhookMessage(serverId primitive.ObjectID, channelId primitive.ObjectID, webhookId primitive.ObjectID, username string, avatarUrl string, content string, embeds []Embed) Message {
+	return Message{
+		ServerID:  serverId,
+		ChannelID: channelId,
+		Content:   content,
+		WebhookID: &webhookId,
+		Username:  username,
+		AvatarURL: avatarUrl,
+		Embeds:    embeds,
+	}
+}
+
+func IsContentValid(content string) (bool, string) {
+	if len(content) == 0 {
+		return false, "Content is empty"
+	}
+	if len(content) > 4000 {
+		return false, "Content is too long"
+	}
+	return true, ""
+}
+
+func (m *Message) Print() map[string]any {
+	print := map[string]any{
+		"id":         m.ID,
+		"server_id":  m.ServerID,
+		"channel_id": m.ChannelID,
+		"author_id":  m.AuthorID,
+		"content":    m.Content,
+		"created_at": m.CreatedAt,
+		"edited_at":  m.EditedAt,
+	}
+	if m.WebhookID != nil {
+		print["webhook_id"] = m.WebhookID
+		print["username"] = m.Username
+		print["avatar_url"] = m.AvatarURL
+	}
+	if len(m.Embeds) > 0 {
+		print["embeds"] = m.Embeds
+	}
+	if m.InteractionID != nil {
+		print["interaction_id"] = m.InteractionID
+	}
+	return print
+}
+
+// Page describes which slice of the channel history to load, at most one cursor is set
+type Page struct {
+	Before primitive.ObjectID
+	After  primitive.ObjectID
+	Around primitive.ObjectID
+	Limit  int
+}
@@ -0,0 +1,200 @@
+package message
+
+import (
+	"context"
+	"harmony/internal/database"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Repository stores the messages of the text channels
+type Repository interface {
+	Create(message *Message) error
+	Read(id primitive.ObjectID) (*Message, error)
+	ReadByChannel(channelId primitive.ObjectID, page Page) ([]Message, error)
+	Update(message *Message) error
+	Delete(id primitive.ObjectID) (bool, error)
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+// EnsureIndexes creates the index the history pages walk, newest first by channel,
+// and the one used to remove the messages of a server
+func (r *MongoRepository) EnsureIndexes() error {
+	cMessages := r.db.Collection("messages")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cMessages, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "channel_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "server_id", Value: 1}}},
+	})
+	return err
+}
+
+func (r *MongoRepository) Create(message *Message) error {
+	cMessages := r.db.Collection("messages")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	message.CreatedAt = time.Now()
+	document := bson.M{
+		"server_id":  message.ServerID,
+		"channel_id": message.ChannelID,
+		"author_id":  message.AuthorID,
+		"content":    message.Content,
+		"created_at": message.CreatedAt,
+	}
+	if message.WebhookID != nil {
+		document["webhook_id"] = message.WebhookID
+		document["username"] = message.Username
+		document["avatar_url"] = message.AvatarURL
+	}
+	if len(message.Embeds) > 0 {
+		document["embeds"] = message.Embeds
+	}
+	if message.InteractionID != nil {
+		document["interaction_id"] = message.InteractionID
+	}
+	result, err := cMessages.InsertOne(ctx, document)
+	if err != nil {
+		return err
+	}
+
+	message.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Message, error) {
+	cMessages := r.db.Collection("messages")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var message Message
+	err := cMessages.FindOne(ctx, bson.M{"_id": id}).Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// ReadByChannel returns a page of the channel history sorted from the newest to the oldest message
+func (r *MongoRepository) ReadByChannel(channelId primitive.ObjectID, page Page) ([]Message, error) {
+	if page.Limit <= 0 || page.Limit > MaxLimit {
+		page.Limit = DefaultLimit
+	}
+
+	switch {
+	case !page.Around.IsZero():
+		// half of the page before the cursor (cursor included), half after
+		before, err := r.find(channelId, bson.M{"$lte": page.Around}, -1, (page.Limit+1)/2)
+		if err != nil {
+			return nil, err
+		}
+		after, err := r.find(channelId, bson.M{"$gt": page.Around}, 1, page.Limit/2)
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(after)
+		return append(after, before...), nil
+	case !page.After.IsZero():
+		messages, err := r.find(channelId, bson.M{"$gt": page.After}, 1, page.Limit)
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(messages)
+		return messages, nil
+	case !page.Before.IsZero():
+		return r.find(channelId, bson.M{"$lt": page.Before}, -1, page.Limit)
+	default:
+		return r.find(channelId, nil, -1, page.Limit)
+	}
+}
+
+func (r *MongoRepository) find(channelId primitive.ObjectID, idFilter bson.M, sort int, limit int) ([]Message, error) {
+	cMessages := r.db.Collection("messages")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{"channel_id": channelId}
+	if idFilter != nil {
+		filter["_id"] = idFilter
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: sort}}).SetLimit(int64(limit))
+	cursor, err := cMessages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *MongoRepository) Update(message *Message) error {
+	cMessages := r.db.Collection("messages")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// cannot update:
+	// - channel
+	// - author
+	// - created at
+	editedAt := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"content":   message.Content,
+			"edited_at": editedAt,
+		},
+	}
+	_, err := cMessages.UpdateByID(ctx, message.ID, update)
+	if err != nil {
+		return err
+	}
+	message.EditedAt = &editedAt
+
+	return nil
+}
+
+func (r *MongoRepository) Delete(id primitive.ObjectID) (bool, error) {
+	cMessages := r.db.Collection("messages")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cMessages.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
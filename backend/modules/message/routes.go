@@ -0,0 +1,12 @@
+package message
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/", h.Create)
+	r.GET("/", h.List)
+	r.PATCH("/:messageId", h.Update)
+	r.DELETE("/:messageId", h.Delete)
+}
@@ -1,27 +1,32 @@
 package server
 
 import (
-	"fmt"
-	"harmony/internal/database"
+	"errors"
+	"harmony/internal/event"
 	"harmony/modules/user"
 	"harmony/utils"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Handler struct {
-	Repo     *Repository
-	RepoUser *user.Repository
+	Repo       Repository
+	RepoUser   user.Repository
+	Membership *Membership
+	Bus        event.Bus
 }
 
-func NewHandler(db *database.Service) *Handler {
+func NewHandler(repo Repository, repoUser user.Repository, membership *Membership, bus event.Bus) *Handler {
 	return &Handler{
-		Repo:     NewRepository(db.Mongo),
-		RepoUser: user.NewRepository(db.Mongo),
+		Repo:       repo,
+		RepoUser:   repoUser,
+		Membership: membership,
+		Bus:        bus,
 	}
 }
 
@@ -57,23 +62,14 @@ func (h *Handler) Create(c *gin.Context) {
 	// create
 	server := NewServer(rb.Name, rb.Image, user.UniqueName)
 
+	// the owner is also added to the members
 	err = h.Repo.Create(&server)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create server"})
+	if errors.Is(err, ErrUniqueNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Name already taken, try again"})
 		return
 	}
-
-	// insert in user the new server created
-	if user.Servers == nil {
-		user.Servers = map[string]string{
-			server.UniqueName: "owner",
-		}
-	} else {
-		user.Servers[server.UniqueName] = "owner"
-	}
-	err = h.RepoUser.Update(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create server"})
 		return
 	}
 
@@ -124,14 +120,19 @@ func (h *Handler) Update(c *gin.Context) {
 	}
 
 	// search server
-	server, err := h.Repo.Read(objectId)
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	server, member, err := h.Membership.Member(objectId, sub)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
 		return
 	}
 
-	// role check
-	if utils.IsRoleAtLeastAdmin(c, server.UniqueName) {
+	// permission check
+	if !server.Permissions(member).Has(PermissionManageServer) {
 		c.Status(http.StatusUnauthorized)
 		return
 	}
@@ -145,6 +146,13 @@ func (h *Handler) Update(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
 		return
 	}
+	h.Membership.Invalidate(server.ID)
+
+	h.publish(event.Event{
+		Type:     event.ServerUpdated,
+		ServerID: server.ID.Hex(),
+		Data:     server.Print(),
+	})
 
 	c.JSON(http.StatusOK, server.Print())
 }
@@ -159,14 +167,15 @@ func (h *Handler) Delete(c *gin.Context) {
 		return
 	}
 
-	server, err := h.Repo.Read(objectId)
+	server, err := h.Membership.Server(objectId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive server"})
 		return
 	}
 
-	// role check
-	if utils.IsRoleAdmin(c, server.UniqueName) {
+	// only the owner can delete
+	sub, ok := utils.GetSub(c)
+	if !ok || sub != server.OwnerID {
 		c.Status(http.StatusUnauthorized)
 		return
 	}
@@ -181,13 +190,26 @@ func (h *Handler) Delete(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
 		return
 	}
+	h.Membership.Invalidate(server.ID)
+
+	h.publish(event.Event{
+		Type:     event.ServerDeleted,
+		ServerID: server.ID.Hex(),
+		Data:     gin.H{"id": server.ID},
+	})
 
 	c.Status(http.StatusNoContent)
 }
 
-func (h *Handler) Invite(c *gin.Context) {
+func (h *Handler) Leave(c *gin.Context) {
 	id := c.Param("id")
 
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
 	// validate input
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -196,29 +218,163 @@ func (h *Handler) Invite(c *gin.Context) {
 	}
 
 	// search server
-	server, err := h.Repo.Read(objectId)
+	server, member, err := h.Membership.Member(objectId, sub)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
 		return
 	}
 
-	// role check
-	if utils.IsRoleAtLeastMember(c, server.UniqueName) {
+	// search user
+	user, err := h.RepoUser.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	if user.UniqueName == server.OwnerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner cannot leave server"})
+		return
+	}
+
+	// check already in the list
+	if member == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not in the server"})
+		return
+	}
+
+	err = h.Repo.RemoveMember(server, user.UniqueName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
+		return
+	}
+	h.Membership.Invalidate(server.ID)
+
+	h.publish(event.Event{
+		Type:     event.MemberLeft,
+		ServerID: server.ID.Hex(),
+		UserID:   user.UniqueName,
+		Data:     gin.H{"server_id": server.ID, "user_id": user.UniqueName},
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListRoles(c *gin.Context) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	server, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// membership check
+	if member == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	c.JSON(http.StatusOK, server.printRoles())
+}
+
+func (h *Handler) CreateRole(c *gin.Context) {
+	id := c.Param("id")
+	type RequestBody struct {
+		Name        string     `json:"name"`
+		Color       int        `json:"color"`
+		Permissions Permission `json:"permissions"`
+	}
+	var rb RequestBody
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsRoleNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsColorValid(rb.Color); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsPermissionValid(rb.Permissions); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search server
+	server, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// permission check, nobody can grant what they do not have
+	permissions := server.Permissions(member)
+	if !permissions.Has(PermissionManageRoles) || !permissions.Has(rb.Permissions) {
 		c.Status(http.StatusUnauthorized)
 		return
 	}
 
-	// compose url
-	url := fmt.Sprintf("%s/servers/%s/join?t=%d", utils.GetFullHost(c), server.ID.Hex(), time.Now().Add(5*time.Minute).UnixMilli())
+	// new roles start right above the one every member has
+	role := NewRole(rb.Name, rb.Color, rb.Permissions)
+	server.Roles = append(server.Roles, role)
+	server.MoveRole(role.ID, 1)
+
+	err = h.Repo.Update(server)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
+		return
+	}
+	h.Membership.Invalidate(server.ID)
 
-	c.JSON(http.StatusOK, gin.H{
-		"link": url,
+	h.publish(event.Event{
+		Type:     event.ServerUpdated,
+		ServerID: server.ID.Hex(),
+		Data:     server.Print(),
 	})
+
+	created, _ := server.Role(role.ID)
+	c.JSON(http.StatusCreated, created.Print())
 }
 
-func (h *Handler) Join(c *gin.Context) {
+func (h *Handler) UpdateRole(c *gin.Context) {
 	id := c.Param("id")
-	tOriginale := c.DefaultQuery("t", "")
+	roleId := c.Param("roleId")
+	type RequestBody struct {
+		Name        string     `json:"name"`
+		Color       int        `json:"color"`
+		Permissions Permission `json:"permissions"`
+		Position    *int       `json:"position"`
+	}
+	var rb RequestBody
 
 	sub, ok := utils.GetSub(c)
 	if !ok {
@@ -232,65 +388,162 @@ func (h *Handler) Join(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	t, err := strconv.ParseInt(tOriginale, 10, 64)
-	if tOriginale == "" || err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to resolve t"})
+	roleObjectId, err := primitive.ObjectIDFromHex(roleId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsRoleNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 		return
 	}
-	if time.Now().UnixMilli() > t {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Join invite expired"})
+	if check, errMsg := IsColorValid(rb.Color); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsPermissionValid(rb.Permissions); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 		return
 	}
 
 	// search server
-	server, err := h.Repo.Read(objectId)
+	server, member, err := h.Membership.Member(objectId, sub)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
 		return
 	}
 
-	// search user
-	user, err := h.RepoUser.ReadByUniqueName(sub)
+	// search role
+	role, exists := server.Role(roleObjectId)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive role"})
+		return
+	}
+
+	// permission check, only roles ranked below can be edited
+	permissions := server.Permissions(member)
+	position := server.Position(member)
+	if !permissions.Has(PermissionManageRoles) || !permissions.Has(rb.Permissions) || role.Position >= position {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	if rb.Position != nil && *rb.Position >= position {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// update data, the role every member has keeps its name and position
+	if role.ID != server.ID {
+		role.Name = rb.Name
+	}
+	role.Color = rb.Color
+	role.Permissions = rb.Permissions
+	if rb.Position != nil {
+		server.MoveRole(role.ID, *rb.Position)
+	}
+
+	err = h.Repo.Update(server)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
 		return
 	}
+	h.Membership.Invalidate(server.ID)
 
-	// check already in the list
-	_, existsInServer := server.Users[user.UniqueName]
+	h.publish(event.Event{
+		Type:     event.ServerUpdated,
+		ServerID: server.ID.Hex(),
+		Data:     server.Print(),
+	})
+
+	updated, _ := server.Role(roleObjectId)
+	c.JSON(http.StatusOK, updated.Print())
+}
 
-	if existsInServer {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User already joined server"})
+func (h *Handler) DeleteRole(c *gin.Context) {
+	id := c.Param("id")
+	roleId := c.Param("roleId")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
 		return
 	}
 
-	// todo: need to take user from access token
-	server.Users[user.UniqueName] = "member"
-	if user.Servers == nil {
-		user.Servers = map[string]string{
-			server.UniqueName: "member",
-		}
-	} else {
-		user.Servers[server.UniqueName] = "owner"
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	roleObjectId, err := primitive.ObjectIDFromHex(roleId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search server
+	server, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return
+	}
+
+	// search role
+	role, exists := server.Role(roleObjectId)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive role"})
+		return
+	}
+	if role.ID == server.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role cannot be deleted"})
+		return
+	}
+
+	// permission check, only roles ranked below can be deleted
+	if !server.Permissions(member).Has(PermissionManageRoles) || role.Position >= server.Position(member) {
+		c.Status(http.StatusUnauthorized)
+		return
 	}
 
+	server.Roles = slices.DeleteFunc(server.Roles, func(r Role) bool {
+		return r.ID == roleObjectId
+	})
+	server.sortRoles()
+
 	err = h.Repo.Update(server)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
 		return
 	}
 
-	err = h.RepoUser.Update(user)
+	// members with the role fall back to plain members
+	err = h.Repo.RemoveRole(server, roleObjectId.Hex())
+	h.Membership.Invalidate(server.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update members"})
 		return
 	}
 
+	h.publish(event.Event{
+		Type:     event.ServerUpdated,
+		ServerID: server.ID.Hex(),
+		Data:     server.Print(),
+	})
+
 	c.Status(http.StatusNoContent)
 }
 
-func (h *Handler) Leave(c *gin.Context) {
+func (h *Handler) AssignRole(c *gin.Context) {
 	id := c.Param("id")
+	userId := c.Param("userId")
+	type RequestBody struct {
+		Role string `json:"role"`
+	}
+	var rb RequestBody
 
 	sub, ok := utils.GetSub(c)
 	if !ok {
@@ -304,49 +557,187 @@ func (h *Handler) Leave(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// search server
-	server, err := h.Repo.Read(objectId)
+	server, member, err := h.Membership.Member(objectId, sub)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
 		return
 	}
 
-	// search user
-	user, err := h.RepoUser.ReadByUniqueName(sub)
+	// check role and member
+	if rb.Role != RoleAdmin && rb.Role != RoleMember {
+		roleObjectId, err := primitive.ObjectIDFromHex(rb.Role)
+		if _, exists := server.Role(roleObjectId); err != nil || !exists || roleObjectId == server.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive role"})
+			return
+		}
+	}
+	_, target, err := h.Membership.Member(server.ID, userId)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive member"})
+		return
+	}
+	if target == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive member"})
+		return
+	}
+	if userId == server.OwnerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner role cannot be changed"})
 		return
 	}
 
-	if user.UniqueName == server.OwnerID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Owner cannot leave server"})
+	// permission check, both the member and the role must be ranked below
+	position := server.Position(member)
+	if !server.Permissions(member).Has(PermissionManageRoles) || server.Position(target) >= position || server.RolePosition(rb.Role) >= position {
+		c.Status(http.StatusUnauthorized)
 		return
 	}
 
-	// check already in the list
-	_, existsInServer := server.Users[user.UniqueName]
+	// update data
+	err = h.Repo.SetRoles(server, userId, []string{rb.Role})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+	h.Membership.Invalidate(server.ID)
 
-	if !existsInServer {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User not in the server"})
+	h.publish(event.Event{
+		Type:     event.ServerUpdated,
+		ServerID: server.ID.Hex(),
+		Data:     server.Print(),
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListMembers(c *gin.Context) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
 		return
 	}
 
-	// todo: need to take user from access token
-	delete(server.Users, user.UniqueName)
-	delete(user.Servers, server.UniqueName)
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
 
-	err = h.Repo.Update(server)
+	// search server
+	server, member, err := h.Membership.Member(objectId, sub)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
 		return
 	}
 
-	err = h.RepoUser.Update(user)
+	// membership check
+	if member == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// search members, the cursor is the unique name of the last member of the previous page
+	members, err := h.Repo.ReadMembers(server.ID, c.Query("after"), limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive members"})
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	result := make([]map[string]any, 0, len(members))
+	for _, member := range members {
+		result = append(result, member.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListServers returns the servers of the user logged in, each with its membership
+func (h *Handler) ListServers(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input, the cursor is the id of the last server of the previous page
+	var after primitive.ObjectID
+	if value := c.Query("after"); value != "" {
+		objectId, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to resolve after"})
+			return
+		}
+		after = objectId
+	}
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	// search memberships
+	members, err := h.Repo.ReadMemberships(sub, after, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive members"})
+		return
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.ServerID)
+	}
+	servers, err := h.Repo.ReadByIDs(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive servers"})
+		return
+	}
+	byId := make(map[primitive.ObjectID]Server, len(servers))
+	for _, server := range servers {
+		byId[server.ID] = server
+	}
+
+	result := make([]map[string]any, 0, len(members))
+	for _, member := range members {
+		server, exists := byId[member.ServerID]
+		if !exists {
+			continue
+		}
+		printed := server.Print()
+		printed["member"] = member.Print()
+		result = append(result, printed)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// parseLimit reads the page size, it writes the error response when it is not valid
+func parseLimit(c *gin.Context) (int, bool) {
+	limit := c.Query("limit")
+	if limit == "" {
+		return DefaultMemberLimit, true
+	}
+
+	l, err := strconv.Atoi(limit)
+	if err != nil || l < 1 || l > MaxMemberLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to resolve limit"})
+		return 0, false
+	}
+	return l, true
+}
+
+func (h *Handler) publish(evt event.Event) {
+	if err := h.Bus.Publish(evt); err != nil {
+		log.Printf("failed to publish %s: %v", evt.Type, err)
+	}
 }
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultMemberLimit = 100
+	MaxMemberLimit     = 1000
+)
+
+// Member is the membership of a user in a server, there is one per server and user
+type Member struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID primitive.ObjectID `bson:"server_id"`
+	UserID   string             `bson:"user_id"`
+	// Roles holds the built-in role of the member and the ids of the roles created in the server
+	Roles      []string   `bson:"roles"`
+	Nickname   string     `bson:"nickname"`
+	JoinedAt   time.Time  `bson:"joined_at"`
+	MutedUntil *time.Time `bson:"muted_until,omitempty"`
+}
+
+func NewMember(serverId primitive.ObjectID, userId string, role string) Member {
+	return Member{
+		ServerID: serverId,
+		UserID:   userId,
+		Roles:    []string{role},
+		JoinedAt: time.Now(),
+	}
+}
+
+// IsMuted tells if the member is muted right now
+func (m *Member) IsMuted() bool {
+	return m.MutedUntil != nil && time.Now().Before(*m.MutedUntil)
+}
+
+func (m *Member) Print() map[string]any {
+	return map[string]any{
+		"server_id":   m.ServerID,
+		"user_id":     m.UserID,
+		"roles":       m.Roles,
+		"nickname":    m.Nickname,
+		"joined_at":   m.JoinedAt,
+		"muted_until": m.MutedUntil,
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"harmony/internal/event"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type cachedServer struct {
+	// generation is the one of the server when it was read
+	generation uint64
+	server     *Server
+	// members holds the members resolved so far by unique name, nil when the user is not a member
+	members   map[string]*Member
+	expiresAt time.Time
+}
+
+// Membership resolves the current roles and permissions of a user from the servers and their members,
+// the servers and the members resolved can be cached for a short time and are dropped as soon as they change.
+// Only the members asked for are read, never all the members of a server
+type Membership struct {
+	Repo Repository
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	cache map[primitive.ObjectID]*cachedServer
+	// generations count the invalidations of each server, what was read before one is not cached
+	generations map[primitive.ObjectID]uint64
+}
+
+// NewMembership creates the service, a zero ttl disables the cache
+func NewMembership(repo Repository, ttl time.Duration) *Membership {
+	return &Membership{
+		Repo:        repo,
+		ttl:         ttl,
+		cache:       map[primitive.ObjectID]*cachedServer{},
+		generations: map[primitive.ObjectID]uint64{},
+	}
+}
+
+// Server returns a copy of the server safe to be read by the caller
+func (m *Membership) Server(id primitive.ObjectID) (*Server, error) {
+	var generation uint64
+	if m.ttl > 0 {
+		m.mu.Lock()
+		cached, exists := m.cache[id]
+		generation = m.generations[id]
+		m.mu.Unlock()
+		if exists && time.Now().Before(cached.expiresAt) {
+			return cached.server.clone(), nil
+		}
+	}
+
+	server, err := m.Repo.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.ttl > 0 {
+		m.mu.Lock()
+		if m.generations[id] == generation {
+			m.cache[id] = &cachedServer{
+				generation: generation,
+				server:     server.clone(),
+				members:    map[string]*Member{},
+				expiresAt:  time.Now().Add(m.ttl),
+			}
+		}
+		m.mu.Unlock()
+	}
+
+	return server, nil
+}
+
+// Member returns a copy of the server and the member the user is in it, the member is nil when the user
+// is not a member
+func (m *Membership) Member(id primitive.ObjectID, userUniqueName string) (*Server, *Member, error) {
+	server, err := m.Server(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var generation uint64
+	if m.ttl > 0 {
+		m.mu.Lock()
+		cached, exists := m.cache[id]
+		generation = m.generations[id]
+		var member *Member
+		if exists {
+			member, exists = cached.members[userUniqueName]
+		}
+		m.mu.Unlock()
+		if exists {
+			return server, member.clone(), nil
+		}
+	}
+
+	member, err := findMember(m.Repo, id, userUniqueName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if m.ttl > 0 {
+		m.mu.Lock()
+		if cached, exists := m.cache[id]; exists && cached.generation == generation && m.generations[id] == generation {
+			cached.members[userUniqueName] = member.clone()
+		}
+		m.mu.Unlock()
+	}
+
+	return server, member, nil
+}
+
+// findMember reads the member the user is in the server, nil when the user is not a member
+func findMember(repo Repository, serverId primitive.ObjectID, userUniqueName string) (*Member, error) {
+	member, err := repo.ReadMember(serverId, userUniqueName)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return member, err
+}
+
+// Permissions returns what the user is currently allowed to do in the server
+func (m *Membership) Permissions(id primitive.ObjectID, userUniqueName string) (Permission, error) {
+	server, member, err := m.Member(id, userUniqueName)
+	if err != nil {
+		return 0, err
+	}
+	return server.Permissions(member), nil
+}
+
+// Invalidate drops the cached server and its members, to be called whenever they are changed
+func (m *Membership) Invalidate(id primitive.ObjectID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.cache, id)
+	m.generations[id]++
+}
+
+// HandleEvent drops the servers changed by the other api instances
+func (m *Membership) HandleEvent(evt event.Event) {
+	switch evt.Type {
+	case event.ServerUpdated, event.ServerDeleted, event.MemberJoined, event.MemberLeft:
+		id, err := primitive.ObjectIDFromHex(evt.ServerID)
+		if err != nil {
+			return
+		}
+		m.Invalidate(id)
+	}
+}
+
+func (s *Server) clone() *Server {
+	clone := *s
+	clone.Roles = slices.Clone(s.Roles)
+	return &clone
+}
+
+func (m *Member) clone() *Member {
+	if m == nil {
+		return nil
+	}
+	clone := *m
+	clone.Roles = slices.Clone(m.Roles)
+	return &clone
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"harmony/internal/database"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// racingRepository changes the server while a read is in flight, like another request would
+type racingRepository struct {
+	*MemoryRepository
+	onRead       func()
+	onReadMember func()
+}
+
+func (r *racingRepository) Read(id primitive.ObjectID) (*Server, error) {
+	server, err := r.MemoryRepository.Read(id)
+	if r.onRead != nil {
+		r.onRead()
+		r.onRead = nil
+	}
+	return server, err
+}
+
+func (r *racingRepository) ReadMember(serverId primitive.ObjectID, userUniqueName string) (*Member, error) {
+	member, err := r.MemoryRepository.ReadMember(serverId, userUniqueName)
+	if r.onReadMember != nil {
+		r.onReadMember()
+		r.onReadMember = nil
+	}
+	return member, err
+}
+
+func TestMembershipStaleRead(t *testing.T) {
+	repo := &racingRepository{MemoryRepository: NewMemoryRepository(database.NewMemory())}
+	membership := NewMembership(repo, time.Minute)
+
+	server := &Server{Name: "guild", OwnerID: "owner#0001"}
+	if err := repo.Create(server); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server is renamed while it is read
+	repo.onRead = func() {
+		server.Name = "renamed"
+		if err := repo.Update(server); err != nil {
+			t.Fatal(err)
+		}
+		membership.Invalidate(server.ID)
+	}
+	if _, err := membership.Server(server.ID); err != nil {
+		t.Fatal(err)
+	}
+	current, err := membership.Server(server.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Name != "renamed" {
+		t.Errorf("expected the renamed server, got %q", current.Name)
+	}
+
+	// the user joins while the membership is read
+	repo.onReadMember = func() {
+		if err := repo.AddMember(server, "user#0001", ""); err != nil {
+			t.Fatal(err)
+		}
+		membership.Invalidate(server.ID)
+	}
+	if _, member, err := membership.Member(server.ID, "user#0001"); err != nil || member != nil {
+		t.Fatalf("expected no member before the join, got %v %v", member, err)
+	}
+	_, member, err := membership.Member(server.ID, "user#0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member == nil {
+		t.Error("expected the joined member, got the one cached before the join")
+	}
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"errors"
+	"harmony/internal/database"
+	"harmony/modules/user"
+	"harmony/utils"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRepository keeps the servers in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	mem.Unique("servers", "unique_name")
+	mem.Unique("server_codes", "name")
+	mem.Unique("members", "server_id", "user_id")
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(server *Server) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var serverCode ServerCode
+		err := tx.FindOne("server_codes", bson.M{"name": server.Name}, &serverCode)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		newCode := utils.GetRandomCode(serverCode.Codes)
+		if serverCode.ID.IsZero() {
+			_, err = tx.Insert("server_codes", ServerCode{Name: server.Name, Codes: []int{newCode}})
+		} else {
+			serverCode.Codes = append(serverCode.Codes, newCode)
+			_, err = tx.Replace("server_codes", serverCode.ID, serverCode)
+		}
+		if err != nil {
+			return err
+		}
+
+		// creates new server
+		server.GenerateUniqueName(newCode)
+
+		id := primitive.NewObjectID()
+		server.Roles = []Role{{
+			ID:          id,
+			Name:        "everyone",
+			Permissions: PermissionDefault,
+		}}
+		server.ID = id
+		if _, err := tx.Insert("servers", server); err != nil {
+			server.ID = primitive.NilObjectID
+			if mongo.IsDuplicateKeyError(err) {
+				return ErrUniqueNameTaken
+			}
+			return err
+		}
+
+		_, err = tx.Insert("members", NewMember(id, server.OwnerID, RoleOwner))
+		return err
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Server, error) {
+	var server Server
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("servers", bson.M{"_id": id}, &server)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &server, nil
+}
+
+func (r *MemoryRepository) ReadByIDs(ids []primitive.ObjectID) ([]Server, error) {
+	servers := []Server{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		for _, id := range ids {
+			var server Server
+			err := tx.FindOne("servers", bson.M{"_id": id}, &server)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			servers = append(servers, server)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return servers, nil
+}
+
+func (r *MemoryRepository) Update(server *Server) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Server
+		_, err := tx.Modify("servers", server.ID, &stored, func() {
+			stored.Name = server.Name
+			stored.Image = server.Image
+			stored.OwnerID = server.OwnerID
+			stored.Roles = server.Roles
+		})
+		return err
+	})
+}
+
+func (r *MemoryRepository) Delete(id primitive.ObjectID) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		if tx.Delete("servers", bson.M{"_id": id}) == 0 {
+			return nil
+		}
+		deleted = true
+
+		// members, channels, messages, invites, incoming webhooks, commands and interactions
+		// cannot live without their server
+		for _, collection := range []string{"members", "channels", "messages", "invites", "incoming_webhooks", "commands", "interactions"} {
+			tx.Delete(collection, bson.M{"server_id": id})
+		}
+		return nil
+	})
+
+	return deleted, err
+}
+
+func (r *MemoryRepository) ReadByMember(userUniqueName string) ([]Server, error) {
+	var members []Member
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("members", bson.M{"user_id": userUniqueName}, &members)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.ServerID)
+	}
+
+	return r.ReadByIDs(ids)
+}
+
+func (r *MemoryRepository) AddMember(server *Server, userUniqueName string, role string) error {
+	member := NewMember(server.ID, userUniqueName, role)
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		_, err := tx.Insert("members", member)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrAlreadyMember
+		}
+		return err
+	})
+}
+
+func (r *MemoryRepository) RemoveMember(server *Server, userUniqueName string) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		tx.Delete("members", bson.M{"server_id": server.ID, "user_id": userUniqueName})
+		return nil
+	})
+}
+
+func (r *MemoryRepository) SetRoles(server *Server, userUniqueName string, roles []string) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var found Member
+		err := tx.FindOne("members", bson.M{"server_id": server.ID, "user_id": userUniqueName}, &found)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var stored Member
+		_, err = tx.Modify("members", found.ID, &stored, func() {
+			stored.Roles = roles
+		})
+		return err
+	})
+}
+
+func (r *MemoryRepository) RemoveRole(server *Server, roleId string) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var members []Member
+		if err := tx.Find("members", bson.M{"server_id": server.ID}, &members); err != nil {
+			return err
+		}
+		for _, member := range members {
+			if !slices.Contains(member.Roles, roleId) {
+				continue
+			}
+			var stored Member
+			_, err := tx.Modify("members", member.ID, &stored, func() {
+				stored.Roles = withoutRole(stored.Roles, roleId)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRepository) ReadMember(serverId primitive.ObjectID, userUniqueName string) (*Member, error) {
+	var member Member
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("members", bson.M{"server_id": serverId, "user_id": userUniqueName}, &member)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+func (r *MemoryRepository) ReadMembers(serverId primitive.ObjectID, after string, limit int) ([]Member, error) {
+	var members []Member
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("members", bson.M{"server_id": serverId}, &members)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(members, func(a, b Member) int { return strings.Compare(a.UserID, b.UserID) })
+	members = slices.DeleteFunc(members, func(m Member) bool { return m.UserID <= after })
+
+	return members[:min(limit, len(members))], nil
+}
+
+func (r *MemoryRepository) ReadMembersByRoles(serverId primitive.ObjectID, roles []string, userUniqueNames []string) ([]Member, error) {
+	var members []Member
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("members", bson.M{"server_id": serverId}, &members)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members = slices.DeleteFunc(members, func(m Member) bool {
+		return !slices.Contains(userUniqueNames, m.UserID) &&
+			!slices.ContainsFunc(m.Roles, func(role string) bool { return slices.Contains(roles, role) })
+	})
+
+	return members, nil
+}
+
+func (r *MemoryRepository) CountMembers(serverId primitive.ObjectID) (int, error) {
+	var members []Member
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("members", bson.M{"server_id": serverId}, &members)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(members), nil
+}
+
+func (r *MemoryRepository) ReadMemberships(userUniqueName string, after primitive.ObjectID, limit int) ([]Member, error) {
+	var members []Member
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("members", bson.M{"user_id": userUniqueName}, &members)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(members, func(a, b Member) int { return strings.Compare(a.ServerID.Hex(), b.ServerID.Hex()) })
+	members = slices.DeleteFunc(members, func(m Member) bool { return m.ServerID.Hex() <= after.Hex() })
+
+	return members[:min(limit, len(members))], nil
+}
+
+func (r *MemoryRepository) ReconcileMembership() (int, error) {
+	removed := 0
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		var members []Member
+		if err := tx.Find("members", nil, &members); err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			var server Server
+			serverErr := tx.FindOne("servers", bson.M{"_id": member.ServerID}, &server)
+			if serverErr != nil && !errors.Is(serverErr, mongo.ErrNoDocuments) {
+				return serverErr
+			}
+			var u user.User
+			userErr := tx.FindOne("users", bson.M{"unique_name": member.UserID}, &u)
+			if userErr != nil && !errors.Is(userErr, mongo.ErrNoDocuments) {
+				return userErr
+			}
+
+			if serverErr != nil || userErr != nil {
+				removed += tx.Delete("members", bson.M{"_id": member.ID})
+			}
+		}
+		return nil
+	})
+
+	return removed, err
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrateMembers moves the memberships kept in the users map of the servers to the members collection
+// and drops the servers map of the users. It can be run again after a failure: the servers already migrated
+// have no users field and the members already there are left untouched. It returns how many servers were migrated
+func (r *MongoRepository) MigrateMembers(ctx context.Context) (int, error) {
+	cServers := r.db.Collection("servers")
+	cMembers := r.db.Collection("members")
+	cUsers := r.db.Collection("users")
+
+	opts := options.Find().SetProjection(bson.M{"users": 1})
+	cursor, err := cServers.Find(ctx, bson.M{"users": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var servers []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Users map[string]string  `bson:"users"`
+	}
+	if err := cursor.All(ctx, &servers); err != nil {
+		return 0, err
+	}
+
+	for _, server := range servers {
+		models := make([]mongo.WriteModel, 0, len(server.Users))
+		for userUniqueName, role := range server.Users {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"server_id": server.ID, "user_id": userUniqueName}).
+				SetUpdate(bson.M{"$setOnInsert": bson.M{
+					"roles":     []string{role},
+					"nickname":  "",
+					"joined_at": server.ID.Timestamp(),
+				}}).
+				SetUpsert(true))
+		}
+		if len(models) > 0 {
+			if _, err := cMembers.BulkWrite(ctx, models); err != nil {
+				return 0, err
+			}
+		}
+
+		// the map is dropped only once its members are stored
+		_, err := cServers.UpdateByID(ctx, server.ID, bson.M{"$unset": bson.M{"users": ""}})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = cUsers.UpdateMany(ctx, bson.M{"servers": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"servers": ""}})
+	if err != nil {
+		return len(servers), err
+	}
+
+	return len(servers), nil
+}
@@ -2,18 +2,106 @@ package server
 
 import (
 	"fmt"
+	"math"
 	"regexp"
+	"slices"
+	"sort"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type Permission uint64
+
+const (
+	PermissionAdministrator Permission = 1 << iota
+	PermissionManageServer
+	PermissionManageRoles
+	PermissionManageChannels
+	PermissionKickMembers
+	PermissionBanMembers
+	PermissionCreateInvite
+	PermissionReadMessages
+	PermissionSendMessages
+	PermissionManageMessages
+	PermissionConnect
+	PermissionSpeak
+	PermissionManageWebhooks
+
+	PermissionAll Permission = 1<<iota - 1
+	// PermissionDefault is granted to every member of the servers created before roles existed
+	PermissionDefault = PermissionCreateInvite | PermissionReadMessages | PermissionSendMessages | PermissionConnect | PermissionSpeak
+)
+
+// built-in roles a member can have besides the ones created in the server
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+func (p Permission) Has(permission Permission) bool {
+	return p&permission == permission
+}
+
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        string             `bson:"name"`
+	Color       int                `bson:"color"`
+	Position    int                `bson:"position"`
+	Permissions Permission         `bson:"permissions"`
+}
+
+func NewRole(name string, color int, permissions Permission) Role {
+	return Role{
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		Color:       color,
+		Permissions: permissions,
+	}
+}
+
+func IsRoleNameValid(name string) (bool, string) {
+	if len(name) == 0 {
+		return false, "Name is empty"
+	}
+	if len(name) > 100 {
+		return false, "Name is too long"
+	}
+	return true, ""
+}
+
+func IsColorValid(color int) (bool, string) {
+	if color < 0 || color > 0xFFFFFF {
+		return false, "Color must be a RGB value"
+	}
+	return true, ""
+}
+
+func IsPermissionValid(permissions Permission) (bool, string) {
+	if permissions&^PermissionAll != 0 {
+		return false, "Permissions contain unknown bits"
+	}
+	return true, ""
+}
+
+func (r *Role) Print() map[string]any {
+	return map[string]any{
+		"id":          r.ID,
+		"name":        r.Name,
+		"color":       r.Color,
+		"position":    r.Position,
+		"permissions": r.Permissions,
+	}
+}
+
 type Server struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty"`
 	Name       string             `bson:"name"`
 	Image      string             `bson:"image"`
 	OwnerID    string             `bson:"owner_id"`
 	UniqueName string             `bson:"unique_name"`
-	Users      map[string]string  `bson:"users"`
+	// Roles always contains the role every member has, its id is the server id
+	Roles []Role `bson:"roles"`
 }
 
 func NewServer(name string, image string, ownerId string) Server {
@@ -21,9 +109,6 @@ func NewServer(name string, image string, ownerId string) Server {
 		Name:    name,
 		Image:   image,
 		OwnerID: ownerId,
-		Users: map[string]string{
-			ownerId: "owner",
-		},
 	}
 }
 
@@ -45,14 +130,173 @@ func (s *Server) GenerateUniqueName(code int) {
 	s.UniqueName = fmt.Sprintf("%s:%04d", s.Name, code)
 }
 
+// Role returns the role created in the server with the given id
+func (s *Server) Role(id primitive.ObjectID) (*Role, bool) {
+	for i := range s.Roles {
+		if s.Roles[i].ID == id {
+			return &s.Roles[i], true
+		}
+	}
+	return nil, false
+}
+
+// Permissions resolves what the member is allowed to do in the server, nothing when it is not a member
+func (s *Server) Permissions(member *Member) Permission {
+	if member == nil {
+		return 0
+	}
+	if member.UserID == s.OwnerID || slices.Contains(member.Roles, RoleOwner) {
+		return PermissionAll
+	}
+
+	permissions := PermissionDefault
+	if everyone, exists := s.Role(s.ID); exists {
+		permissions = everyone.Permissions
+	}
+
+	for _, role := range member.Roles {
+		switch role {
+		case RoleAdmin:
+			permissions |= PermissionAdministrator
+		case RoleMember:
+		default:
+			roleId, err := primitive.ObjectIDFromHex(role)
+			if err != nil {
+				break
+			}
+			if role, exists := s.Role(roleId); exists {
+				permissions |= role.Permissions
+			}
+		}
+	}
+
+	if permissions.Has(PermissionAdministrator) {
+		return PermissionAll
+	}
+	return permissions
+}
+
+// RolesWith returns the roles, as stored in the member roles, that grant the permission on their own
+// besides the one every member has
+func (s *Server) RolesWith(permission Permission) []string {
+	roles := []string{RoleOwner, RoleAdmin}
+	for _, role := range s.Roles {
+		if role.ID == s.ID {
+			continue
+		}
+		if role.Permissions.Has(permission) || role.Permissions.Has(PermissionAdministrator) {
+			roles = append(roles, role.ID.Hex())
+		}
+	}
+	return roles
+}
+
+// Position is the rank of the highest role of the member, members can only manage roles and members ranked below them
+func (s *Server) Position(member *Member) int {
+	if member == nil {
+		return -1
+	}
+	if member.UserID == s.OwnerID {
+		return math.MaxInt
+	}
+	position := 0
+	for _, role := range member.Roles {
+		position = max(position, s.RolePosition(role))
+	}
+	return position
+}
+
+// RolePosition is the rank of a role as stored in the member roles
+func (s *Server) RolePosition(assigned string) int {
+	switch assigned {
+	case RoleOwner:
+		return math.MaxInt
+	case RoleAdmin:
+		return math.MaxInt - 1
+	case RoleMember:
+		return 0
+	}
+	roleId, err := primitive.ObjectIDFromHex(assigned)
+	if err != nil {
+		return 0
+	}
+	if role, exists := s.Role(roleId); exists {
+		return role.Position
+	}
+	return 0
+}
+
+// withoutRole removes the role from the assigned ones, a member left without roles is a plain member
+func withoutRole(assigned []string, roleId string) []string {
+	roles := slices.DeleteFunc(slices.Clone(assigned), func(role string) bool { return role == roleId })
+	if len(roles) == 0 {
+		return []string{RoleMember}
+	}
+	return roles
+}
+
+// MoveRole places the role at the given position shifting the others
+func (s *Server) MoveRole(id primitive.ObjectID, position int) {
+	s.sortRoles()
+
+	from := slices.IndexFunc(s.Roles, func(r Role) bool { return r.ID == id })
+	if from == -1 || id == s.ID {
+		return
+	}
+	role := s.Roles[from]
+	roles := slices.Delete(slices.Clone(s.Roles), from, from+1)
+
+	// positions start from 1 above the role every member has
+	offset := 0
+	if len(roles) > 0 && roles[0].ID == s.ID {
+		offset = 1
+	}
+	position = max(1, min(position, len(roles)-offset+1))
+	s.Roles = slices.Insert(roles, offset+position-1, role)
+
+	s.renumberRoles()
+}
+
+// sortRoles orders the roles by position and renumbers them,
+// the role every member has always stays at the bottom
+func (s *Server) sortRoles() {
+	sort.SliceStable(s.Roles, func(i, j int) bool {
+		if s.Roles[j].ID == s.ID {
+			return false
+		}
+		return s.Roles[i].ID == s.ID || s.Roles[i].Position < s.Roles[j].Position
+	})
+	s.renumberRoles()
+}
+
+func (s *Server) renumberRoles() {
+	position := 1
+	for i := range s.Roles {
+		if s.Roles[i].ID == s.ID {
+			s.Roles[i].Position = 0
+			continue
+		}
+		s.Roles[i].Position = position
+		position++
+	}
+}
+
 func (s *Server) Print() map[string]any {
 	return map[string]any{
 		"id":          s.ID,
 		"name":        s.Name,
 		"image":       s.Image,
 		"unique_name": s.UniqueName,
-		"users":       s.Users,
+		"roles":       s.printRoles(),
+	}
+}
+
+func (s *Server) printRoles() []map[string]any {
+	roles := make([]map[string]any, 0, len(s.Roles))
+	for _, role := range s.Roles {
+		roles = append(roles, role.Print())
 	}
+	return roles
 }
 
 type ServerCode struct {
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Reconciler removes in the background the members whose server or user no longer exists,
+// e.g. when a delete failed before removing them
+type Reconciler struct {
+	Repo     Repository
+	interval time.Duration
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewReconciler creates the job, a zero interval disables it
+func NewReconciler(repo Repository, interval time.Duration) *Reconciler {
+	return &Reconciler{
+		Repo:     repo,
+		interval: interval,
+	}
+}
+
+func (r *Reconciler) Start() {
+	if r.interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.run()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (r *Reconciler) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Reconciler) run() {
+	repaired, err := r.Repo.ReconcileMembership()
+	if err != nil {
+		log.Printf("failed to reconcile membership: %v", err)
+	}
+	if repaired > 0 {
+		log.Printf("membership reconciled, %d members removed", repaired)
+	}
+}
+
+// missingValues returns the values that are not among the existing ones
+func missingValues(values []any, existing []any) []any {
+	found := map[any]bool{}
+	for _, value := range existing {
+		found[value] = true
+	}
+
+	missing := []any{}
+	for _, value := range values {
+		if !found[value] {
+			missing = append(missing, value)
+		}
+	}
+	return missing
+}
@@ -3,87 +3,175 @@ package server
 import (
 	"context"
 	"errors"
+	"harmony/internal/database"
 	"harmony/utils"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const defaultTimeout = 5 * time.Second
 
-type Repository struct {
+// reconcileTimeout bounds the scans made by ReconcileMembership
+const reconcileTimeout = time.Minute
+
+// ErrUniqueNameTaken is returned when another server created at the same time got the same unique name
+var ErrUniqueNameTaken = errors.New("unique name already taken")
+
+// ErrAlreadyMember is returned when the user is already a member of the server
+var ErrAlreadyMember = errors.New("user already member of the server")
+
+// Repository stores the servers and their members, a server is read without its members:
+// they are read one at a time, by page or by role
+type Repository interface {
+	Create(server *Server) error
+	Read(id primitive.ObjectID) (*Server, error)
+	ReadByIDs(ids []primitive.ObjectID) ([]Server, error)
+	Update(server *Server) error
+	Delete(id primitive.ObjectID) (bool, error)
+	ReadByMember(userUniqueName string) ([]Server, error)
+	AddMember(server *Server, userUniqueName string, role string) error
+	RemoveMember(server *Server, userUniqueName string) error
+	SetRoles(server *Server, userUniqueName string, roles []string) error
+	RemoveRole(server *Server, roleId string) error
+	ReadMember(serverId primitive.ObjectID, userUniqueName string) (*Member, error)
+	ReadMembers(serverId primitive.ObjectID, after string, limit int) ([]Member, error)
+	ReadMembersByRoles(serverId primitive.ObjectID, roles []string, userUniqueNames []string) ([]Member, error)
+	CountMembers(serverId primitive.ObjectID) (int, error)
+	ReadMemberships(userUniqueName string, after primitive.ObjectID, limit int) ([]Member, error)
+	ReconcileMembership() (int, error)
+}
+
+type MongoRepository struct {
 	db *mongo.Database
 }
 
-func NewRepository(db *mongo.Client) *Repository {
-	return &Repository{
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
 		db: db.Database("harmony"),
 	}
 }
 
-func (r *Repository) Create(server *Server) error {
-	cServerCodes := r.db.Collection("server_codes")
+// EnsureIndexes creates the indexes of the servers and of their members, the members are looked up
+// by server, by user and by role
+func (r *MongoRepository) EnsureIndexes() error {
 	cServers := r.db.Collection("servers")
+	cServerCodes := r.db.Collection("server_codes")
+	cMembers := r.db.Collection("members")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	var serverCode ServerCode
-	newServerName := false
-	filter := bson.M{
-		"name": server.Name,
+	err := database.EnsureIndexes(ctx, cServers, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "unique_name", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return err
 	}
-	err := cServerCodes.FindOne(ctx, filter).Decode(&serverCode)
+
+	err = database.EnsureIndexes(ctx, cServerCodes, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
 	if err != nil {
-		if !errors.Is(err, mongo.ErrNoDocuments) {
-			return err
-		}
-		newServerName = true
+		return err
 	}
 
-	newCode := utils.GetRandomCode(serverCode.Codes)
-	if newServerName {
-		_, err := cServerCodes.InsertOne(ctx, bson.M{
-			"name":  server.Name,
-			"codes": []int{newCode},
+	err = database.EnsureIndexes(ctx, cMembers, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "server_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "server_id", Value: 1}}},
+		{Keys: bson.D{{Key: "server_id", Value: 1}, {Key: "roles", Value: 1}}},
+	})
+	return err
+}
+
+// Create stores the server and its owner as first member, the code of the unique name,
+// the server and the member are written in one transaction
+func (r *MongoRepository) Create(server *Server) error {
+	cServerCodes := r.db.Collection("server_codes")
+	cServers := r.db.Collection("servers")
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.Transaction(ctx, r.db.Client(), func(ctx mongo.SessionContext) error {
+		var serverCode ServerCode
+		newServerName := false
+		filter := bson.M{
+			"name": server.Name,
+		}
+		err := cServerCodes.FindOne(ctx, filter).Decode(&serverCode)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+			newServerName = true
+		}
+
+		newCode := utils.GetRandomCode(serverCode.Codes)
+		if newServerName {
+			_, err := cServerCodes.InsertOne(ctx, bson.M{
+				"name":  server.Name,
+				"codes": []int{newCode},
+			})
+			if err != nil {
+				return err
+			}
+		} else {
+			update := bson.M{
+				"$set": bson.M{
+					"codes": append(serverCode.Codes, newCode),
+				},
+			}
+			_, err = cServerCodes.UpdateByID(ctx, serverCode.ID, update)
+			if err != nil {
+				return err
+			}
+		}
+
+		// creates new server
+		server.GenerateUniqueName(newCode)
+
+		// the id is needed upfront as it is also the id of the role every member has
+		id := primitive.NewObjectID()
+		server.Roles = []Role{{
+			ID:          id,
+			Name:        "everyone",
+			Permissions: PermissionDefault,
+		}}
+		_, err = cServers.InsertOne(ctx, bson.M{
+			"_id":         id,
+			"name":        server.Name,
+			"image":       server.Image,
+			"owner_id":    server.OwnerID,
+			"unique_name": server.UniqueName,
+			"roles":       server.Roles,
 		})
 		if err != nil {
 			return err
 		}
-	} else {
-		update := bson.M{
-			"$set": bson.M{
-				"codes": append(serverCode.Codes, newCode),
-			},
-		}
-		_, err = cServerCodes.UpdateByID(ctx, serverCode.ID, update)
+
+		owner := NewMember(id, server.OwnerID, RoleOwner)
+		_, err = cMembers.InsertOne(ctx, owner)
 		if err != nil {
 			return err
 		}
-	}
 
-	// creates new server
-	server.GenerateUniqueName(newCode)
-	server.Users = map[string]string{server.OwnerID: "owner"}
-	result, err := cServers.InsertOne(ctx, bson.M{
-		"name":        server.Name,
-		"image":       server.Image,
-		"owner_id":    server.OwnerID,
-		"unique_name": server.UniqueName,
-		"users":       server.Users,
+		server.ID = id
+
+		return nil
 	})
-	if err != nil {
-		return err
+	// the code or the unique name was taken by a server created at the same time
+	if _, ok := database.DuplicateKeyIndex(err); ok {
+		return ErrUniqueNameTaken
 	}
-
-	server.ID = result.InsertedID.(primitive.ObjectID)
-
-	return nil
+	return err
 }
 
-func (r *Repository) Read(id primitive.ObjectID) (*Server, error) {
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Server, error) {
 	cServers := r.db.Collection("servers")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -98,7 +186,27 @@ func (r *Repository) Read(id primitive.ObjectID) (*Server, error) {
 	return &server, nil
 }
 
-func (r *Repository) Update(server *Server) error {
+// ReadByIDs returns the servers
+func (r *MongoRepository) ReadByIDs(ids []primitive.ObjectID) ([]Server, error) {
+	cServers := r.db.Collection("servers")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	cursor, err := cServers.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []Server{}
+	if err := cursor.All(ctx, &servers); err != nil {
+		return nil, err
+	}
+
+	return servers, nil
+}
+
+func (r *MongoRepository) Update(server *Server) error {
 	cServers := r.db.Collection("servers")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -106,12 +214,13 @@ func (r *Repository) Update(server *Server) error {
 
 	// cannot update:
 	// - unique name
+	// - members
 	update := bson.M{
 		"$set": bson.M{
 			"name":     server.Name,
 			"image":    server.Image,
 			"owner_id": server.OwnerID,
-			"users":    server.Users,
+			"roles":    server.Roles,
 		},
 	}
 	_, err := cServers.UpdateByID(ctx, server.ID, update)
@@ -122,20 +231,281 @@ func (r *Repository) Update(server *Server) error {
 	return nil
 }
 
-func (r *Repository) Delete(id primitive.ObjectID) (bool, error) {
+// Delete removes the server with its members, channels, messages and invites in one transaction
+func (r *MongoRepository) Delete(id primitive.ObjectID) (bool, error) {
 	cServers := r.db.Collection("servers")
+	cMembers := r.db.Collection("members")
+	cChannels := r.db.Collection("channels")
+	cMessages := r.db.Collection("messages")
+	cInvites := r.db.Collection("invites")
+	cIncoming := r.db.Collection("incoming_webhooks")
+	cCommands := r.db.Collection("commands")
+	cInteractions := r.db.Collection("interactions")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	result, err := cServers.DeleteOne(ctx, bson.M{"_id": id})
+	deleted := false
+	err := database.Transaction(ctx, r.db.Client(), func(ctx mongo.SessionContext) error {
+		result, err := cServers.DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		deleted = result.DeletedCount > 0
+		if !deleted {
+			return nil
+		}
+
+		// members, channels, messages, invites, incoming webhooks, commands and interactions
+		// cannot live without their server
+		for _, c := range []*mongo.Collection{cMembers, cChannels, cMessages, cInvites, cIncoming, cCommands, cInteractions} {
+			if _, err := c.DeleteMany(ctx, bson.M{"server_id": id}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return false, err
 	}
 
-	if result.DeletedCount == 0 {
-		return false, nil
+	return deleted, nil
+}
+
+// ReadByMember returns the servers the user is member of
+func (r *MongoRepository) ReadByMember(userUniqueName string) ([]Server, error) {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	ids, err := cMembers.Distinct(ctx, "server_id", bson.M{"user_id": userUniqueName})
+	if err != nil {
+		return nil, err
+	}
+
+	serverIds := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if serverId, ok := id.(primitive.ObjectID); ok {
+			serverIds = append(serverIds, serverId)
+		}
+	}
+
+	return r.ReadByIDs(serverIds)
+}
+
+// AddMember adds the user to the server with the given role, ErrAlreadyMember is returned when it is already there
+func (r *MongoRepository) AddMember(server *Server, userUniqueName string, role string) error {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	member := NewMember(server.ID, userUniqueName, role)
+	_, err := cMembers.InsertOne(ctx, member)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyMember
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *MongoRepository) RemoveMember(server *Server, userUniqueName string) error {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cMembers.DeleteOne(ctx, bson.M{"server_id": server.ID, "user_id": userUniqueName})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetRoles replaces the roles of the member
+func (r *MongoRepository) SetRoles(server *Server, userUniqueName string, roles []string) error {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cMembers.UpdateOne(ctx, bson.M{"server_id": server.ID, "user_id": userUniqueName}, bson.M{
+		"$set": bson.M{"roles": roles},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveRole takes the role away from the members, the ones left without roles fall back to plain members
+func (r *MongoRepository) RemoveRole(server *Server, roleId string) error {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cMembers.UpdateMany(ctx, bson.M{"server_id": server.ID, "roles": roleId}, bson.M{
+		"$pull": bson.M{"roles": roleId},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cMembers.UpdateMany(ctx, bson.M{"server_id": server.ID, "roles": bson.M{"$size": 0}}, bson.M{
+		"$set": bson.M{"roles": []string{RoleMember}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *MongoRepository) ReadMember(serverId primitive.ObjectID, userUniqueName string) (*Member, error) {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var member Member
+	err := cMembers.FindOne(ctx, bson.M{"server_id": serverId, "user_id": userUniqueName}).Decode(&member)
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// ReadMembers returns a page of the members of the server ordered by user, starting after the given one
+func (r *MongoRepository) ReadMembers(serverId primitive.ObjectID, after string, limit int) ([]Member, error) {
+	filter := bson.M{"server_id": serverId}
+	if after != "" {
+		filter["user_id"] = bson.M{"$gt": after}
+	}
+	return r.findMembers(filter, "user_id", limit)
+}
+
+// ReadMembersByRoles returns the members of the server that have one of the roles or one of the unique names,
+// e.g. the ones that may read a private channel
+func (r *MongoRepository) ReadMembersByRoles(serverId primitive.ObjectID, roles []string, userUniqueNames []string) ([]Member, error) {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	// $in needs an array even when empty
+	filter := bson.M{
+		"server_id": serverId,
+		"$or": bson.A{
+			bson.M{"roles": bson.M{"$in": append([]string{}, roles...)}},
+			bson.M{"user_id": bson.M{"$in": append([]string{}, userUniqueNames...)}},
+		},
+	}
+	cursor, err := cMembers.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (r *MongoRepository) CountMembers(serverId primitive.ObjectID) (int, error) {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	count, err := cMembers.CountDocuments(ctx, bson.M{"server_id": serverId})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// ReadMemberships returns a page of the memberships of the user ordered by server, starting after the given one
+func (r *MongoRepository) ReadMemberships(userUniqueName string, after primitive.ObjectID, limit int) ([]Member, error) {
+	filter := bson.M{"user_id": userUniqueName}
+	if !after.IsZero() {
+		filter["server_id"] = bson.M{"$gt": after}
+	}
+	return r.findMembers(filter, "server_id", limit)
+}
+
+func (r *MongoRepository) findMembers(filter bson.M, sort string, limit int) ([]Member, error) {
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: sort, Value: 1}}).SetLimit(int64(limit))
+	cursor, err := cMembers.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// ReconcileMembership removes the members of the servers and of the users that no longer exist,
+// e.g. left by a cascade that failed halfway. It returns how many members were removed
+func (r *MongoRepository) ReconcileMembership() (int, error) {
+	cMembers := r.db.Collection("members")
+	cServers := r.db.Collection("servers")
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	removed := 0
+
+	serverIds, err := cMembers.Distinct(ctx, "server_id", bson.M{})
+	if err != nil {
+		return removed, err
+	}
+	existing, err := cServers.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": serverIds}})
+	if err != nil {
+		return removed, err
+	}
+	if missing := missingValues(serverIds, existing); len(missing) > 0 {
+		result, err := cMembers.DeleteMany(ctx, bson.M{"server_id": bson.M{"$in": missing}})
+		if err != nil {
+			return removed, err
+		}
+		removed += int(result.DeletedCount)
+	}
+
+	userIds, err := cMembers.Distinct(ctx, "user_id", bson.M{})
+	if err != nil {
+		return removed, err
+	}
+	existing, err = cUsers.Distinct(ctx, "unique_name", bson.M{"unique_name": bson.M{"$in": userIds}})
+	if err != nil {
+		return removed, err
+	}
+	if missing := missingValues(userIds, existing); len(missing) > 0 {
+		result, err := cMembers.DeleteMany(ctx, bson.M{"user_id": bson.M{"$in": missing}})
+		if err != nil {
+			return removed, err
+		}
+		removed += int(result.DeletedCount)
 	}
 
-	return true, nil
+	return removed, nil
 }
@@ -9,7 +9,15 @@ func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
 	r.GET("/:id", h.Read)
 	r.PATCH("/:id", h.Update)
 	r.DELETE("/:id", h.Delete)
-	r.GET("/:id/invite", h.Invite)
-	r.POST("/:id/join", h.Join)
 	r.POST("/:id/leave", h.Leave)
+	r.GET("/:id/roles", h.ListRoles)
+	r.POST("/:id/roles", h.CreateRole)
+	r.PATCH("/:id/roles/:roleId", h.UpdateRole)
+	r.DELETE("/:id/roles/:roleId", h.DeleteRole)
+	r.GET("/:id/members", h.ListMembers)
+	r.PUT("/:id/members/:userId/role", h.AssignRole)
+}
+
+func RegisterUserRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/", h.ListServers)
 }
@@ -0,0 +1,78 @@
+package session
+
+import (
+	"harmony/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Handler struct {
+	Repo        Repository
+	Revocations *Revocations
+}
+
+func NewHandler(repo Repository, revocations *Revocations) *Handler {
+	return &Handler{
+		Repo:        repo,
+		Revocations: revocations,
+	}
+}
+
+func (h *Handler) List(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+	sid, _ := utils.GetSid(c)
+
+	sessions, err := h.Repo.ReadByUser(sub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive sessions"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(sessions))
+	for _, session := range sessions {
+		print := session.Print()
+		print["current"] = session.ID.Hex() == sid
+		result = append(result, print)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) Revoke(c *gin.Context) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search session
+	session, err := h.Repo.Read(objectId)
+	if err != nil || session.UserID != sub {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive session"})
+		return
+	}
+
+	err = h.Repo.Revoke(session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	h.Revocations.Forget(session.ID.Hex())
+
+	c.Status(http.StatusNoContent)
+}
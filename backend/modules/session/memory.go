@@ -0,0 +1,181 @@
+package session
+
+import (
+	"errors"
+	"harmony/internal/database"
+	"harmony/utils"
+	"slices"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRepository keeps the sessions in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	mem.Unique("sessions", "token_hash")
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(session *Session) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		id, err := tx.Insert("sessions", session)
+		if err != nil {
+			return err
+		}
+		session.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Session, error) {
+	var session Session
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("sessions", bson.M{"_id": id}, &session)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *MemoryRepository) ReadByToken(token string) (*Session, error) {
+	var session Session
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("sessions", bson.M{"token_hash": utils.HashToken(token)}, &session)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *MemoryRepository) ReadByUser(userId string) ([]Session, error) {
+	var all []Session
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("sessions", bson.M{"user_id": userId, "revoked_at": nil}, &all)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, session := range all {
+		if session.IsActive() {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func (r *MemoryRepository) Rotate(token string, device string, ip string) (*Session, string, error) {
+	hash := utils.HashToken(token)
+	newToken := utils.GetRandomToken(TokenSize)
+	now := time.Now()
+
+	var session Session
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		err := tx.FindOne("sessions", bson.M{"token_hash": hash, "revoked_at": nil}, &session)
+		if err == nil && session.IsActive() {
+			session.TokenHash = utils.HashToken(newToken)
+			session.Device = device
+			session.IP = ip
+			session.LastUsedAt = now
+			session.ExpiresAt = now.Add(RefreshTTL)
+			session.PreviousHashes = append(session.PreviousHashes, hash)
+			if len(session.PreviousHashes) > previousHashes {
+				session.PreviousHashes = session.PreviousHashes[len(session.PreviousHashes)-previousHashes:]
+			}
+			_, err = tx.Replace("sessions", session.ID, session)
+			return err
+		}
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		// the token is not current, check whether it was rotated already
+		var sessions []Session
+		if err := tx.Find("sessions", bson.M{"revoked_at": nil}, &sessions); err != nil {
+			return err
+		}
+		for _, rotated := range sessions {
+			if !slices.Contains(rotated.PreviousHashes, hash) {
+				continue
+			}
+			rotated.RevokedAt = &now
+			if _, err := tx.Replace("sessions", rotated.ID, rotated); err != nil {
+				return err
+			}
+			return ErrTokenReused
+		}
+
+		return ErrInvalidToken
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &session, newToken, nil
+}
+
+func (r *MemoryRepository) Revoke(session *Session) error {
+	now := time.Now()
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Session
+		_, err := tx.Modify("sessions", session.ID, &stored, func() {
+			stored.RevokedAt = &now
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	session.RevokedAt = &now
+
+	return nil
+}
+
+func (r *MemoryRepository) RevokeByUser(userId string) error {
+	return r.revoke(userId, primitive.NilObjectID)
+}
+
+func (r *MemoryRepository) RevokeOthers(userId string, current primitive.ObjectID) error {
+	return r.revoke(userId, current)
+}
+
+// revoke ends the sessions of the user but the kept one
+func (r *MemoryRepository) revoke(userId string, kept primitive.ObjectID) error {
+	now := time.Now()
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var sessions []Session
+		if err := tx.Find("sessions", bson.M{"user_id": userId, "revoked_at": nil}, &sessions); err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			if session.ID == kept {
+				continue
+			}
+			session.RevokedAt = &now
+			if _, err := tx.Replace("sessions", session.ID, session); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
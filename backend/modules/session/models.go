@@ -0,0 +1,61 @@
+package session
+
+import (
+	"harmony/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// TokenSize is the number of random bytes of a refresh token
+	TokenSize = 32
+	// RefreshTTL is how long a session stays valid without being refreshed
+	RefreshTTL = 30 * 24 * time.Hour
+	// previousHashes is how many rotated tokens are kept to detect a reuse
+	previousHashes = 100
+)
+
+type Session struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	UserID         string             `bson:"user_id"`
+	TokenHash      string             `bson:"token_hash"`
+	PreviousHashes []string           `bson:"previous_hashes"`
+	Device         string             `bson:"device"`
+	IP             string             `bson:"ip"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	LastUsedAt     time.Time          `bson:"last_used_at"`
+	ExpiresAt      time.Time          `bson:"expires_at"`
+	RevokedAt      *time.Time         `bson:"revoked_at"`
+}
+
+// NewSession creates a session for the user, the returned token is the only place the refresh token is in clear
+func NewSession(userId string, device string, ip string) (Session, string) {
+	token := utils.GetRandomToken(TokenSize)
+	now := time.Now()
+	return Session{
+		UserID:         userId,
+		TokenHash:      utils.HashToken(token),
+		PreviousHashes: []string{},
+		Device:         device,
+		IP:             ip,
+		CreatedAt:      now,
+		LastUsedAt:     now,
+		ExpiresAt:      now.Add(RefreshTTL),
+	}, token
+}
+
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+func (s *Session) Print() map[string]any {
+	return map[string]any{
+		"id":           s.ID,
+		"device":       s.Device,
+		"ip":           s.IP,
+		"created_at":   s.CreatedAt,
+		"last_used_at": s.LastUsedAt,
+		"expires_at":   s.ExpiresAt,
+	}
+}
@@ -0,0 +1,247 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"harmony/internal/database"
+	"harmony/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+var (
+	ErrInvalidToken = errors.New("invalid refresh token")
+	// ErrTokenReused means an already rotated token was presented, the session is revoked as it was probably stolen
+	ErrTokenReused = errors.New("refresh token reused")
+)
+
+// Repository stores the sessions, refresh tokens are only kept hashed
+type Repository interface {
+	Create(session *Session) error
+	Read(id primitive.ObjectID) (*Session, error)
+	ReadByToken(token string) (*Session, error)
+	ReadByUser(userId string) ([]Session, error)
+	Rotate(token string, device string, ip string) (*Session, string, error)
+	Revoke(session *Session) error
+	RevokeByUser(userId string) error
+	RevokeOthers(userId string, current primitive.ObjectID) error
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+// EnsureIndexes creates the lookup indexes and lets mongo drop the expired sessions
+func (r *MongoRepository) EnsureIndexes() error {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cSessions, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "previous_hashes", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (r *MongoRepository) Create(session *Session) error {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cSessions.InsertOne(ctx, bson.M{
+		"user_id":         session.UserID,
+		"token_hash":      session.TokenHash,
+		"previous_hashes": session.PreviousHashes,
+		"device":          session.Device,
+		"ip":              session.IP,
+		"created_at":      session.CreatedAt,
+		"last_used_at":    session.LastUsedAt,
+		"expires_at":      session.ExpiresAt,
+		"revoked_at":      session.RevokedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	session.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Session, error) {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var session Session
+	err := cSessions.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *MongoRepository) ReadByToken(token string) (*Session, error) {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var session Session
+	err := cSessions.FindOne(ctx, bson.M{"token_hash": utils.HashToken(token)}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// ReadByUser returns the active sessions of the user, the most recently used first
+func (r *MongoRepository) ReadByUser(userId string) ([]Session, error) {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userId,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}})
+	cursor, err := cSessions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Rotate swaps the refresh token for a new one and returns the session with the new token.
+// The swap is conditioned on the old hash so two concurrent refreshes can't both succeed,
+// presenting a token that was already rotated revokes the session.
+func (r *MongoRepository) Rotate(token string, device string, ip string) (*Session, string, error) {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	hash := utils.HashToken(token)
+	newToken := utils.GetRandomToken(TokenSize)
+	now := time.Now()
+
+	filter := bson.M{
+		"token_hash": hash,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"token_hash":   utils.HashToken(newToken),
+			"device":       device,
+			"ip":           ip,
+			"last_used_at": now,
+			"expires_at":   now.Add(RefreshTTL),
+		},
+		"$push": bson.M{
+			"previous_hashes": bson.M{"$each": []string{hash}, "$slice": -previousHashes},
+		},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var session Session
+	err := cSessions.FindOneAndUpdate(ctx, filter, update, opts).Decode(&session)
+	if err == nil {
+		return &session, newToken, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", err
+	}
+
+	// the token is not current, check whether it was rotated already
+	result, err := cSessions.UpdateOne(ctx, bson.M{"previous_hashes": hash, "revoked_at": nil}, bson.M{
+		"$set": bson.M{"revoked_at": now},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if result.MatchedCount > 0 {
+		return nil, "", ErrTokenReused
+	}
+
+	return nil, "", ErrInvalidToken
+}
+
+func (r *MongoRepository) Revoke(session *Session) error {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	now := time.Now()
+	_, err := cSessions.UpdateByID(ctx, session.ID, bson.M{
+		"$set": bson.M{"revoked_at": now},
+	})
+	if err != nil {
+		return err
+	}
+
+	session.RevokedAt = &now
+
+	return nil
+}
+
+// RevokeByUser ends every session of the user, it is the "log out all devices"
+func (r *MongoRepository) RevokeByUser(userId string) error {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cSessions.UpdateMany(ctx, bson.M{"user_id": userId, "revoked_at": nil}, bson.M{
+		"$set": bson.M{"revoked_at": time.Now()},
+	})
+	return err
+}
+
+// RevokeOthers ends every session of the user but the one in use
+func (r *MongoRepository) RevokeOthers(userId string, current primitive.ObjectID) error {
+	cSessions := r.db.Collection("sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userId,
+		"_id":        bson.M{"$ne": current},
+		"revoked_at": nil,
+	}
+	_, err := cSessions.UpdateMany(ctx, filter, bson.M{
+		"$set": bson.M{"revoked_at": time.Now()},
+	})
+	return err
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type cachedState struct {
+	userId    string
+	active    bool
+	expiresAt time.Time
+}
+
+// Revocations tells whether the session an access token was signed for is still active. The answers
+// are cached for a short time so the sessions are not read on every request, a session revoked on
+// another instance stops authenticating once the ttl elapsed. A zero ttl disables the cache
+type Revocations struct {
+	Repo Repository
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedState
+}
+
+func NewRevocations(repo Repository, ttl time.Duration) *Revocations {
+	return &Revocations{
+		Repo:  repo,
+		ttl:   ttl,
+		cache: map[string]cachedState{},
+	}
+}
+
+// Active reports whether the session with the hex id is neither revoked nor expired
+func (r *Revocations) Active(id string) (bool, error) {
+	if r.ttl > 0 {
+		r.mu.Lock()
+		cached, exists := r.cache[id]
+		r.mu.Unlock()
+		if exists && time.Now().Before(cached.expiresAt) {
+			return cached.active, nil
+		}
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+	session, err := r.Repo.Read(objectId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[id] = cachedState{
+			userId:    session.UserID,
+			active:    session.IsActive(),
+			expiresAt: time.Now().Add(r.ttl),
+		}
+		r.mu.Unlock()
+	}
+
+	return session.IsActive(), nil
+}
+
+// Forget drops the cached session, to be called when it is revoked
+func (r *Revocations) Forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.cache, id)
+}
+
+// ForgetUser drops the cached sessions of the user, to be called when they are all revoked
+func (r *Revocations) ForgetUser(userId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, cached := range r.cache {
+		if cached.userId == userId {
+			delete(r.cache, id)
+		}
+	}
+}
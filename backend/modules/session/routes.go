@@ -0,0 +1,10 @@
+package session
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/", h.List)
+	r.DELETE("/:id", h.Revoke)
+}
@@ -0,0 +1,176 @@
+package token
+
+import (
+	"harmony/modules/user"
+	"harmony/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Handler struct {
+	Repo     Repository
+	RepoUser user.Repository
+}
+
+func NewHandler(repo Repository, repoUser user.Repository) *Handler {
+	return &Handler{
+		Repo:     repo,
+		RepoUser: repoUser,
+	}
+}
+
+// target resolves the :id of the path, "@me" or the id of a bot owned by the caller
+func (h *Handler) target(c *gin.Context) (*user.User, bool) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return nil, false
+	}
+
+	// search caller
+	caller, err := h.RepoUser.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return nil, false
+	}
+	if id == "@me" {
+		return caller, true
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	// search user
+	target, err := h.RepoUser.Read(objectId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return nil, false
+	}
+
+	// permission check
+	if !caller.CanManage(target) {
+		c.Status(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return target, true
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	type RequestBody struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		MaxAge int      `json:"max_age"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxAge := time.Duration(rb.MaxAge) * time.Second
+	if check, errMsg := IsNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsScopesValid(rb.Scopes); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsMaxAgeValid(maxAge); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	target, ok := h.target(c)
+	if !ok {
+		return
+	}
+
+	// limit the active tokens
+	tokens, err := h.Repo.ReadByUser(target.UniqueName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive tokens"})
+		return
+	}
+	if len(tokens) >= MaxTokens {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many tokens"})
+		return
+	}
+
+	// create
+	token, value := NewToken(target.UniqueName, rb.Name, rb.Scopes, maxAge)
+
+	err = h.Repo.Create(&token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	// the value is shown only now
+	result := token.Print()
+	result["token"] = value
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func (h *Handler) List(c *gin.Context) {
+	target, ok := h.target(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.Repo.ReadByUser(target.UniqueName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive tokens"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, token.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) Revoke(c *gin.Context) {
+	tokenId := c.Param("tokenId")
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(tokenId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target, ok := h.target(c)
+	if !ok {
+		return
+	}
+
+	// search token
+	token, err := h.Repo.Read(objectId)
+	if err != nil || token.UserID != target.UniqueName || token.RevokedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive token"})
+		return
+	}
+
+	err = h.Repo.Revoke(token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
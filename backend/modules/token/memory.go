@@ -0,0 +1,113 @@
+package token
+
+import (
+	"harmony/internal/database"
+	"harmony/utils"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository keeps the tokens in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	mem.Unique("tokens", "hash")
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(token *Token) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		id, err := tx.Insert("tokens", token)
+		if err != nil {
+			return err
+		}
+		token.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Token, error) {
+	return r.findOne(bson.M{"_id": id})
+}
+
+func (r *MemoryRepository) ReadByValue(value string) (*Token, error) {
+	return r.findOne(bson.M{"hash": utils.HashToken(value)})
+}
+
+func (r *MemoryRepository) findOne(filter bson.M) (*Token, error) {
+	var token Token
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("tokens", filter, &token)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *MemoryRepository) ReadByUser(userId string) ([]Token, error) {
+	var all []Token
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("tokens", bson.M{"user_id": userId, "revoked_at": nil}, &all)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []Token{}
+	for _, token := range all {
+		if token.IsActive() {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (r *MemoryRepository) Touch(token *Token) error {
+	now := time.Now()
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < lastUsedPrecision {
+		return nil
+	}
+
+	if err := r.set(token.ID, func(stored *Token) { stored.LastUsedAt = &now }); err != nil {
+		return err
+	}
+
+	token.LastUsedAt = &now
+
+	return nil
+}
+
+func (r *MemoryRepository) Revoke(token *Token) error {
+	now := time.Now()
+	if err := r.set(token.ID, func(stored *Token) { stored.RevokedAt = &now }); err != nil {
+		return err
+	}
+
+	token.RevokedAt = &now
+
+	return nil
+}
+
+func (r *MemoryRepository) set(id primitive.ObjectID, fn func(stored *Token)) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Token
+		_, err := tx.Modify("tokens", id, &stored, func() {
+			fn(&stored)
+		})
+		return err
+	})
+}
@@ -0,0 +1,122 @@
+package token
+
+import (
+	"fmt"
+	"harmony/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Prefix tells personal access tokens apart from session JWTs
+	Prefix    = "hmy_"
+	tokenSize = 32
+	// MaxTokens is how many active tokens a user can have
+	MaxTokens = 25
+	MaxAge    = 365 * 24 * time.Hour
+)
+
+// scopes a token can be granted, session JWTs have all of them
+const (
+	ScopeServersRead   = "servers:read"
+	ScopeServersWrite  = "servers:write"
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeGateway       = "gateway"
+)
+
+var Scopes = []string{
+	ScopeServersRead,
+	ScopeServersWrite,
+	ScopeMessagesRead,
+	ScopeMessagesWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeGateway,
+}
+
+type Token struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     string             `bson:"user_id"`
+	Name       string             `bson:"name"`
+	Hint       string             `bson:"hint"`
+	Hash       string             `bson:"hash"`
+	Scopes     []string           `bson:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at"`
+	RevokedAt  *time.Time         `bson:"revoked_at"`
+}
+
+// NewToken creates the token, the returned value is the only time the token is in clear
+func NewToken(userId string, name string, scopes []string, maxAge time.Duration) (Token, string) {
+	value := Prefix + utils.GetRandomToken(tokenSize)
+	now := time.Now()
+	token := Token{
+		UserID:    userId,
+		Name:      name,
+		Hint:      value[len(value)-4:],
+		Hash:      utils.HashToken(value),
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if maxAge > 0 {
+		expiresAt := now.Add(maxAge)
+		token.ExpiresAt = &expiresAt
+	}
+	return token, value
+}
+
+func IsNameValid(name string) (bool, string) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return false, "Name is empty"
+	}
+	if len(name) > 64 {
+		return false, "Name is longer than 64 caracters"
+	}
+	return true, ""
+}
+
+func IsScopesValid(scopes []string) (bool, string) {
+	if len(scopes) == 0 {
+		return false, "Scopes are empty"
+	}
+	for _, scope := range scopes {
+		if !utils.Contains(Scopes, scope) {
+			return false, fmt.Sprintf("Scope %s does not exist", scope)
+		}
+	}
+	return true, ""
+}
+
+func IsMaxAgeValid(maxAge time.Duration) (bool, string) {
+	if maxAge < 0 || maxAge > MaxAge {
+		return false, "Max age is out of range"
+	}
+	return true, ""
+}
+
+func (t *Token) IsActive() bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt))
+}
+
+func (t *Token) HasScope(scope string) bool {
+	return utils.Contains(t.Scopes, scope)
+}
+
+func (t *Token) Print() map[string]any {
+	return map[string]any{
+		"id":           t.ID,
+		"name":         t.Name,
+		"hint":         t.Hint,
+		"scopes":       t.Scopes,
+		"created_at":   t.CreatedAt,
+		"last_used_at": t.LastUsedAt,
+		"expires_at":   t.ExpiresAt,
+	}
+}
@@ -0,0 +1,181 @@
+package token
+
+import (
+	"context"
+	"harmony/internal/database"
+	"harmony/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// lastUsedPrecision limits the writes of tokens used by every request
+const lastUsedPrecision = time.Minute
+
+// Repository stores the personal access tokens, only the hash of the value is kept
+type Repository interface {
+	Create(token *Token) error
+	Read(id primitive.ObjectID) (*Token, error)
+	ReadByValue(value string) (*Token, error)
+	ReadByUser(userId string) ([]Token, error)
+	Touch(token *Token) error
+	Revoke(token *Token) error
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+func (r *MongoRepository) Create(token *Token) error {
+	cTokens := r.db.Collection("tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cTokens.InsertOne(ctx, bson.M{
+		"user_id":      token.UserID,
+		"name":         token.Name,
+		"hint":         token.Hint,
+		"hash":         token.Hash,
+		"scopes":       token.Scopes,
+		"created_at":   token.CreatedAt,
+		"last_used_at": token.LastUsedAt,
+		"expires_at":   token.ExpiresAt,
+		"revoked_at":   token.RevokedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	token.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Token, error) {
+	cTokens := r.db.Collection("tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var token Token
+	err := cTokens.FindOne(ctx, bson.M{"_id": id}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// ReadByValue finds the token presented by a client
+func (r *MongoRepository) ReadByValue(value string) (*Token, error) {
+	cTokens := r.db.Collection("tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var token Token
+	err := cTokens.FindOne(ctx, bson.M{"hash": utils.HashToken(value)}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// ReadByUser returns the active tokens of the user
+func (r *MongoRepository) ReadByUser(userId string) ([]Token, error) {
+	cTokens := r.db.Collection("tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userId,
+		"revoked_at": nil,
+		"$or": bson.A{
+			bson.M{"expires_at": nil},
+			bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := cTokens.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []Token{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Touch records the token was used, at most once per lastUsedPrecision
+func (r *MongoRepository) Touch(token *Token) error {
+	now := time.Now()
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < lastUsedPrecision {
+		return nil
+	}
+
+	cTokens := r.db.Collection("tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cTokens.UpdateByID(ctx, token.ID, bson.M{
+		"$set": bson.M{"last_used_at": now},
+	})
+	if err != nil {
+		return err
+	}
+
+	token.LastUsedAt = &now
+
+	return nil
+}
+
+func (r *MongoRepository) Revoke(token *Token) error {
+	cTokens := r.db.Collection("tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	now := time.Now()
+	_, err := cTokens.UpdateByID(ctx, token.ID, bson.M{
+		"$set": bson.M{"revoked_at": now},
+	})
+	if err != nil {
+		return err
+	}
+
+	token.RevokedAt = &now
+
+	return nil
+}
+
+// EnsureIndexes creates the index used to authenticate every request made with a token
+func (r *MongoRepository) EnsureIndexes() error {
+	cTokens := r.db.Collection("tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cTokens, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	return err
+}
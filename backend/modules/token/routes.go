@@ -0,0 +1,11 @@
+package token
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/", h.List)
+	r.POST("/", h.Create)
+	r.DELETE("/:tokenId", h.Revoke)
+}
@@ -1,27 +1,41 @@
 package user
 
 import (
-	"harmony/internal/database"
+	"errors"
+	"harmony/internal/mail"
+	"harmony/modules/session"
+	"harmony/utils"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Handler struct {
-	Repo *Repository
+	Repo     Repository
+	Sessions session.Repository
+	Mailer   mail.Mailer
+	// Local tells whether users can have a password
+	Local bool
 }
 
-func NewHandler(db *database.Service) *Handler {
+func NewHandler(repo Repository, sessions session.Repository, mailer mail.Mailer, local bool) *Handler {
 	return &Handler{
-		Repo: NewRepository(db.Mongo),
+		Repo:     repo,
+		Sessions: sessions,
+		Mailer:   mailer,
+		Local:    local,
 	}
 }
 
 func (h *Handler) Create(c *gin.Context) {
 	type RequestBody struct {
-		Name string `json:"name"`
-		Mail string `json:"mail"`
+		Name     string  `json:"name"`
+		Mail     string  `json:"mail"`
+		Password *string `json:"password"`
 	}
 	var rb RequestBody
 
@@ -30,6 +44,16 @@ func (h *Handler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if rb.Password != nil {
+		if !h.Local {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Local authentication is disabled"})
+			return
+		}
+		if check, errMsg := IsPasswordValid(*rb.Password); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
 	if check, errMsg := IsNameValid(rb.Name); !check {
 		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 		return
@@ -46,19 +70,39 @@ func (h *Handler) Create(c *gin.Context) {
 		return
 	}
 	if isMailUsed {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Mail already used"})
+		c.JSON(http.StatusConflict, gin.H{"error": "Mail already used"})
 		return
 	}
 
 	// create
 	user := NewUser(rb.Name, rb.Mail)
+	if rb.Password != nil {
+		if err := user.SetPassword(*rb.Password); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+	}
 
 	err = h.Repo.Create(&user)
+	if errors.Is(err, ErrMailTaken) {
+		// registered meanwhile
+		c.JSON(http.StatusConflict, gin.H{"error": "Mail already used"})
+		return
+	}
+	if errors.Is(err, ErrUniqueNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Name already taken, try again"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
+	// the user can ask another mail if this one gets lost
+	if err := SendVerification(h.Repo, h.Mailer, &user); err != nil {
+		log.Printf("failed to send verification to %s: %v", user.Mail, err)
+	}
+
 	c.JSON(http.StatusCreated, user.Print())
 }
 
@@ -82,13 +126,37 @@ func (h *Handler) Read(c *gin.Context) {
 	c.JSON(http.StatusOK, user.Print())
 }
 
+func (h *Handler) ReadMe(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// search user
+	user, err := h.Repo.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Print())
+}
+
 func (h *Handler) Update(c *gin.Context) {
 	id := c.Param("id")
 	type RequestBody struct {
-		Name string `json:"name"`
+		Name        *string `json:"name"`
+		DisplayName *string `json:"display_name"`
 	}
 	var rb RequestBody
 
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
 	// validate input
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -99,9 +167,17 @@ func (h *Handler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if check, errMsg := IsNameValid(rb.Name); !check {
-		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
-		return
+	if rb.Name != nil {
+		if check, errMsg := IsNameValid(*rb.Name); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+	if rb.DisplayName != nil {
+		if check, errMsg := IsDisplayNameValid(*rb.DisplayName); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
 	}
 
 	// search user
@@ -111,8 +187,19 @@ func (h *Handler) Update(c *gin.Context) {
 		return
 	}
 
+	// users can only change themselves
+	if user.UniqueName != sub {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
 	// update data
-	user.Name = rb.Name
+	if rb.Name != nil {
+		user.Name = *rb.Name
+	}
+	if rb.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*rb.DisplayName)
+	}
 
 	err = h.Repo.Update(user)
 	if err != nil {
@@ -126,6 +213,12 @@ func (h *Handler) Update(c *gin.Context) {
 func (h *Handler) Delete(c *gin.Context) {
 	id := c.Param("id")
 
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
 	// validate input
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -133,6 +226,19 @@ func (h *Handler) Delete(c *gin.Context) {
 		return
 	}
 
+	// search user
+	user, err := h.Repo.Read(objectId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// users can only delete themselves
+	if user.UniqueName != sub {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
 	// try deleting
 	isDeleted, err := h.Repo.Delete(objectId)
 	if err != nil {
@@ -146,3 +252,325 @@ func (h *Handler) Delete(c *gin.Context) {
 
 	c.Status(http.StatusNoContent)
 }
+
+func (h *Handler) ListIdentities(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// search user
+	user, err := h.Repo.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Identities)
+}
+
+func (h *Handler) UnlinkIdentity(c *gin.Context) {
+	issuer := c.Query("issuer")
+	subject := c.Query("subject")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// search user
+	user, err := h.Repo.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+	if !user.HasIdentity(issuer, subject) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive identity"})
+		return
+	}
+
+	isRemoved, err := h.Repo.RemoveIdentity(user, issuer, subject)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+	if !isRemoved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot unlink the last identity"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ChangePassword(c *gin.Context) {
+	type RequestBody struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	var rb RequestBody
+
+	if !h.Local {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Local authentication is disabled"})
+		return
+	}
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsPasswordValid(rb.NewPassword); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search user
+	user, err := h.Repo.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// users logging in only with a provider can set a first password
+	if user.HasPassword() {
+		isValid, err := VerifyPassword(rb.CurrentPassword, user.PasswordHash)
+		if err != nil || !isValid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Wrong password"})
+			return
+		}
+	}
+
+	// update data
+	if err := user.SetPassword(rb.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	err = h.Repo.UpdatePassword(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// the other devices have to log in again
+	sid, _ := utils.GetSid(c)
+	current, _ := primitive.ObjectIDFromHex(sid)
+	if err := h.Sessions.RevokeOthers(sub, current); err != nil {
+		log.Printf("failed to revoke sessions of %s: %v", sub, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) RequestReset(c *gin.Context) {
+	type RequestBody struct {
+		Mail string `json:"mail"`
+	}
+	var rb RequestBody
+
+	if !h.Local {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Local authentication is disabled"})
+		return
+	}
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the answer is the same whether the mail exists or not
+	user, err := h.Repo.ReadByMail(rb.Mail)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("failed to search user %s: %v", rb.Mail, err)
+		}
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	if err := SendReset(h.Repo, h.Mailer, user); err != nil {
+		log.Printf("failed to send password reset to %s: %v", user.Mail, err)
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func (h *Handler) ConfirmReset(c *gin.Context) {
+	type RequestBody struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	var rb RequestBody
+
+	if !h.Local {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Local authentication is disabled"})
+		return
+	}
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsPasswordValid(rb.Password); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search user
+	user, err := h.Repo.ReadByResetToken(rb.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reset token is not valid"})
+		return
+	}
+
+	// update data
+	if err := user.SetPassword(rb.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	err = h.Repo.UpdatePassword(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// whoever knew the old password is logged out
+	if err := h.Sessions.RevokeByUser(user.UniqueName); err != nil {
+		log.Printf("failed to revoke sessions of %s: %v", user.UniqueName, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) Verify(c *gin.Context) {
+	type RequestBody struct {
+		Token string `json:"token"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// search user
+	user, err := h.Repo.ReadByVerifyToken(rb.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification token is not valid"})
+		return
+	}
+
+	err = h.Repo.MarkVerified(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Print())
+}
+
+func (h *Handler) ResendVerification(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// search user
+	user, err := h.Repo.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+	if user.Verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User already verified"})
+		return
+	}
+
+	if err := SendVerification(h.Repo, h.Mailer, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification"})
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func (h *Handler) CreateBot(c *gin.Context) {
+	type RequestBody struct {
+		Name string `json:"name"`
+	}
+	var rb RequestBody
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsNameValid(rb.Name); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	// search owner, bots can't own bots
+	owner, err := h.Repo.ReadByUniqueName(sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+	if owner.Bot {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	// create
+	bot := NewBot(rb.Name, owner.UniqueName)
+
+	err = h.Repo.Create(&bot)
+	if errors.Is(err, ErrUniqueNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Name already taken, try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create bot"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, bot.Print())
+}
+
+func (h *Handler) ListBots(c *gin.Context) {
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	bots, err := h.Repo.ReadBots(sub)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive bots"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(bots))
+	for _, bot := range bots {
+		result = append(result, bot.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"fmt"
+	"harmony/internal/mail"
+	"time"
+)
+
+// VerifyTTL is how long a mail verification token can be used
+const VerifyTTL = 48 * time.Hour
+
+type mailData struct {
+	Name    string
+	Link    string
+	Expires string
+}
+
+// SendVerification mails the user a new verification link
+func SendVerification(repo Repository, mailer mail.Mailer, user *User) error {
+	token, err := repo.CreateVerifyToken(user)
+	if err != nil {
+		return err
+	}
+
+	msg, err := mail.Render(mail.TemplateVerify, user.Mail, mailData{
+		Name:    user.DisplayName,
+		Link:    mail.Link("/verify", token),
+		Expires: hours(VerifyTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(msg)
+}
+
+// SendReset mails the user a password reset link
+func SendReset(repo Repository, mailer mail.Mailer, user *User) error {
+	token, err := repo.CreateResetToken(user)
+	if err != nil {
+		return err
+	}
+
+	msg, err := mail.Render(mail.TemplateReset, user.Mail, mailData{
+		Name:    user.DisplayName,
+		Link:    mail.Link("/reset-password", token),
+		Expires: hours(ResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(msg)
+}
+
+func hours(d time.Duration) string {
+	if d == time.Hour {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", int(d.Hours()))
+}
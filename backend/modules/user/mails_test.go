@@ -0,0 +1,157 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"harmony/internal/database"
+	"harmony/internal/mail"
+	"harmony/modules/session"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var tokenParam = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+type verifyEnv struct {
+	mem    *database.Memory
+	repo   *MemoryRepository
+	mailer *mail.MemoryMailer
+	router *gin.Engine
+}
+
+func newVerifyEnv(t *testing.T) *verifyEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	mem := database.NewMemory()
+	env := &verifyEnv{
+		mem:    mem,
+		repo:   NewMemoryRepository(mem),
+		mailer: mail.NewMemoryMailer(),
+		router: gin.New(),
+	}
+	h := NewHandler(env.repo, session.NewMemoryRepository(mem), env.mailer, false)
+	RegisterVerifyRoutes(env.router.Group("/user/verify"), h)
+	return env
+}
+
+// send mails a verification to the user and returns the token of the link
+func (env *verifyEnv) send(t *testing.T, user *User) string {
+	t.Helper()
+
+	if err := SendVerification(env.repo, env.mailer, user); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+	messages := env.mailer.Messages()
+	msg := messages[len(messages)-1]
+	if msg.To != user.Mail {
+		t.Fatalf("expected a mail to %s, got %s", user.Mail, msg.To)
+	}
+	match := tokenParam.FindStringSubmatch(msg.Text)
+	if match == nil {
+		t.Fatal("expected a link with a token in the mail")
+	}
+	return match[1]
+}
+
+func (env *verifyEnv) verify(t *testing.T, token string) int {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"token": token})
+	req := httptest.NewRequest(http.MethodPost, "/user/verify/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	env.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (env *verifyEnv) isVerified(t *testing.T, user *User) bool {
+	t.Helper()
+
+	stored, err := env.repo.Read(user.ID)
+	if err != nil {
+		t.Fatalf("read user: %v", err)
+	}
+	return stored.Verified
+}
+
+func TestVerifyToken(t *testing.T) {
+	env := newVerifyEnv(t)
+	user := register(t, env.repo, "alice", "alice@example.com", false)
+	token := env.send(t, user)
+
+	// only the hash is stored
+	stored, err := env.repo.Read(user.ID)
+	if err != nil {
+		t.Fatalf("read user: %v", err)
+	}
+	if stored.VerifyHash == "" || stored.VerifyHash == token {
+		t.Fatalf("expected the hash of the token, got %q", stored.VerifyHash)
+	}
+
+	if status := env.verify(t, "unknown"); status != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", status)
+	}
+	if status := env.verify(t, token); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if !env.isVerified(t, user) {
+		t.Fatal("expected the user to be verified")
+	}
+
+	// the token is consumed
+	if status := env.verify(t, token); status != http.StatusBadRequest {
+		t.Fatalf("expected the token to be used once, got %d", status)
+	}
+}
+
+func TestVerifyTokenReplaced(t *testing.T) {
+	env := newVerifyEnv(t)
+	user := register(t, env.repo, "alice", "alice@example.com", false)
+
+	first := env.send(t, user)
+	second := env.send(t, user)
+
+	// a new mail invalidates the link of the previous one
+	if status := env.verify(t, first); status != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", status)
+	}
+	if env.isVerified(t, user) {
+		t.Fatal("expected the user not to be verified")
+	}
+	if status := env.verify(t, second); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+}
+
+func TestVerifyTokenExpired(t *testing.T) {
+	env := newVerifyEnv(t)
+	user := register(t, env.repo, "alice", "alice@example.com", false)
+	token := env.send(t, user)
+
+	err := env.mem.Update(func(tx *database.MemoryTx) error {
+		var stored User
+		_, err := tx.Modify("users", user.ID, &stored, func() {
+			expired := time.Now().Add(-time.Second)
+			stored.VerifyExpiresAt = &expired
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("expire token: %v", err)
+	}
+
+	if status := env.verify(t, token); status != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", status)
+	}
+	if env.isVerified(t, user) {
+		t.Fatal("expected the user not to be verified")
+	}
+}
@@ -0,0 +1,278 @@
+package user
+
+import (
+	"errors"
+	"harmony/internal/database"
+	"harmony/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRepository keeps the users in memory, it behaves like MongoRepository
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	mem.Unique("users", "mail")
+	mem.Unique("users", "unique_name")
+	mem.Unique("users", "identities.issuer", "identities.subject")
+	mem.Unique("user_codes", "name")
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+// find returns the first user matching the filter and fn
+func (r *MemoryRepository) find(filter bson.M, fn func(user *User) bool) (*User, error) {
+	var users []User
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("users", filter, &users)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		if fn == nil || fn(&users[i]) {
+			return &users[i], nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// modify applies fn to the stored user and copies the result back to user
+func (r *MemoryRepository) modify(user *User, fn func(stored *User)) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored User
+		found, err := tx.Modify("users", user.ID, &stored, func() {
+			fn(&stored)
+		})
+		if err != nil || !found {
+			return err
+		}
+
+		*user = stored
+		return nil
+	})
+}
+
+func (r *MemoryRepository) IsMailUsed(mail string) (bool, error) {
+	_, err := r.ReadByMail(mail)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *MemoryRepository) Create(user *User) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var userCode UserCode
+		err := tx.FindOne("user_codes", bson.M{"name": user.Name}, &userCode)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		newCode := utils.GetRandomCode(userCode.Codes)
+		if userCode.ID.IsZero() {
+			_, err = tx.Insert("user_codes", UserCode{Name: user.Name, Codes: []int{newCode}})
+		} else {
+			userCode.Codes = append(userCode.Codes, newCode)
+			_, err = tx.Replace("user_codes", userCode.ID, userCode)
+		}
+		if err != nil {
+			return err
+		}
+
+		// creates new user
+		user.GenerateUniqueName(newCode)
+		id, err := tx.Insert("users", user)
+		if err != nil {
+			return duplicateKey(err)
+		}
+		user.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*User, error) {
+	return r.find(bson.M{"_id": id}, nil)
+}
+
+func (r *MemoryRepository) ReadByUniqueName(uniqueName string) (*User, error) {
+	return r.find(bson.M{"unique_name": uniqueName}, nil)
+}
+
+func (r *MemoryRepository) ReadByMail(mail string) (*User, error) {
+	// bots have no mail
+	if mail == "" {
+		return nil, mongo.ErrNoDocuments
+	}
+	return r.find(bson.M{"mail": mail}, nil)
+}
+
+func (r *MemoryRepository) ReadBots(ownerId string) ([]User, error) {
+	users := []User{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("users", bson.M{"bot": true, "owner_id": ownerId}, &users)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *MemoryRepository) ReadByIdentity(issuer string, subject string) (*User, error) {
+	return r.find(nil, func(user *User) bool {
+		return user.HasIdentity(issuer, subject)
+	})
+}
+
+func (r *MemoryRepository) AddIdentity(user *User, identity Identity) error {
+	err := r.modify(user, func(stored *User) {
+		if !stored.HasIdentity(identity.Issuer, identity.Subject) {
+			stored.Identities = append(stored.Identities, identity)
+		}
+	})
+	if err != nil {
+		return duplicateKey(err)
+	}
+	return nil
+}
+
+func (r *MemoryRepository) RemoveIdentity(user *User, issuer string, subject string) (bool, error) {
+	removed := false
+	err := r.modify(user, func(stored *User) {
+		// the last identity stays so the user can still log in
+		if len(stored.Identities) < 2 || !stored.HasIdentity(issuer, subject) {
+			return
+		}
+		identities := []Identity{}
+		for _, identity := range stored.Identities {
+			if identity.Issuer != issuer || identity.Subject != subject {
+				identities = append(identities, identity)
+			}
+		}
+		stored.Identities = identities
+		removed = true
+	})
+
+	return removed, err
+}
+
+func (r *MemoryRepository) Update(user *User) error {
+	return r.modify(user, func(stored *User) {
+		stored.Name = user.Name
+		stored.DisplayName = user.DisplayName
+	})
+}
+
+func (r *MemoryRepository) UpdatePassword(user *User) error {
+	return r.modify(user, func(stored *User) {
+		stored.PasswordHash = user.PasswordHash
+		stored.FailedLogins = 0
+		stored.LockedUntil = nil
+		stored.ResetHash = ""
+		stored.ResetExpiresAt = nil
+	})
+}
+
+func (r *MemoryRepository) RecordLoginFailure(user *User) error {
+	return r.modify(user, func(stored *User) {
+		stored.FailedLogins++
+		if stored.FailedLogins < MaxFailedLogins {
+			return
+		}
+
+		lockedUntil := time.Now().Add(LockoutDuration)
+		stored.FailedLogins = 0
+		stored.LockedUntil = &lockedUntil
+	})
+}
+
+func (r *MemoryRepository) ResetLoginFailures(user *User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+
+	return r.modify(user, func(stored *User) {
+		stored.FailedLogins = 0
+		stored.LockedUntil = nil
+	})
+}
+
+func (r *MemoryRepository) CreateResetToken(user *User) (string, error) {
+	token := utils.GetRandomToken(32)
+	expiresAt := time.Now().Add(ResetTTL)
+	err := r.modify(user, func(stored *User) {
+		stored.ResetHash = utils.HashToken(token)
+		stored.ResetExpiresAt = &expiresAt
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (r *MemoryRepository) ReadByResetToken(token string) (*User, error) {
+	return r.find(bson.M{"reset_hash": utils.HashToken(token)}, func(user *User) bool {
+		return user.ResetExpiresAt != nil && user.ResetExpiresAt.After(time.Now())
+	})
+}
+
+func (r *MemoryRepository) CreateVerifyToken(user *User) (string, error) {
+	token := utils.GetRandomToken(32)
+	expiresAt := time.Now().Add(VerifyTTL)
+	err := r.modify(user, func(stored *User) {
+		stored.VerifyHash = utils.HashToken(token)
+		stored.VerifyExpiresAt = &expiresAt
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (r *MemoryRepository) ReadByVerifyToken(token string) (*User, error) {
+	return r.find(bson.M{"verify_hash": utils.HashToken(token)}, func(user *User) bool {
+		return user.VerifyExpiresAt != nil && user.VerifyExpiresAt.After(time.Now())
+	})
+}
+
+func (r *MemoryRepository) MarkVerified(user *User) error {
+	return r.modify(user, func(stored *User) {
+		stored.Verified = true
+		stored.VerifyHash = ""
+		stored.VerifyExpiresAt = nil
+	})
+}
+
+func (r *MemoryRepository) Delete(id primitive.ObjectID) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		var found User
+		err := tx.FindOne("users", bson.M{"_id": id}, &found)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		deleted = tx.Delete("users", bson.M{"_id": id}) > 0
+		tx.Delete("members", bson.M{"user_id": found.UniqueName})
+		return nil
+	})
+
+	return deleted, err
+}
@@ -0,0 +1,51 @@
+package user
+
+import (
+	"context"
+
+	"harmony/internal/database"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MigrateUniqueIdentities replaces the index on the identities with the unique one, the creation fails
+// naming the duplicate key when an identity is already linked to two users: one of them has to be unlinked
+// before running it again
+func (r *MongoRepository) MigrateUniqueIdentities(ctx context.Context) error {
+	cUsers := r.db.Collection("users")
+
+	cursor, err := cUsers.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []struct {
+		Name   string `bson:"name"`
+		Unique bool   `bson:"unique"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index.Name == "identities.issuer_1_identities.subject_1" && !index.Unique {
+			if _, err := cUsers.Indexes().DropOne(ctx, index.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return database.EnsureIndexes(ctx, cUsers, []mongo.IndexModel{
+		{Keys: identitiesKeys, Options: identitiesOptions()},
+	})
+}
+
+// DropUniqueIdentities goes back to the index on the identities that allows duplicates
+func (r *MongoRepository) DropUniqueIdentities(ctx context.Context) error {
+	cUsers := r.db.Collection("users")
+
+	if _, err := cUsers.Indexes().DropOne(ctx, "identities.issuer_1_identities.subject_1"); err != nil {
+		return err
+	}
+	_, err := cUsers.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: identitiesKeys})
+	return err
+}
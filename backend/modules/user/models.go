@@ -4,25 +4,67 @@ import (
 	"fmt"
 	"harmony/utils"
 	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	// NameMaxLength bounds the names derived from the provider claims
+	NameMaxLength        = 32
+	DisplayNameMaxLength = 32
+)
+
 type User struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	Name       string             `bson:"name" json:"name"`
-	Mail       string             `bson:"mail" json:"mail"`
-	UniqueName string             `bson:"unique_name"`
-	Servers    map[string]string  `bson:"servers"`
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Name        string             `bson:"name" json:"name"`
+	DisplayName string             `bson:"display_name" json:"display_name"`
+	Mail        string             `bson:"mail" json:"mail"`
+	UniqueName  string             `bson:"unique_name"`
+	Identities  []Identity         `bson:"identities"`
+	// Bot users have no mail nor login, they act through the tokens their owner creates
+	Bot     bool   `bson:"bot" json:"bot"`
+	OwnerID string `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	// Verified is set once the user proved to own the mail
+	Verified        bool       `bson:"verified" json:"verified"`
+	VerifyHash      string     `bson:"verify_hash,omitempty" json:"-"`
+	VerifyExpiresAt *time.Time `bson:"verify_expires_at,omitempty" json:"-"`
+	// local credentials, only used when AUTH_LOCAL is enabled
+	PasswordHash   string     `bson:"password_hash,omitempty" json:"-"`
+	FailedLogins   int        `bson:"failed_logins" json:"-"`
+	LockedUntil    *time.Time `bson:"locked_until,omitempty" json:"-"`
+	ResetHash      string     `bson:"reset_hash,omitempty" json:"-"`
+	ResetExpiresAt *time.Time `bson:"reset_expires_at,omitempty" json:"-"`
+}
+
+// Identity links the user to an account of an OIDC provider, it is keyed by issuer and subject
+// while the provider name is kept to show it
+type Identity struct {
+	Provider string `bson:"provider" json:"provider"`
+	Issuer   string `bson:"issuer" json:"issuer"`
+	Subject  string `bson:"subject" json:"subject"`
 }
 
 func NewUser(name string, mail string) User {
 	return User{
-		Name: name,
-		Mail: mail,
+		Name:        name,
+		DisplayName: name,
+		Mail:        mail,
+		Identities:  []Identity{},
 	}
 }
 
+// NewBot creates a bot owned by the user, it counts as verified as it has no mail
+func NewBot(name string, ownerId string) User {
+	bot := NewUser(name, "")
+	bot.Bot = true
+	bot.OwnerID = ownerId
+	bot.Verified = true
+	return bot
+}
+
 func IsNameValid(name string) (bool, string) {
 	if len(name) == 0 {
 		return false, "Name is empty"
@@ -34,6 +76,41 @@ func IsNameValid(name string) (bool, string) {
 	return true, ""
 }
 
+func IsDisplayNameValid(displayName string) (bool, string) {
+	displayName = strings.TrimSpace(displayName)
+	if len(displayName) == 0 {
+		return false, "Display name is empty"
+	}
+	if utf8.RuneCountInString(displayName) > DisplayNameMaxLength {
+		return false, fmt.Sprintf("Display name is longer than %d caracters", DisplayNameMaxLength)
+	}
+	return true, ""
+}
+
+// DeriveName turns the first usable candidate into a name accepted by IsNameValid,
+// spaces and separators become underscores and the other caracters are dropped
+func DeriveName(candidates ...string) string {
+	for _, candidate := range candidates {
+		var b strings.Builder
+		for _, r := range candidate {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				b.WriteRune(r)
+			case r == ' ' || r == '-' || r == '.':
+				b.WriteRune('_')
+			}
+			if b.Len() == NameMaxLength {
+				break
+			}
+		}
+		name := strings.Trim(b.String(), "_")
+		if check, _ := IsNameValid(name); check {
+			return name
+		}
+	}
+	return "user"
+}
+
 func IsMailValid(mail string) (bool, string) {
 	if len(mail) == 0 {
 		return false, "Mail is empty"
@@ -51,13 +128,31 @@ func (u *User) GenerateUniqueName(code int) {
 	u.UniqueName = fmt.Sprintf("%s:%04d", u.Name, code)
 }
 
+// HasIdentity tells whether the provider account is linked to the user
+func (u *User) HasIdentity(issuer string, subject string) bool {
+	for _, identity := range u.Identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// CanManage tells whether the user acts on the other one, itself or a bot it owns
+func (u *User) CanManage(other *User) bool {
+	return u.UniqueName == other.UniqueName || (other.Bot && other.OwnerID == u.UniqueName)
+}
+
 func (u *User) Print() map[string]any {
 	return map[string]any{
-		"id":          u.ID,
-		"name":        u.Name,
-		"mail":        u.Mail,
-		"unique_name": u.UniqueName,
-		"servers":     u.Servers,
+		"id":           u.ID,
+		"name":         u.Name,
+		"display_name": u.DisplayName,
+		"mail":         u.Mail,
+		"verified":     u.Verified,
+		"bot":          u.Bot,
+		"unique_name":  u.UniqueName,
+		"identities":   u.Identities,
 	}
 }
 
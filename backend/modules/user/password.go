@@ -0,0 +1,113 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	PasswordMinLength = 8
+	PasswordMaxLength = 128
+	// MaxFailedLogins is how many wrong passwords in a row lock the account for LockoutDuration
+	MaxFailedLogins = 5
+	LockoutDuration = 15 * time.Minute
+	// ResetTTL is how long a password reset token can be used
+	ResetTTL = time.Hour
+)
+
+// argon2id parameters, the ones suggested by RFC 9106 for memory constrained environments
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+func IsPasswordValid(password string) (bool, string) {
+	length := utf8.RuneCountInString(password)
+	if length < PasswordMinLength {
+		return false, fmt.Sprintf("Password is shorter than %d caracters", PasswordMinLength)
+	}
+	if length > PasswordMaxLength {
+		return false, fmt.Sprintf("Password is longer than %d caracters", PasswordMaxLength)
+	}
+	return true, ""
+}
+
+// HashPassword hashes with argon2id and encodes the parameters with the hash so they can change later
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func VerifyPassword(password string, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrInvalidHash
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// SetPassword replaces the password hash and clears the lockout and any pending reset
+func (u *User) SetPassword(password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	u.FailedLogins = 0
+	u.LockedUntil = nil
+	u.ResetHash = ""
+	u.ResetExpiresAt = nil
+	return nil
+}
+
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"harmony/internal/database"
+	"testing"
+	"time"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	other, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if hash == other {
+		t.Fatal("expected a new salt for every hash")
+	}
+
+	if ok, err := VerifyPassword("correct horse battery", hash); err != nil || !ok {
+		t.Fatalf("expected the password to match, got %v %v", ok, err)
+	}
+	if ok, err := VerifyPassword("wrong horse battery", hash); err != nil || ok {
+		t.Fatalf("expected the password not to match, got %v %v", ok, err)
+	}
+	if _, err := VerifyPassword("correct horse battery", "not a hash"); err == nil {
+		t.Fatal("expected an invalid hash error")
+	}
+}
+
+func TestLoginFailures(t *testing.T) {
+	r := NewMemoryRepository(database.NewMemory())
+	user := register(t, r, "alice", "alice@example.com", true)
+
+	for i := 1; i < MaxFailedLogins; i++ {
+		if err := r.RecordLoginFailure(user); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+		if user.FailedLogins != i || user.IsLocked() {
+			t.Fatalf("expected %d failures and no lock, got %d %v", i, user.FailedLogins, user.LockedUntil)
+		}
+	}
+	if err := r.RecordLoginFailure(user); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	stored, err := r.Read(user.ID)
+	if err != nil {
+		t.Fatalf("read user: %v", err)
+	}
+	if !stored.IsLocked() {
+		t.Fatal("expected the user to be locked")
+	}
+	if until := time.Until(*stored.LockedUntil); until <= LockoutDuration-time.Minute || until > LockoutDuration {
+		t.Fatalf("expected a lock of %v, got %v", LockoutDuration, until)
+	}
+
+	if err := r.ResetLoginFailures(stored); err != nil {
+		t.Fatalf("reset failures: %v", err)
+	}
+	stored, err = r.Read(user.ID)
+	if err != nil {
+		t.Fatalf("read user: %v", err)
+	}
+	if stored.IsLocked() || stored.FailedLogins != 0 {
+		t.Fatalf("expected the lock to be cleared, got %d %v", stored.FailedLogins, stored.LockedUntil)
+	}
+}
+
+func TestIsLocked(t *testing.T) {
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Minute)
+
+	cases := []struct {
+		name        string
+		lockedUntil *time.Time
+		expected    bool
+	}{
+		{"never locked", nil, false},
+		{"lock expired", &past, false},
+		{"locked", &future, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := User{LockedUntil: tc.lockedUntil}
+			if user.IsLocked() != tc.expected {
+				t.Fatalf("expected %v", tc.expected)
+			}
+		})
+	}
+}
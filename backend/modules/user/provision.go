@@ -0,0 +1,112 @@
+package user
+
+import (
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrMailTaken is returned when the provider mail belongs to another user but either the provider
+// didn't verify it, linking it would let anyone claim the account, or the user didn't verify it,
+// linking it would let whoever registered the mail first keep a password on the account
+var ErrMailTaken = errors.New("mail already used by another user")
+
+// ErrIdentityTaken is returned when linking an identity already linked to another user
+var ErrIdentityTaken = errors.New("identity linked to another user")
+
+// Profile is what the OIDC provider tells about the user logging in
+type Profile struct {
+	Provider          string
+	Issuer            string
+	Subject           string
+	Mail              string
+	MailVerified      bool
+	PreferredUsername string
+	Name              string
+}
+
+// Provision returns the user linked to the provider account, on the first login it links
+// the user registered with the same verified mail or creates a new one
+func Provision(r Repository, p Profile) (*User, bool, error) {
+	identity := p.Identity()
+
+	user, err := r.ReadByIdentity(p.Issuer, p.Subject)
+	if err == nil {
+		if err := verifyFromProvider(r, user, p); err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, err
+	}
+
+	// registered and verified before logging in with the provider, an unverified account
+	// may have been registered by someone else with the mail of the user
+	user, err = r.ReadByMail(p.Mail)
+	if err == nil {
+		if !p.MailVerified || !user.Verified {
+			return nil, false, ErrMailTaken
+		}
+		if err := r.AddIdentity(user, identity); err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, err
+	}
+
+	// first login, the user can pick another display name later
+	mailName, _, _ := strings.Cut(p.Mail, "@")
+	newUser := NewUser(DeriveName(p.PreferredUsername, p.Name, mailName), p.Mail)
+	if check, _ := IsDisplayNameValid(p.Name); check {
+		newUser.DisplayName = strings.TrimSpace(p.Name)
+	}
+	newUser.Identities = []Identity{identity}
+	newUser.Verified = p.MailVerified
+
+	err = r.Create(&newUser)
+	if errors.Is(err, ErrIdentityTaken) {
+		// a concurrent login with the same identity created the user first
+		user, err := r.ReadByIdentity(p.Issuer, p.Subject)
+		if err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &newUser, true, nil
+}
+
+func (p Profile) Identity() Identity {
+	return Identity{Provider: p.Provider, Issuer: p.Issuer, Subject: p.Subject}
+}
+
+// Link adds the provider account to the user logged in
+func Link(r Repository, user *User, p Profile) error {
+	linked, err := r.ReadByIdentity(p.Issuer, p.Subject)
+	if err == nil {
+		if linked.ID != user.ID {
+			return ErrIdentityTaken
+		}
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	return r.AddIdentity(user, p.Identity())
+}
+
+// verifyFromProvider trusts the provider when it verified the same mail the user has
+func verifyFromProvider(r Repository, user *User, p Profile) error {
+	if user.Verified || !p.MailVerified || !strings.EqualFold(user.Mail, p.Mail) {
+		return nil
+	}
+	return r.MarkVerified(user)
+}
@@ -0,0 +1,126 @@
+package user
+
+import (
+	"errors"
+	"harmony/internal/database"
+	"testing"
+)
+
+func profile(mail string, verified bool) Profile {
+	return Profile{
+		Provider:     "corp",
+		Issuer:       "https://id.example.com",
+		Subject:      "victim",
+		Mail:         mail,
+		MailVerified: verified,
+		Name:         "Victim",
+	}
+}
+
+// register creates a local account with a password, verified or not
+func register(t *testing.T, r Repository, name string, mail string, verified bool) *User {
+	t.Helper()
+
+	user := NewUser(name, mail)
+	if err := user.SetPassword("correct horse battery"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if err := r.Create(&user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if verified {
+		if err := r.MarkVerified(&user); err != nil {
+			t.Fatalf("verify user: %v", err)
+		}
+	}
+	return &user
+}
+
+func TestProvisionUnverifiedAccount(t *testing.T) {
+	r := NewMemoryRepository(database.NewMemory())
+
+	// someone registered the mail of the victim before the victim ever logged in
+	squatter := register(t, r, "squatter", "victim@example.com", false)
+
+	_, _, err := Provision(r, profile("victim@example.com", true))
+	if !errors.Is(err, ErrMailTaken) {
+		t.Fatalf("expected %v, got %v", ErrMailTaken, err)
+	}
+
+	stored, err := r.Read(squatter.ID)
+	if err != nil {
+		t.Fatalf("read user: %v", err)
+	}
+	if len(stored.Identities) != 0 || stored.Verified {
+		t.Fatalf("the account must be left alone, got %+v", stored)
+	}
+	if _, err := r.ReadByIdentity("https://id.example.com", "victim"); err == nil {
+		t.Fatal("the identity must not be linked")
+	}
+}
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+		verified bool
+		profile  Profile
+		created  bool
+		err      error
+	}{
+		{"first login", false, false, profile("new@example.com", true), true, nil},
+		{"verified account", true, true, profile("user@example.com", true), false, nil},
+		{"mail not verified by the provider", true, true, profile("user@example.com", false), false, ErrMailTaken},
+		{"account not verified", true, false, profile("user@example.com", true), false, ErrMailTaken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewMemoryRepository(database.NewMemory())
+			if tt.existing {
+				register(t, r, "user", "user@example.com", tt.verified)
+			}
+
+			user, created, err := Provision(r, tt.profile)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if created != tt.created {
+				t.Fatalf("expected created %v, got %v", tt.created, created)
+			}
+
+			// the next login finds the user by its identity
+			again, created, err := Provision(r, tt.profile)
+			if err != nil || created || again.ID != user.ID {
+				t.Fatalf("expected the same user, got %+v %v %v", again, created, err)
+			}
+		})
+	}
+}
+
+// TestIdentityLinkedOnce adds the identity without the check of Link, like two links running together
+func TestIdentityLinkedOnce(t *testing.T) {
+	r := NewMemoryRepository(database.NewMemory())
+	first := register(t, r, "first", "first@example.com", true)
+	second := register(t, r, "second", "second@example.com", true)
+	p := profile("first@example.com", true)
+
+	if err := r.AddIdentity(first, p.Identity()); err != nil {
+		t.Fatalf("add identity: %v", err)
+	}
+	if err := r.AddIdentity(second, p.Identity()); !errors.Is(err, ErrIdentityTaken) {
+		t.Fatalf("expected %v, got %v", ErrIdentityTaken, err)
+	}
+	if err := Link(r, second, p); !errors.Is(err, ErrIdentityTaken) {
+		t.Fatalf("expected %v, got %v", ErrIdentityTaken, err)
+	}
+
+	// another subject of the same issuer is free
+	p.Subject = "other"
+	if err := r.AddIdentity(second, p.Identity()); err != nil {
+		t.Fatalf("add identity: %v", err)
+	}
+}
@@ -3,25 +3,101 @@ package user
 import (
 	"context"
 	"errors"
+	"harmony/internal/database"
 	"harmony/utils"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const defaultTimeout = 5 * time.Second
 
-type Repository struct {
+// ErrUniqueNameTaken is returned when another user created at the same time got the same unique name
+var ErrUniqueNameTaken = errors.New("unique name already taken")
+
+// Repository stores the users together with their credentials and one time tokens
+type Repository interface {
+	IsMailUsed(mail string) (bool, error)
+	Create(user *User) error
+	Read(id primitive.ObjectID) (*User, error)
+	ReadByUniqueName(uniqueName string) (*User, error)
+	ReadByMail(mail string) (*User, error)
+	ReadBots(ownerId string) ([]User, error)
+	ReadByIdentity(issuer string, subject string) (*User, error)
+	AddIdentity(user *User, identity Identity) error
+	RemoveIdentity(user *User, issuer string, subject string) (bool, error)
+	Update(user *User) error
+	UpdatePassword(user *User) error
+	RecordLoginFailure(user *User) error
+	ResetLoginFailures(user *User) error
+	CreateResetToken(user *User) (string, error)
+	ReadByResetToken(token string) (*User, error)
+	CreateVerifyToken(user *User) (string, error)
+	ReadByVerifyToken(token string) (*User, error)
+	MarkVerified(user *User) error
+	Delete(id primitive.ObjectID) (bool, error)
+}
+
+type MongoRepository struct {
 	db *mongo.Database
 }
 
-func NewRepository(db *mongo.Client) *Repository {
-	return &Repository{db: db.Database("harmony")}
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{db: db.Database("harmony")}
 }
 
-func (r *Repository) IsMailUsed(mail string) (bool, error) {
+// EnsureIndexes creates the indexes of the users, the unique ones guard against concurrent creations.
+// Bots have no mail so the mail is unique only among the users that have one, and an identity
+// can be linked to a single user
+func (r *MongoRepository) EnsureIndexes() error {
+	cUsers := r.db.Collection("users")
+	cUserCodes := r.db.Collection("user_codes")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cUsers, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "mail", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"mail": bson.M{"$gt": ""}})},
+		{Keys: bson.D{{Key: "unique_name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: identitiesKeys, Options: identitiesOptions()},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = database.EnsureIndexes(ctx, cUserCodes, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	return err
+}
+
+var identitiesKeys = bson.D{{Key: "identities.issuer", Value: 1}, {Key: "identities.subject", Value: 1}}
+
+// identitiesOptions makes the identities unique among the users that have one
+func identitiesOptions() *options.IndexOptions {
+	return options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"identities.issuer": bson.M{"$exists": true}})
+}
+
+// duplicateKey turns the duplicate key errors of the unique indexes into the errors of the taken fields
+func duplicateKey(err error) error {
+	index, ok := database.DuplicateKeyIndex(err)
+	if !ok {
+		return err
+	}
+	switch index {
+	case "mail_1":
+		return ErrMailTaken
+	case "identities.issuer_1_identities.subject_1":
+		return ErrIdentityTaken
+	}
+	return ErrUniqueNameTaken
+}
+
+func (r *MongoRepository) IsMailUsed(mail string) (bool, error) {
 	cUsers := r.db.Collection("users")
 	var user User
 
@@ -41,7 +117,7 @@ func (r *Repository) IsMailUsed(mail string) (bool, error) {
 	return true, nil
 }
 
-func (r *Repository) Create(user *User) error {
+func (r *MongoRepository) Create(user *User) error {
 	cUserCodes := r.db.Collection("user_codes")
 	cUsers := r.db.Collection("users")
 
@@ -68,7 +144,7 @@ func (r *Repository) Create(user *User) error {
 			"codes": []int{newCode},
 		})
 		if err != nil {
-			return err
+			return duplicateKey(err)
 		}
 	} else {
 		update := bson.M{
@@ -84,13 +160,27 @@ func (r *Repository) Create(user *User) error {
 
 	// creates new user
 	user.GenerateUniqueName(newCode)
-	result, err := cUsers.InsertOne(ctx, bson.M{
-		"name":        user.Name,
-		"mail":        user.Mail,
-		"unique_name": user.UniqueName,
-	})
+	document := bson.M{
+		"name":         user.Name,
+		"display_name": user.DisplayName,
+		"unique_name":  user.UniqueName,
+		"identities":   user.Identities,
+		"verified":     user.Verified,
+		"bot":          user.Bot,
+	}
+	// bots have no mail, leaving it out keeps them out of the mail lookups
+	if user.Mail != "" {
+		document["mail"] = user.Mail
+	}
+	if user.OwnerID != "" {
+		document["owner_id"] = user.OwnerID
+	}
+	if user.HasPassword() {
+		document["password_hash"] = user.PasswordHash
+	}
+	result, err := cUsers.InsertOne(ctx, document)
 	if err != nil {
-		return err
+		return duplicateKey(err)
 	}
 
 	user.ID = result.InsertedID.(primitive.ObjectID)
@@ -98,7 +188,7 @@ func (r *Repository) Create(user *User) error {
 	return nil
 }
 
-func (r *Repository) Read(id primitive.ObjectID) (*User, error) {
+func (r *MongoRepository) Read(id primitive.ObjectID) (*User, error) {
 	cUsers := r.db.Collection("users")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -113,7 +203,7 @@ func (r *Repository) Read(id primitive.ObjectID) (*User, error) {
 	return &user, nil
 }
 
-func (r *Repository) ReadByUniqueName(uniqueName string) (*User, error) {
+func (r *MongoRepository) ReadByUniqueName(uniqueName string) (*User, error) {
 	cUsers := r.db.Collection("users")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -128,7 +218,7 @@ func (r *Repository) ReadByUniqueName(uniqueName string) (*User, error) {
 	return &user, nil
 }
 
-func (r *Repository) ReadByMail(mail string) (*User, error) {
+func (r *MongoRepository) ReadByMail(mail string) (*User, error) {
 	cUsers := r.db.Collection("users")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -143,7 +233,106 @@ func (r *Repository) ReadByMail(mail string) (*User, error) {
 	return &user, nil
 }
 
-func (r *Repository) Update(user *User) error {
+// ReadBots returns the bots owned by the user
+func (r *MongoRepository) ReadBots(ownerId string) ([]User, error) {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	cursor, err := cUsers.Find(ctx, bson.M{"bot": true, "owner_id": ownerId})
+	if err != nil {
+		return nil, err
+	}
+
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *MongoRepository) ReadByIdentity(issuer string, subject string) (*User, error) {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}},
+	}
+	var user User
+	err := cUsers.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *MongoRepository) AddIdentity(user *User, identity Identity) error {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": user.ID,
+		"identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"issuer":  identity.Issuer,
+			"subject": identity.Subject,
+		}}},
+	}
+	update := bson.M{
+		"$push": bson.M{"identities": identity},
+	}
+	_, err := cUsers.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return duplicateKey(err)
+	}
+
+	if !user.HasIdentity(identity.Issuer, identity.Subject) {
+		user.Identities = append(user.Identities, identity)
+	}
+
+	return nil
+}
+
+// RemoveIdentity unlinks the identity, it doesn't remove the last one so the user can still log in
+func (r *MongoRepository) RemoveIdentity(user *User, issuer string, subject string) (bool, error) {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":          user.ID,
+		"identities.1": bson.M{"$exists": true},
+	}
+	update := bson.M{
+		"$pull": bson.M{"identities": bson.M{"issuer": issuer, "subject": subject}},
+	}
+	result, err := cUsers.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	identities := []Identity{}
+	for _, identity := range user.Identities {
+		if identity.Issuer != issuer || identity.Subject != subject {
+			identities = append(identities, identity)
+		}
+	}
+	user.Identities = identities
+
+	return true, nil
+}
+
+func (r *MongoRepository) Update(user *User) error {
 	cUsers := r.db.Collection("users")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
@@ -154,8 +343,8 @@ func (r *Repository) Update(user *User) error {
 	// - unique name
 	update := bson.M{
 		"$set": bson.M{
-			"name":    user.Name,
-			"servers": user.Servers,
+			"name":         user.Name,
+			"display_name": user.DisplayName,
 		},
 	}
 	_, err := cUsers.UpdateByID(ctx, user.ID, update)
@@ -166,20 +355,220 @@ func (r *Repository) Update(user *User) error {
 	return nil
 }
 
-func (r *Repository) Delete(id primitive.ObjectID) (bool, error) {
+// UpdatePassword stores the hash set by User.SetPassword
+func (r *MongoRepository) UpdatePassword(user *User) error {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"password_hash": user.PasswordHash,
+			"failed_logins": 0,
+		},
+		"$unset": bson.M{
+			"locked_until":     "",
+			"reset_hash":       "",
+			"reset_expires_at": "",
+		},
+	}
+	_, err := cUsers.UpdateByID(ctx, user.ID, update)
+	return err
+}
+
+// RecordLoginFailure counts a wrong password, reaching MaxFailedLogins locks the user
+func (r *MongoRepository) RecordLoginFailure(user *User) error {
 	cUsers := r.db.Collection("users")
 
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
-	result, err := cUsers.DeleteOne(ctx, bson.M{"_id": id})
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := cUsers.FindOneAndUpdate(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$inc": bson.M{"failed_logins": 1},
+	}, opts).Decode(user)
 	if err != nil {
-		return false, err
+		return err
+	}
+	if user.FailedLogins < MaxFailedLogins {
+		return nil
 	}
 
-	if result.DeletedCount == 0 {
+	lockedUntil := time.Now().Add(LockoutDuration)
+	_, err = cUsers.UpdateByID(ctx, user.ID, bson.M{
+		"$set": bson.M{
+			"failed_logins": 0,
+			"locked_until":  lockedUntil,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	user.FailedLogins = 0
+	user.LockedUntil = &lockedUntil
+
+	return nil
+}
+
+func (r *MongoRepository) ResetLoginFailures(user *User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cUsers.UpdateByID(ctx, user.ID, bson.M{
+		"$set":   bson.M{"failed_logins": 0},
+		"$unset": bson.M{"locked_until": ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+
+	return nil
+}
+
+// CreateResetToken stores the hash of a new password reset token and returns the token
+func (r *MongoRepository) CreateResetToken(user *User) (string, error) {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	token := utils.GetRandomToken(32)
+	expiresAt := time.Now().Add(ResetTTL)
+	_, err := cUsers.UpdateByID(ctx, user.ID, bson.M{
+		"$set": bson.M{
+			"reset_hash":       utils.HashToken(token),
+			"reset_expires_at": expiresAt,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	user.ResetHash = utils.HashToken(token)
+	user.ResetExpiresAt = &expiresAt
+
+	return token, nil
+}
+
+func (r *MongoRepository) ReadByResetToken(token string) (*User, error) {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"reset_hash":       utils.HashToken(token),
+		"reset_expires_at": bson.M{"$gt": time.Now()},
+	}
+	var user User
+	err := cUsers.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateVerifyToken stores the hash of a new mail verification token and returns the token
+func (r *MongoRepository) CreateVerifyToken(user *User) (string, error) {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	token := utils.GetRandomToken(32)
+	expiresAt := time.Now().Add(VerifyTTL)
+	_, err := cUsers.UpdateByID(ctx, user.ID, bson.M{
+		"$set": bson.M{
+			"verify_hash":       utils.HashToken(token),
+			"verify_expires_at": expiresAt,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	user.VerifyHash = utils.HashToken(token)
+	user.VerifyExpiresAt = &expiresAt
+
+	return token, nil
+}
+
+func (r *MongoRepository) ReadByVerifyToken(token string) (*User, error) {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{
+		"verify_hash":       utils.HashToken(token),
+		"verify_expires_at": bson.M{"$gt": time.Now()},
+	}
+	var user User
+	err := cUsers.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *MongoRepository) MarkVerified(user *User) error {
+	cUsers := r.db.Collection("users")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cUsers.UpdateByID(ctx, user.ID, bson.M{
+		"$set": bson.M{"verified": true},
+		"$unset": bson.M{
+			"verify_hash":       "",
+			"verify_expires_at": "",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	user.Verified = true
+	user.VerifyHash = ""
+	user.VerifyExpiresAt = nil
+
+	return nil
+}
+
+func (r *MongoRepository) Delete(id primitive.ObjectID) (bool, error) {
+	cUsers := r.db.Collection("users")
+	cMembers := r.db.Collection("members")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var user User
+	err := cUsers.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		return false, nil
 	}
+	if err != nil {
+		return false, err
+	}
+
+	// the user leaves all the servers
+	_, err = cMembers.DeleteMany(ctx, bson.M{"user_id": user.UniqueName})
+	if err != nil {
+		return true, err
+	}
 
 	return true, nil
 }
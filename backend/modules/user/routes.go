@@ -5,11 +5,31 @@ import (
 )
 
 func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/@me", h.ReadMe)
+	r.POST("/@me/verify", h.ResendVerification)
 	r.GET("/:id", h.Read)
 	r.PATCH("/:id", h.Update)
 	r.DELETE("/:id", h.Delete)
 }
 
+// RegisterAccountRoutes registers the routes managing the credentials of the user, under /users/@me
+func RegisterAccountRoutes(r *gin.RouterGroup, h *Handler) {
+	r.GET("/identities", h.ListIdentities)
+	r.DELETE("/identities", h.UnlinkIdentity)
+	r.PUT("/password", h.ChangePassword)
+	r.GET("/bots", h.ListBots)
+	r.POST("/bots", h.CreateBot)
+}
+
 func RegisterRoutesNoAuth(r *gin.RouterGroup, h *Handler) {
 	r.POST("/", h.Create)
 }
+
+func RegisterPasswordRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/reset", h.RequestReset)
+	r.POST("/reset/confirm", h.ConfirmReset)
+}
+
+func RegisterVerifyRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/", h.Verify)
+}
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// allowLocal lets the receivers run on the local machine over plain http, for development only
+var allowLocal = os.Getenv("WEBHOOK_ALLOW_LOCAL") == "true"
+
+var ErrAddressNotAllowed = errors.New("address not allowed")
+
+// reserved are the ranges net.IP has no method for, none of them is reachable on the internet
+var reserved = []*net.IPNet{
+	mustCIDR("0.0.0.0/8"),
+	mustCIDR("100.64.0.0/10"),
+	mustCIDR("192.0.0.0/24"),
+	mustCIDR("198.18.0.0/15"),
+	mustCIDR("240.0.0.0/4"),
+	mustCIDR("64:ff9b::/96"),
+}
+
+func mustCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// IsPublicIP tells if the ip is routed on the internet, private, loopback and link-local
+// addresses would let a receiver URL reach the hosts next to the backend
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range reserved {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialer connects only to public addresses, the host is resolved here and the checked ip is
+// the one dialed, so a DNS answer changing between the check and the connection can't get around it
+type dialer struct {
+	net.Dialer
+	allowLoopback bool
+}
+
+func (d *dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP) && !(d.allowLoopback && addr.IP.IsLoopback()) {
+			return nil, fmt.Errorf("%s resolves to %s: %w", host, addr.IP, ErrAddressNotAllowed)
+		}
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := d.Dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// NewClient returns the client posting to the URLs given by the users, it doesn't follow redirects
+// and refuses to connect to addresses that are not public
+func NewClient(timeout time.Duration) *http.Client {
+	return newClient(timeout, allowLocal)
+}
+
+func newClient(timeout time.Duration, allowLoopback bool) *http.Client {
+	d := &dialer{
+		Dialer:        net.Dialer{Timeout: timeout},
+		allowLoopback: allowLoopback,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		// the proxy would dial in our place without the check
+		Transport: &http.Transport{
+			DialContext:         d.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		// a redirect could point anywhere, the receiver has to answer itself
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
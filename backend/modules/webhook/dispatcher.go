@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harmony/internal/event"
+	"harmony/utils"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	workers      = 4
+	pollInterval = 2 * time.Second
+	sendTimeout  = 10 * time.Second
+	// claimLock must be longer than sendTimeout so a delivery is never sent twice at once
+	claimLock = 30 * time.Second
+)
+
+// Dispatcher turns the bus events into deliveries and sends them in the background,
+// deliveries are stored so any instance can retry them
+type Dispatcher struct {
+	Repo   Repository
+	client *http.Client
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(repo Repository) *Dispatcher {
+	return &Dispatcher{
+		Repo:   repo,
+		client: NewClient(sendTimeout),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	for range workers {
+		d.wg.Add(1)
+		go d.work(ctx)
+	}
+}
+
+func (d *Dispatcher) Close() error {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+	return nil
+}
+
+// HandleEvent is subscribed to the bus, the deliveries are enqueued outside of the publisher request
+func (d *Dispatcher) HandleEvent(evt event.Event) {
+	if !utils.Contains(Events, evt.Type) {
+		return
+	}
+	// messages of private channels stay with the members who can read them
+	if evt.Audience != nil || evt.Excluded != nil {
+		return
+	}
+
+	go func() {
+		if err := d.enqueue(evt); err != nil {
+			log.Printf("webhook: failed to enqueue %s %s: %v", evt.Type, evt.ID, err)
+		}
+	}()
+}
+
+func (d *Dispatcher) enqueue(evt event.Event) error {
+	serverId, err := primitive.ObjectIDFromHex(evt.ServerID)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := d.Repo.ReadByServer(serverId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(map[string]any{
+		"id":         evt.ID,
+		"type":       evt.Type,
+		"server_id":  evt.ServerID,
+		"data":       evt.Data,
+		"created_at": now,
+	})
+	if err != nil {
+		return err
+	}
+
+	isQueued := false
+	for _, webhook := range webhooks {
+		if !webhook.Wants(evt.Type) {
+			continue
+		}
+		isCreated, err := d.Repo.CreateDelivery(&Delivery{
+			WebhookID:     webhook.ID,
+			EventID:       evt.ID,
+			Event:         evt.Type,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+			LockedUntil:   now,
+		})
+		if err != nil {
+			return err
+		}
+		isQueued = isQueued || isCreated
+	}
+
+	if evt.Type == event.ServerDeleted {
+		if err := d.Repo.MarkServerDeleted(serverId); err != nil {
+			return err
+		}
+	}
+
+	if isQueued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		delivery, err := d.Repo.ClaimDelivery(claimLock)
+		if err == nil {
+			d.attempt(delivery)
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("webhook: failed to claim delivery: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// attempt sends the delivery once and schedules the retry when it fails
+func (d *Dispatcher) attempt(delivery *Delivery) {
+	webhook, err := d.Repo.Read(delivery.WebhookID)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("webhook: failed to read webhook %s: %v", delivery.WebhookID.Hex(), err)
+			return
+		}
+		delivery.Status = DeliveryFailed
+		delivery.Error = "webhook deleted"
+		d.save(delivery)
+		return
+	}
+	if !webhook.Enabled {
+		delivery.Status = DeliveryFailed
+		delivery.Error = "webhook disabled"
+		d.save(delivery)
+		return
+	}
+
+	start := time.Now()
+	statusCode, err := d.send(webhook, delivery)
+	delivery.Attempts++
+	delivery.Duration = time.Since(start)
+	delivery.StatusCode = statusCode
+	delivery.Error = ""
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	success := err == nil
+	switch {
+	case success:
+		delivery.Status = DeliverySucceeded
+	case delivery.Attempts >= MaxAttempts:
+		delivery.Status = DeliveryFailed
+	default:
+		delivery.NextAttemptAt = time.Now().Add(Backoff(delivery.Attempts))
+	}
+	delivery.LockedUntil = time.Now()
+	d.save(delivery)
+
+	// only the final outcome counts toward disabling the webhook
+	if delivery.Status == DeliveryPending {
+		return
+	}
+	if err := d.Repo.RecordResult(webhook, success); err != nil {
+		log.Printf("webhook: failed to record result of %s: %v", webhook.ID.Hex(), err)
+	}
+	if !webhook.Enabled {
+		log.Printf("webhook: %s disabled after %d failed deliveries", webhook.ID.Hex(), MaxFailures)
+	}
+}
+
+func (d *Dispatcher) send(webhook *Webhook, delivery *Delivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Harmony-Webhook/1.0")
+	req.Header.Set("X-Harmony-Event", delivery.Event)
+	req.Header.Set("X-Harmony-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-Harmony-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Harmony-Signature", "sha256="+webhook.Sign(timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) save(delivery *Delivery) {
+	if err := d.Repo.UpdateDelivery(delivery); err != nil {
+		log.Printf("webhook: failed to update delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
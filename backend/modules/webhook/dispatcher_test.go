@@ -0,0 +1,229 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"harmony/internal/database"
+	"harmony/internal/event"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records the requests and answers with the status
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []request
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, request{header: req.Header, body: body})
+	w.WriteHeader(r.status)
+}
+
+// newDispatcher returns a dispatcher with a webhook posting to the receiver, loopback is allowed for httptest
+func newDispatcher(t *testing.T, status int) (*Dispatcher, *Webhook, *receiver) {
+	t.Helper()
+
+	rcv := &receiver{status: status}
+	srv := httptest.NewServer(rcv)
+	t.Cleanup(srv.Close)
+
+	repo := NewMemoryRepository(database.NewMemory())
+	webhook := NewWebhook(primitive.NewObjectID(), srv.URL, []string{event.MessageCreated}, "owner")
+	if err := repo.Create(&webhook); err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+
+	d := NewDispatcher(repo)
+	d.client = newClient(sendTimeout, true)
+	return d, &webhook, rcv
+}
+
+// deliver enqueues a message for the webhook and attempts it once
+func deliver(t *testing.T, d *Dispatcher, webhook *Webhook) *Delivery {
+	t.Helper()
+
+	evt := event.Event{ID: primitive.NewObjectID().Hex(), Type: event.MessageCreated, ServerID: webhook.ServerID.Hex(), Data: map[string]any{"content": "hello"}}
+	if err := d.enqueue(evt); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	return attempt(t, d)
+}
+
+// attempt claims the next delivery due and sends it
+func attempt(t *testing.T, d *Dispatcher) *Delivery {
+	t.Helper()
+
+	delivery, err := d.Repo.ClaimDelivery(claimLock)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	d.attempt(delivery)
+	return delivery
+}
+
+func TestDeliverySignature(t *testing.T) {
+	d, webhook, rcv := newDispatcher(t, http.StatusNoContent)
+
+	delivery := deliver(t, d, webhook)
+	if delivery.Status != DeliverySucceeded || delivery.StatusCode != http.StatusNoContent || delivery.Attempts != 1 {
+		t.Fatalf("expected a successful delivery, got %+v", delivery)
+	}
+	if len(rcv.requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(rcv.requests))
+	}
+
+	req := rcv.requests[0]
+	if req.header.Get("X-Harmony-Event") != event.MessageCreated || req.header.Get("X-Harmony-Delivery") != delivery.ID.Hex() {
+		t.Fatalf("unexpected headers %v", req.header)
+	}
+	timestamp, err := strconv.ParseInt(req.header.Get("X-Harmony-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp: %v", err)
+	}
+
+	// what a receiver does with the secret
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	fmt.Fprintf(mac, "%d.%s", timestamp, req.body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if req.header.Get("X-Harmony-Signature") != expected {
+		t.Fatalf("expected signature %s, got %s", expected, req.header.Get("X-Harmony-Signature"))
+	}
+}
+
+func TestDeliveryRetry(t *testing.T) {
+	d, webhook, rcv := newDispatcher(t, http.StatusInternalServerError)
+
+	delivery := deliver(t, d, webhook)
+	if delivery.Status != DeliveryPending || delivery.Attempts != 1 || delivery.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected a retry, got %+v", delivery)
+	}
+	wait := time.Until(delivery.NextAttemptAt)
+	if wait <= Backoff(1)-time.Second || wait > Backoff(1) {
+		t.Fatalf("expected the next attempt in %v, got %v", Backoff(1), wait)
+	}
+	if _, err := d.Repo.ClaimDelivery(claimLock); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("the delivery must wait the backoff, got %v", err)
+	}
+
+	// skip the waits until the last attempt
+	for delivery.Status == DeliveryPending {
+		delivery.NextAttemptAt = time.Now()
+		if err := d.Repo.UpdateDelivery(delivery); err != nil {
+			t.Fatalf("update delivery: %v", err)
+		}
+		delivery = attempt(t, d)
+	}
+
+	if delivery.Status != DeliveryFailed || delivery.Attempts != MaxAttempts || len(rcv.requests) != MaxAttempts {
+		t.Fatalf("expected to fail after %d attempts, got %+v", MaxAttempts, delivery)
+	}
+	stored, _ := d.Repo.Read(webhook.ID)
+	if stored.Failures != 1 || !stored.Enabled {
+		t.Fatalf("expected one failure recorded, got %+v", stored)
+	}
+
+	// a success resets the failures
+	rcv.mu.Lock()
+	rcv.status = http.StatusOK
+	rcv.mu.Unlock()
+	deliver(t, d, webhook)
+	stored, _ = d.Repo.Read(webhook.ID)
+	if stored.Failures != 0 {
+		t.Fatalf("expected the failures reset, got %d", stored.Failures)
+	}
+}
+
+func TestDeliveryAutoDisable(t *testing.T) {
+	d, webhook, rcv := newDispatcher(t, http.StatusBadGateway)
+
+	for range MaxFailures {
+		delivery := deliver(t, d, webhook)
+		// the failures count only once the delivery gave up
+		delivery.Attempts = MaxAttempts - 1
+		delivery.NextAttemptAt = time.Now()
+		if err := d.Repo.UpdateDelivery(delivery); err != nil {
+			t.Fatalf("update delivery: %v", err)
+		}
+		attempt(t, d)
+	}
+
+	stored, _ := d.Repo.Read(webhook.ID)
+	if stored.Enabled || stored.Failures != MaxFailures {
+		t.Fatalf("expected the webhook disabled, got %+v", stored)
+	}
+
+	// a disabled webhook gets no more deliveries
+	sent := len(rcv.requests)
+	evt := event.Event{ID: primitive.NewObjectID().Hex(), Type: event.MessageCreated, ServerID: webhook.ServerID.Hex()}
+	if err := d.enqueue(evt); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := d.Repo.ClaimDelivery(claimLock); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("expected no delivery, got %v", err)
+	}
+	if len(rcv.requests) != sent {
+		t.Fatalf("expected no request to the disabled webhook")
+	}
+}
+
+func TestClientRejectsInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(&receiver{status: http.StatusOK})
+	defer srv.Close()
+
+	client := newClient(time.Second, false)
+	for _, url := range []string{srv.URL, "http://169.254.169.254/latest/meta-data", "http://10.0.0.5/", "http://[::1]:80/", "http://localhost/"} {
+		_, err := client.Get(url)
+		if !errors.Is(err, ErrAddressNotAllowed) {
+			t.Errorf("expected %s to be rejected, got %v", url, err)
+		}
+	}
+}
+
+func TestIsURLValid(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://example.com/hook", true},
+		{"http://example.com/hook", false},
+		{"https://169.254.169.254/", false},
+		{"https://10.0.0.5/", false},
+		{"https://192.168.1.1/", false},
+		{"https://[fe80::1]/", false},
+		{"https://localhost/", false},
+		{"http://127.0.0.1:8080/", false},
+		{"https://8.8.8.8/", true},
+		{"ftp://example.com/", false},
+		{"not a url", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if valid, errMsg := IsURLValid(tt.url); valid != tt.valid {
+				t.Errorf("expected %v, got %v (%s)", tt.valid, valid, errMsg)
+			}
+		})
+	}
+}
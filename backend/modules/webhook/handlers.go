@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"harmony/modules/server"
+	"harmony/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Handler struct {
+	Repo       Repository
+	Membership *server.Membership
+}
+
+func NewHandler(repo Repository, membership *server.Membership) *Handler {
+	return &Handler{
+		Repo:       repo,
+		Membership: membership,
+	}
+}
+
+// manageable resolves the server of the path checking the user can manage its webhooks
+func (h *Handler) manageable(c *gin.Context) (*server.Server, bool) {
+	id := c.Param("id")
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return nil, false
+	}
+
+	// validate input
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	// search server
+	srv, member, err := h.Membership.Member(objectId, sub)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive server"})
+		return nil, false
+	}
+
+	// permission check
+	if !srv.Permissions(member).Has(server.PermissionManageWebhooks) {
+		c.Status(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return srv, true
+}
+
+// webhook resolves the :webhookId of the path inside the server
+func (h *Handler) webhook(c *gin.Context, srv *server.Server) (*Webhook, bool) {
+	webhookObjectId, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	webhook, err := h.Repo.Read(webhookObjectId)
+	if err != nil || webhook.ServerID != srv.ID || webhook.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to retreive webhook"})
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	type RequestBody struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	var rb RequestBody
+
+	sub, ok := utils.GetSub(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to retreive user"})
+		return
+	}
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if check, errMsg := IsURLValid(rb.URL); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+	if check, errMsg := IsEventsValid(rb.Events); !check {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	srv, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.Repo.ReadByServer(srv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive webhooks"})
+		return
+	}
+	if len(webhooks) >= MaxWebhooks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many webhooks"})
+		return
+	}
+
+	// create
+	webhook := NewWebhook(srv.ID, rb.URL, rb.Events, sub)
+
+	err = h.Repo.Create(&webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	// the secret is shown only now, receivers need it to check the signatures
+	result := webhook.Print()
+	result["secret"] = webhook.Secret
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func (h *Handler) List(c *gin.Context) {
+	srv, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.Repo.ReadByServer(srv.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive webhooks"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		result = append(result, webhook.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) Update(c *gin.Context) {
+	type RequestBody struct {
+		URL     *string  `json:"url"`
+		Events  []string `json:"events"`
+		Enabled *bool    `json:"enabled"`
+	}
+	var rb RequestBody
+
+	// validate input
+	if err := c.ShouldBindJSON(&rb); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rb.URL != nil {
+		if check, errMsg := IsURLValid(*rb.URL); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+	if rb.Events != nil {
+		if check, errMsg := IsEventsValid(rb.Events); !check {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+	}
+
+	srv, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+	webhook, ok := h.webhook(c, srv)
+	if !ok {
+		return
+	}
+
+	// update data
+	if rb.URL != nil {
+		webhook.URL = *rb.URL
+	}
+	if rb.Events != nil {
+		webhook.Events = rb.Events
+	}
+	if rb.Enabled != nil {
+		// enabling again starts counting the failures from zero
+		if *rb.Enabled && !webhook.Enabled {
+			webhook.Failures = 0
+		}
+		webhook.Enabled = *rb.Enabled
+	}
+
+	err := h.Repo.Update(webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook.Print())
+}
+
+func (h *Handler) Delete(c *gin.Context) {
+	srv, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+	webhook, ok := h.webhook(c, srv)
+	if !ok {
+		return
+	}
+
+	_, err := h.Repo.Delete(webhook.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListDeliveries(c *gin.Context) {
+	srv, ok := h.manageable(c)
+	if !ok {
+		return
+	}
+	webhook, ok := h.webhook(c, srv)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.Repo.ReadDeliveries(webhook.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retreive deliveries"})
+		return
+	}
+
+	result := make([]map[string]any, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		result = append(result, delivery.Print())
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,232 @@
+package webhook
+
+import (
+	"harmony/internal/database"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRepository keeps the webhooks and their deliveries in memory, it behaves like MongoRepository
+// but the webhooks of deleted servers are only hidden as there is no TTL index
+type MemoryRepository struct {
+	mem *database.Memory
+}
+
+func NewMemoryRepository(mem *database.Memory) *MemoryRepository {
+	mem.Unique("webhook_deliveries", "webhook_id", "event_id")
+	return &MemoryRepository{
+		mem: mem,
+	}
+}
+
+func (r *MemoryRepository) Create(webhook *Webhook) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		id, err := tx.Insert("webhooks", webhook)
+		if err != nil {
+			return err
+		}
+		webhook.ID = id
+
+		return nil
+	})
+}
+
+func (r *MemoryRepository) Read(id primitive.ObjectID) (*Webhook, error) {
+	var webhook Webhook
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.FindOne("webhooks", bson.M{"_id": id}, &webhook)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (r *MemoryRepository) ReadByServer(serverId primitive.ObjectID) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("webhooks", bson.M{"server_id": serverId, "deleted_at": nil}, &webhooks)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+
+	return webhooks, nil
+}
+
+func (r *MemoryRepository) Update(webhook *Webhook) error {
+	return r.modify(webhook.ID, func(stored *Webhook) {
+		stored.URL = webhook.URL
+		stored.Events = webhook.Events
+		stored.Enabled = webhook.Enabled
+		stored.Failures = webhook.Failures
+	})
+}
+
+func (r *MemoryRepository) Delete(id primitive.ObjectID) (bool, error) {
+	deleted := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		if tx.Delete("webhooks", bson.M{"_id": id}) == 0 {
+			return nil
+		}
+		deleted = true
+		tx.Delete("webhook_deliveries", bson.M{"webhook_id": id})
+		return nil
+	})
+
+	return deleted, err
+}
+
+func (r *MemoryRepository) MarkServerDeleted(serverId primitive.ObjectID) error {
+	now := time.Now()
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var webhooks []Webhook
+		if err := tx.Find("webhooks", bson.M{"server_id": serverId, "deleted_at": nil}, &webhooks); err != nil {
+			return err
+		}
+		for _, webhook := range webhooks {
+			webhook.DeletedAt = &now
+			if _, err := tx.Replace("webhooks", webhook.ID, webhook); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRepository) RecordResult(webhook *Webhook, success bool) error {
+	if success && webhook.Failures == 0 {
+		return nil
+	}
+
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Webhook
+		found, err := tx.Modify("webhooks", webhook.ID, &stored, func() {
+			if success {
+				stored.Failures = 0
+				return
+			}
+			stored.Failures++
+			if stored.Failures >= MaxFailures {
+				stored.Enabled = false
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return mongo.ErrNoDocuments
+		}
+
+		*webhook = stored
+		return nil
+	})
+}
+
+func (r *MemoryRepository) modify(id primitive.ObjectID, fn func(stored *Webhook)) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Webhook
+		_, err := tx.Modify("webhooks", id, &stored, func() {
+			fn(&stored)
+		})
+		return err
+	})
+}
+
+func (r *MemoryRepository) CreateDelivery(delivery *Delivery) (bool, error) {
+	created := false
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		// the same (webhook_id, event_id) unique index as mongo
+		var existing Delivery
+		err := tx.FindOne("webhook_deliveries", bson.M{"webhook_id": delivery.WebhookID, "event_id": delivery.EventID}, &existing)
+		if err == nil {
+			return nil
+		}
+
+		id, err := tx.Insert("webhook_deliveries", delivery)
+		if err != nil {
+			return err
+		}
+		delivery.ID = id
+		created = true
+
+		return nil
+	})
+
+	return created, err
+}
+
+func (r *MemoryRepository) ClaimDelivery(lock time.Duration) (*Delivery, error) {
+	var claimed *Delivery
+	err := r.mem.Update(func(tx *database.MemoryTx) error {
+		var deliveries []Delivery
+		if err := tx.Find("webhook_deliveries", bson.M{"status": DeliveryPending}, &deliveries); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			if delivery.NextAttemptAt.After(now) || delivery.LockedUntil.After(now) {
+				continue
+			}
+			if claimed == nil || delivery.NextAttemptAt.Before(claimed.NextAttemptAt) {
+				claimed = delivery
+			}
+		}
+		if claimed == nil {
+			return mongo.ErrNoDocuments
+		}
+
+		claimed.LockedUntil = now.Add(lock)
+		_, err := tx.Replace("webhook_deliveries", claimed.ID, claimed)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+func (r *MemoryRepository) UpdateDelivery(delivery *Delivery) error {
+	return r.mem.Update(func(tx *database.MemoryTx) error {
+		var stored Delivery
+		_, err := tx.Modify("webhook_deliveries", delivery.ID, &stored, func() {
+			stored.Status = delivery.Status
+			stored.Attempts = delivery.Attempts
+			stored.StatusCode = delivery.StatusCode
+			stored.Error = delivery.Error
+			stored.Duration = delivery.Duration
+			stored.NextAttemptAt = delivery.NextAttemptAt
+			stored.LockedUntil = delivery.LockedUntil
+		})
+		return err
+	})
+}
+
+func (r *MemoryRepository) ReadDeliveries(webhookId primitive.ObjectID) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := r.mem.View(func(tx *database.MemoryTx) error {
+		return tx.Find("webhook_deliveries", bson.M{"webhook_id": webhookId}, &deliveries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	if len(deliveries) > DeliveryLogSize {
+		deliveries = deliveries[:DeliveryLogSize]
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"harmony/internal/event"
+	"harmony/utils"
+	"net"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxWebhooks is how many webhooks a server can have
+	MaxWebhooks = 10
+	// MaxAttempts is how many times a delivery is tried before it fails
+	MaxAttempts = 6
+	// MaxFailures is how many failed deliveries in a row disable the webhook
+	MaxFailures = 5
+	secretSize  = 32
+)
+
+// Events webhooks can subscribe to
+var Events = []string{
+	event.MemberJoined,
+	event.MemberLeft,
+	event.ServerUpdated,
+	event.ServerDeleted,
+	event.MessageCreated,
+}
+
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	ServerID  primitive.ObjectID `bson:"server_id"`
+	URL       string             `bson:"url"`
+	Secret    string             `bson:"secret"`
+	Events    []string           `bson:"events"`
+	Enabled   bool               `bson:"enabled"`
+	Failures  int                `bson:"failures"`
+	CreatorID string             `bson:"creator_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+	// DeletedAt is set when the server is deleted, the webhook lives until the last delivery is done
+	DeletedAt *time.Time `bson:"deleted_at,omitempty"`
+}
+
+func NewWebhook(serverId primitive.ObjectID, url string, events []string, creatorId string) Webhook {
+	return Webhook{
+		ServerID:  serverId,
+		URL:       url,
+		Secret:    utils.GetRandomToken(secretSize),
+		Events:    events,
+		Enabled:   true,
+		CreatorID: creatorId,
+		CreatedAt: time.Now(),
+	}
+}
+
+// IsURLValid requires https to a public host, plain http to the local machine is allowed
+// only with WEBHOOK_ALLOW_LOCAL. Names are checked again when they are resolved at send time
+func IsURLValid(raw string) (bool, string) {
+	target, err := url.Parse(raw)
+	if err != nil || target.Host == "" {
+		return false, "URL is not valid"
+	}
+
+	host := target.Hostname()
+	ip := net.ParseIP(host)
+	isLocal := host == "localhost" || (ip != nil && ip.IsLoopback())
+	if isLocal && allowLocal {
+		return true, ""
+	}
+	if target.Scheme != "https" {
+		return false, "URL must use https"
+	}
+	if isLocal || (ip != nil && !IsPublicIP(ip)) {
+		return false, "URL must point to a public address"
+	}
+	return true, ""
+}
+
+func IsEventsValid(events []string) (bool, string) {
+	if len(events) == 0 {
+		return false, "Events are empty"
+	}
+	for _, evt := range events {
+		if !utils.Contains(Events, evt) {
+			return false, fmt.Sprintf("Event %s does not exist", evt)
+		}
+	}
+	return true, ""
+}
+
+func (w *Webhook) Wants(eventType string) bool {
+	return w.Enabled && utils.Contains(w.Events, eventType)
+}
+
+// Sign is the hex HMAC-SHA256 of "<timestamp>.<body>", receivers recompute it with the secret
+// and reject old timestamps to prevent replays
+func (w *Webhook) Sign(timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) Print() map[string]any {
+	return map[string]any{
+		"id":         w.ID,
+		"server_id":  w.ServerID,
+		"url":        w.URL,
+		"events":     w.Events,
+		"enabled":    w.Enabled,
+		"failures":   w.Failures,
+		"creator_id": w.CreatorID,
+		"created_at": w.CreatedAt,
+	}
+}
+
+// delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is one event sent to one webhook, with the outcome of its last attempt
+type Delivery struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	WebhookID     primitive.ObjectID `bson:"webhook_id"`
+	EventID       string             `bson:"event_id"`
+	Event         string             `bson:"event"`
+	Payload       string             `bson:"payload"`
+	Status        string             `bson:"status"`
+	Attempts      int                `bson:"attempts"`
+	StatusCode    int                `bson:"status_code"`
+	Error         string             `bson:"error"`
+	Duration      time.Duration      `bson:"duration"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at"`
+	LockedUntil   time.Time          `bson:"locked_until"`
+}
+
+// Backoff is the wait before the next attempt: 10s, 20s, 40s... up to an hour
+func Backoff(attempts int) time.Duration {
+	wait := 10 * time.Second << (attempts - 1)
+	if attempts > 10 || wait > time.Hour {
+		return time.Hour
+	}
+	return wait
+}
+
+func (d *Delivery) Print() map[string]any {
+	return map[string]any{
+		"id":              d.ID,
+		"webhook_id":      d.WebhookID,
+		"event":           d.Event,
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"status_code":     d.StatusCode,
+		"error":           d.Error,
+		"duration_ms":     d.Duration.Milliseconds(),
+		"created_at":      d.CreatedAt,
+		"next_attempt_at": d.NextAttemptAt,
+	}
+}
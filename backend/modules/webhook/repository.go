@@ -0,0 +1,335 @@
+package webhook
+
+import (
+	"context"
+	"harmony/internal/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultTimeout = 5 * time.Second
+
+const (
+	// deliveryRetention is how long the delivery log is kept
+	deliveryRetention = 7 * 24 * time.Hour
+	// DeliveryLogSize is how many deliveries are listed per webhook
+	DeliveryLogSize = 50
+)
+
+// Repository stores the webhooks and their deliveries, the deliveries are shared by the instances
+// through ClaimDelivery
+type Repository interface {
+	Create(webhook *Webhook) error
+	Read(id primitive.ObjectID) (*Webhook, error)
+	ReadByServer(serverId primitive.ObjectID) ([]Webhook, error)
+	Update(webhook *Webhook) error
+	Delete(id primitive.ObjectID) (bool, error)
+	MarkServerDeleted(serverId primitive.ObjectID) error
+	RecordResult(webhook *Webhook, success bool) error
+	CreateDelivery(delivery *Delivery) (bool, error)
+	ClaimDelivery(lock time.Duration) (*Delivery, error)
+	UpdateDelivery(delivery *Delivery) error
+	ReadDeliveries(webhookId primitive.ObjectID) ([]Delivery, error)
+}
+
+type MongoRepository struct {
+	db *mongo.Database
+}
+
+func NewRepository(db *mongo.Client) *MongoRepository {
+	return &MongoRepository{
+		db: db.Database("harmony"),
+	}
+}
+
+// EnsureIndexes creates the indexes of the webhooks and of their delivery log
+func (r *MongoRepository) EnsureIndexes() error {
+	cWebhooks := r.db.Collection("webhooks")
+	cDeliveries := r.db.Collection("webhook_deliveries")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	err := database.EnsureIndexes(ctx, cWebhooks, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "server_id", Value: 1}}},
+		// webhooks of deleted servers go away once their last deliveries are done
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(deliveryRetention.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = database.EnsureIndexes(ctx, cDeliveries, []mongo.IndexModel{
+		// every instance sees the events, only the first one enqueues the delivery
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(deliveryRetention.Seconds()))},
+	})
+	return err
+}
+
+func (r *MongoRepository) Create(webhook *Webhook) error {
+	cWebhooks := r.db.Collection("webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cWebhooks.InsertOne(ctx, bson.M{
+		"server_id":  webhook.ServerID,
+		"url":        webhook.URL,
+		"secret":     webhook.Secret,
+		"events":     webhook.Events,
+		"enabled":    webhook.Enabled,
+		"failures":   webhook.Failures,
+		"creator_id": webhook.CreatorID,
+		"created_at": webhook.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	webhook.ID = result.InsertedID.(primitive.ObjectID)
+
+	return nil
+}
+
+// Read returns the webhook even when its server was deleted, the pending deliveries still need it
+func (r *MongoRepository) Read(id primitive.ObjectID) (*Webhook, error) {
+	cWebhooks := r.db.Collection("webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	var webhook Webhook
+	err := cWebhooks.FindOne(ctx, bson.M{"_id": id}).Decode(&webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (r *MongoRepository) ReadByServer(serverId primitive.ObjectID) ([]Webhook, error) {
+	cWebhooks := r.db.Collection("webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	filter := bson.M{"server_id": serverId, "deleted_at": nil}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := cWebhooks.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := []Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *MongoRepository) Update(webhook *Webhook) error {
+	cWebhooks := r.db.Collection("webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"url":      webhook.URL,
+			"events":   webhook.Events,
+			"enabled":  webhook.Enabled,
+			"failures": webhook.Failures,
+		},
+	}
+	_, err := cWebhooks.UpdateByID(ctx, webhook.ID, update)
+	return err
+}
+
+func (r *MongoRepository) Delete(id primitive.ObjectID) (bool, error) {
+	cWebhooks := r.db.Collection("webhooks")
+	cDeliveries := r.db.Collection("webhook_deliveries")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cWebhooks.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	_, err = cDeliveries.DeleteMany(ctx, bson.M{"webhook_id": id})
+	if err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// MarkServerDeleted hides the webhooks of a deleted server, they are removed by the TTL index
+func (r *MongoRepository) MarkServerDeleted(serverId primitive.ObjectID) error {
+	cWebhooks := r.db.Collection("webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, err := cWebhooks.UpdateMany(ctx, bson.M{"server_id": serverId, "deleted_at": nil}, bson.M{
+		"$set": bson.M{"deleted_at": time.Now()},
+	})
+	return err
+}
+
+// RecordResult keeps count of the failed deliveries in a row and disables the webhook at MaxFailures
+func (r *MongoRepository) RecordResult(webhook *Webhook, success bool) error {
+	cWebhooks := r.db.Collection("webhooks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	if success {
+		if webhook.Failures == 0 {
+			return nil
+		}
+		_, err := cWebhooks.UpdateByID(ctx, webhook.ID, bson.M{"$set": bson.M{"failures": 0}})
+		if err != nil {
+			return err
+		}
+		webhook.Failures = 0
+		return nil
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := cWebhooks.FindOneAndUpdate(ctx, bson.M{"_id": webhook.ID}, bson.M{
+		"$inc": bson.M{"failures": 1},
+	}, opts).Decode(webhook)
+	if err != nil {
+		return err
+	}
+	if webhook.Failures < MaxFailures || !webhook.Enabled {
+		return nil
+	}
+
+	_, err = cWebhooks.UpdateByID(ctx, webhook.ID, bson.M{"$set": bson.M{"enabled": false}})
+	if err != nil {
+		return err
+	}
+	webhook.Enabled = false
+
+	return nil
+}
+
+// CreateDelivery enqueues the delivery, false means another instance already did
+func (r *MongoRepository) CreateDelivery(delivery *Delivery) (bool, error) {
+	cDeliveries := r.db.Collection("webhook_deliveries")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	result, err := cDeliveries.InsertOne(ctx, bson.M{
+		"webhook_id":      delivery.WebhookID,
+		"event_id":        delivery.EventID,
+		"event":           delivery.Event,
+		"payload":         delivery.Payload,
+		"status":          delivery.Status,
+		"attempts":        delivery.Attempts,
+		"status_code":     delivery.StatusCode,
+		"error":           delivery.Error,
+		"duration":        delivery.Duration,
+		"created_at":      delivery.CreatedAt,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"locked_until":    delivery.LockedUntil,
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+
+	return true, nil
+}
+
+// ClaimDelivery takes the next due delivery locking it for the given time so no other worker sends it
+func (r *MongoRepository) ClaimDelivery(lock time.Duration) (*Delivery, error) {
+	cDeliveries := r.db.Collection("webhook_deliveries")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"status":          DeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+		"locked_until":    bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"locked_until": now.Add(lock)},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var delivery Delivery
+	err := cDeliveries.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (r *MongoRepository) UpdateDelivery(delivery *Delivery) error {
+	cDeliveries := r.db.Collection("webhook_deliveries")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"status_code":     delivery.StatusCode,
+			"error":           delivery.Error,
+			"duration":        delivery.Duration,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"locked_until":    delivery.LockedUntil,
+		},
+	}
+	_, err := cDeliveries.UpdateByID(ctx, delivery.ID, update)
+	return err
+}
+
+// ReadDeliveries returns the last deliveries of the webhook, the newest first
+func (r *MongoRepository) ReadDeliveries(webhookId primitive.ObjectID) ([]Delivery, error) {
+	cDeliveries := r.db.Collection("webhook_deliveries")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(DeliveryLogSize)
+	cursor, err := cDeliveries.Find(ctx, bson.M{"webhook_id": webhookId}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []Delivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
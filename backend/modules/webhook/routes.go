@@ -0,0 +1,13 @@
+package webhook
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.RouterGroup, h *Handler) {
+	r.POST("/", h.Create)
+	r.GET("/", h.List)
+	r.PATCH("/:webhookId", h.Update)
+	r.DELETE("/:webhookId", h.Delete)
+	r.GET("/:webhookId/deliveries", h.ListDeliveries)
+}
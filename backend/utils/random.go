@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+)
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// GetRandomToken generate a random hex string from the given number of random bytes
+func GetRandomToken(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// GetRandomString generate a random alphanumeric string of the given length
+func GetRandomString(length int) string {
+	b := make([]byte, length)
+	max := big.NewInt(int64(len(alphanumeric)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = alphanumeric[n.Int64()]
+	}
+	return string(b)
+}
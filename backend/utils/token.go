@@ -21,7 +21,8 @@ func GetSub(c *gin.Context) (string, bool) {
 	return sub, true
 }
 
-func GetRole(c *gin.Context, serverUniqueName string) (string, bool) {
+// GetSid returns the session the access token was issued for
+func GetSid(c *gin.Context) (string, bool) {
 	claims, exists := c.Get("claims")
 	if !exists {
 		return "", false
@@ -30,71 +31,9 @@ func GetRole(c *gin.Context, serverUniqueName string) (string, bool) {
 	if !ok {
 		return "", false
 	}
-	roles, ok := mapClaims["roles"].(map[string]string)
+	sid, ok := mapClaims["sid"].(string)
 	if !ok {
 		return "", false
 	}
-	if roles == nil {
-		return "", false
-	}
-	role, exists := roles[serverUniqueName]
-	if !exists {
-		return "", false
-	}
-	return role, true
-}
-
-func IsRoleOwner(c *gin.Context, serverUniqueName string) bool {
-	role, ok := GetRole(c, serverUniqueName)
-	if !ok {
-		return false
-	}
-	if role != "owner" {
-		return false
-	}
-	return true
-}
-
-func IsRoleAdmin(c *gin.Context, serverUniqueName string) bool {
-	role, ok := GetRole(c, serverUniqueName)
-	if !ok {
-		return false
-	}
-	if role != "admin" {
-		return false
-	}
-	return true
-}
-
-func IsRoleAtLeastAdmin(c *gin.Context, serverUniqueName string) bool {
-	role, ok := GetRole(c, serverUniqueName)
-	if !ok {
-		return false
-	}
-	if role != "owner" && role != "admin" {
-		return false
-	}
-	return true
-}
-
-func IsRoleMember(c *gin.Context, serverUniqueName string) bool {
-	role, ok := GetRole(c, serverUniqueName)
-	if !ok {
-		return false
-	}
-	if role != "member" {
-		return false
-	}
-	return true
-}
-
-func IsRoleAtLeastMember(c *gin.Context, serverUniqueName string) bool {
-	role, ok := GetRole(c, serverUniqueName)
-	if !ok {
-		return false
-	}
-	if role != "owner" && role != "admin" && role != "member" {
-		return false
-	}
-	return true
+	return sid, true
 }